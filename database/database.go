@@ -4,9 +4,13 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/rs/zerolog/log"
+	"math"
 	_ "modernc.org/sqlite"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 const UpdateVersionQuery = "UPDATE version SET version = ?"
@@ -18,7 +22,9 @@ func InitDatabaseConnection(dbFile string, sqlHistoryDirectory string) (*sql.DB,
 	if !fileExists(dbFile) {
 		return nil, &DbNotFoundException{message: "Файл БД по пути " + dbFile + " не найден"}
 	}
-	sqlDB, err := sql.Open("sqlite", dbFile)
+	// Изменения выполняются в транзакциях, поэтому другие соединения пула ждут снятия блокировки, а не получают
+	// SQLITE_BUSY
+	sqlDB, err := sql.Open("sqlite", dbFile+"?_pragma=busy_timeout(5000)")
 	if err == nil {
 		err = sqlDB.Ping()
 	}
@@ -31,6 +37,10 @@ func InitDatabaseConnection(dbFile string, sqlHistoryDirectory string) (*sql.DB,
 	if err != nil {
 		return nil, err
 	}
+	// Номер версии - индекс файла, поэтому 10.sql должен идти после 9.sql, а не после 1.sql
+	sort.SliceStable(files, func(i, j int) bool {
+		return migrationNumber(files[i].Name()) < migrationNumber(files[j].Name())
+	})
 
 	log.Info().Msg("Соединение с базой данных успешно установлено")
 
@@ -77,6 +87,27 @@ func InitDatabaseConnection(dbFile string, sqlHistoryDirectory string) (*sql.DB,
 	return sqlDB, err
 }
 
+// InTransaction выполняет action в транзакции и фиксирует её, если action не вернула ошибку
+func InTransaction(db *sql.DB, action func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = action(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrationNumber(fileName string) int {
+	number, err := strconv.Atoi(strings.TrimSuffix(fileName, filepath.Ext(fileName)))
+	if err != nil {
+		return math.MaxInt
+	}
+	return number
+}
+
 func fileExists(filename string) bool {
 	_, err := os.Stat(filename)
 	return !os.IsNotExist(err)
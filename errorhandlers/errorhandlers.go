@@ -1,23 +1,32 @@
 package errorhandlers
 
 import (
+	"context"
+	"errors"
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
 	"modernc.org/sqlite"
 	sqlite3 "modernc.org/sqlite/lib"
 	"reflect"
+	"unimock/history"
+	"unimock/proxy"
 	"unimock/scenarios"
+	"unimock/states"
 	"unimock/templates"
 	"unimock/triggers"
 	"unimock/util"
 )
 
+// contextCanceled - ошибка прерванной задержки, параметр HandleError затеняет пакет context
+var contextCanceled = context.Canceled
+
 type ExceptionResponse struct {
-	Message string
+	Message    string
+	NearMisses []*triggers.NearMiss `json:",omitempty"`
 }
 
 func NewExceptionResponse(message string) *ExceptionResponse {
-	return &ExceptionResponse{message}
+	return &ExceptionResponse{Message: message}
 }
 
 func (response *ExceptionResponse) setMessage(message string) {
@@ -30,18 +39,59 @@ func HandleErrorStatus(context *fiber.Ctx, status int, err error) error {
 	return context.Status(status).JSON(resp)
 }
 
+// StatusClientClosedRequest - статус запроса, клиент которого отключился до ответа, ответ ему не доставляется
+const StatusClientClosedRequest = 499
+
 func HandleError(context *fiber.Ctx, err error) error {
+	if errors.Is(err, contextCanceled) {
+		log.Info().Msg("Клиент отключился до отправки ответа")
+		return context.SendStatus(StatusClientClosedRequest)
+	}
 	switch v := err.(type) {
 	case *templates.TemplateValidationException:
 		return HandleErrorStatus(context, fiber.StatusBadRequest, err)
 	case *templates.TemplateNotFoundException:
 		return HandleErrorStatus(context, fiber.StatusNotFound, err)
+	case *templates.ExtractorNotFoundException:
+		return HandleErrorStatus(context, fiber.StatusNotFound, err)
 	case *triggers.TriggerValidationException:
 		return HandleErrorStatus(context, fiber.StatusBadRequest, err)
 	case *triggers.TriggerNotFoundException:
+		if len(v.NearMisses()) > 0 {
+			log.Error().Err(err).Msg("")
+			resp := NewExceptionResponse(err.Error())
+			resp.NearMisses = v.NearMisses()
+			return context.Status(fiber.StatusNotFound).JSON(resp)
+		}
 		return HandleErrorStatus(context, fiber.StatusNotFound, err)
 	case *scenarios.StepValidationException:
 		return HandleErrorStatus(context, fiber.StatusBadRequest, err)
+	case *scenarios.StepNotFoundException:
+		return HandleErrorStatus(context, fiber.StatusNotFound, err)
+	case *scenarios.ScriptException:
+		return HandleErrorStatus(context, fiber.StatusUnprocessableEntity, err)
+	case *scenarios.ConversionException:
+		return HandleErrorStatus(context, fiber.StatusUnprocessableEntity, err)
+	case *scenarios.SequenceExhaustedException:
+		return HandleErrorStatus(context, fiber.StatusGone, err)
+	case *scenarios.CallbackException:
+		return HandleErrorStatus(context, fiber.StatusBadGateway, err)
+	case *scenarios.FaultException:
+		return HandleErrorStatus(context, v.StatusCode(), err)
+	case *proxy.UpstreamNotConfiguredException:
+		return HandleErrorStatus(context, fiber.StatusNotFound, err)
+	case *proxy.UpstreamException:
+		return HandleErrorStatus(context, fiber.StatusBadGateway, err)
+	case *states.MachineValidationException:
+		return HandleErrorStatus(context, fiber.StatusBadRequest, err)
+	case *states.MachineNotFoundException:
+		return HandleErrorStatus(context, fiber.StatusNotFound, err)
+	case *states.MachineInUseException:
+		return HandleErrorStatus(context, fiber.StatusConflict, err)
+	case *states.StateKeyNotFoundException:
+		return HandleErrorStatus(context, fiber.StatusBadRequest, err)
+	case *history.VersionNotFoundException:
+		return HandleErrorStatus(context, fiber.StatusNotFound, err)
 	case *util.ParamValidationException:
 		return HandleErrorStatus(context, fiber.StatusBadRequest, err)
 	case *sqlite.Error:
@@ -0,0 +1,91 @@
+package history
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"unimock/util"
+)
+
+// RollbackFunc восстанавливает объект id из версии version от имени author
+type RollbackFunc func(id int64, version int64, author string) error
+
+// VersionHandler - общие для шаблонов, триггеров и шагов сценариев маршруты истории /:id/versions
+type VersionHandler struct {
+	history  *History
+	rollback RollbackFunc
+}
+
+func NewHandler(history *History, rollback RollbackFunc) *VersionHandler {
+	return &VersionHandler{
+		history:  history,
+		rollback: rollback,
+	}
+}
+
+func (handler *VersionHandler) GetVersions(context *fiber.Ctx) error {
+	id, err := strconv.ParseInt(context.Params("id"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("id", err)
+	}
+
+	versions, err := handler.history.GetVersions(id)
+	if err != nil {
+		return err
+	}
+	return context.JSON(versions)
+}
+
+func (handler *VersionHandler) GetVersion(context *fiber.Ctx) error {
+	id, version, err := parseVersionParams(context)
+	if err != nil {
+		return err
+	}
+
+	result, err := handler.history.GetVersion(id, version)
+	if err != nil {
+		return err
+	}
+	return context.JSON(result)
+}
+
+// DiffVersions возвращает unified diff версий из параметров запроса from и to
+func (handler *VersionHandler) DiffVersions(context *fiber.Ctx) error {
+	id, err := strconv.ParseInt(context.Params("id"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("id", err)
+	}
+	from, err := strconv.ParseInt(context.Query("from"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("from", err)
+	}
+	to, err := strconv.ParseInt(context.Query("to"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("to", err)
+	}
+
+	diff, err := handler.history.Diff(id, from, to)
+	if err != nil {
+		return err
+	}
+	return context.SendString(diff)
+}
+
+func (handler *VersionHandler) Rollback(context *fiber.Ctx) error {
+	id, version, err := parseVersionParams(context)
+	if err != nil {
+		return err
+	}
+	return handler.rollback(id, version, util.GetAuthor(context))
+}
+
+func parseVersionParams(context *fiber.Ctx) (int64, int64, error) {
+	id, err := strconv.ParseInt(context.Params("id"), 10, 64)
+	if err != nil {
+		return 0, 0, util.CreateParamValidationException("id", err)
+	}
+	version, err := strconv.ParseInt(context.Params("version"), 10, 64)
+	if err != nil {
+		return 0, 0, util.CreateParamValidationException("version", err)
+	}
+	return id, version, nil
+}
@@ -0,0 +1,9 @@
+package history
+
+type VersionNotFoundException struct {
+	message string
+}
+
+func (e *VersionNotFoundException) Error() string {
+	return e.message
+}
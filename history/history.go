@@ -0,0 +1,171 @@
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/pmezard/go-difflib/difflib"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Таблицы истории изменений шаблонов, триггеров и шагов сценариев
+const (
+	TemplateVersionsTable     = "template_versions"
+	TriggerVersionsTable      = "trigger_versions"
+	ScenarioStepVersionsTable = "scenario_step_versions"
+)
+
+const insertQuery = "INSERT INTO %[1]s (entity_id, version, operation, author, created_at, snapshot) " +
+	"SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ?, ? FROM %[1]s WHERE entity_id = ?"
+const selectByEntityIdQuery = "SELECT version, operation, author, created_at FROM %s WHERE entity_id = ? ORDER BY version"
+const selectVersionQuery = "SELECT operation, author, created_at, snapshot FROM %s WHERE entity_id = ? AND version = ?"
+
+type Operation string
+
+const (
+	Created    Operation = "create"
+	Updated    Operation = "update"
+	Deleted    Operation = "delete"
+	RolledBack Operation = "rollback"
+)
+
+// Version - сохранённое состояние объекта после изменения. Snapshot - объект в JSON, как его возвращает API
+type Version struct {
+	EntityId  int64           `json:"entity_id"`
+	Version   int64           `json:"version"`
+	Operation Operation       `json:"operation"`
+	Author    string          `json:"author"`
+	CreatedAt time.Time       `json:"created_at"`
+	Snapshot  json.RawMessage `json:"snapshot,omitempty"`
+}
+
+// History хранит версии объектов одного вида в таблице table
+type History struct {
+	db    *sql.DB
+	table string
+}
+
+func NewHistory(db *sql.DB, table string) *History {
+	return &History{db: db, table: table}
+}
+
+// Record сохраняет новую версию объекта entityId в транзакции tx, в которой изменяется сам объект
+func (history *History) Record(tx *sql.Tx, entityId int64, operation Operation, author string, entity interface{}) error {
+	snapshot, err := json.Marshal(entity)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(insertQuery, history.table),
+		entityId, operation, author, time.Now().UnixNano(), string(snapshot), entityId)
+	return err
+}
+
+// GetVersions возвращает версии объекта по возрастанию без снимков
+func (history *History) GetVersions(entityId int64) ([]*Version, error) {
+	rows, err := history.db.Query(fmt.Sprintf(selectByEntityIdQuery, history.table), entityId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]*Version, 0)
+	for rows.Next() {
+		version := &Version{EntityId: entityId}
+		var createdAt int64
+		if err = rows.Scan(&version.Version, &version.Operation, &version.Author, &createdAt); err != nil {
+			return nil, err
+		}
+		version.CreatedAt = time.Unix(0, createdAt)
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+func (history *History) GetVersion(entityId int64, versionNumber int64) (*Version, error) {
+	version := &Version{EntityId: entityId, Version: versionNumber}
+	var createdAt int64
+	var snapshot string
+	err := history.db.QueryRow(fmt.Sprintf(selectVersionQuery, history.table), entityId, versionNumber).
+		Scan(&version.Operation, &version.Author, &createdAt, &snapshot)
+	if err == sql.ErrNoRows {
+		return nil, &VersionNotFoundException{
+			message: fmt.Sprintf("Версия %d объекта с id = %d не найдена", versionNumber, entityId),
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	version.CreatedAt = time.Unix(0, createdAt)
+	version.Snapshot = json.RawMessage(snapshot)
+	return version, nil
+}
+
+// GetLatestVersion возвращает последнюю версию объекта со снимком
+func (history *History) GetLatestVersion(entityId int64) (*Version, error) {
+	versions, err := history.GetVersions(entityId)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, &VersionNotFoundException{message: fmt.Sprintf("Версии объекта с id = %d не найдены", entityId)}
+	}
+	return history.GetVersion(entityId, versions[len(versions)-1].Version)
+}
+
+// Diff возвращает unified diff снимков двух версий объекта
+func (history *History) Diff(entityId int64, from int64, to int64) (string, error) {
+	fromVersion, err := history.GetVersion(entityId, from)
+	if err != nil {
+		return "", err
+	}
+	toVersion, err := history.GetVersion(entityId, to)
+	if err != nil {
+		return "", err
+	}
+
+	fromText, err := snapshotText(fromVersion.Snapshot)
+	if err != nil {
+		return "", err
+	}
+	toText, err := snapshotText(toVersion.Snapshot)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(strings.TrimSuffix(fromText, "\n")),
+		B:        difflib.SplitLines(strings.TrimSuffix(toText, "\n")),
+		FromFile: fmt.Sprintf("version %d", from),
+		ToFile:   fmt.Sprintf("version %d", to),
+		Context:  3,
+	})
+}
+
+// snapshotText выводит поля снимка по одному в строке, строковые значения без экранирования, чтобы
+// изменения многострочного тела шаблона были видны построчно
+func snapshotText(snapshot json.RawMessage) (string, error) {
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(snapshot, &fields); err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var text strings.Builder
+	for _, key := range keys {
+		value, ok := fields[key].(string)
+		if !ok {
+			encoded, err := json.Marshal(fields[key])
+			if err != nil {
+				return "", err
+			}
+			value = string(encoded)
+		}
+		text.WriteString(key + ": " + value + "\n")
+	}
+	return text.String(), nil
+}
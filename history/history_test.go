@@ -0,0 +1,66 @@
+package history
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"unimock/database"
+)
+
+type entity struct {
+	Name string `json:"name"`
+	Body string `json:"body"`
+}
+
+func newTestHistory(t *testing.T) *History {
+	dbFile := filepath.Join(t.TempDir(), "unimock.db")
+	require.NoError(t, os.WriteFile(dbFile, nil, 0o644))
+
+	db, err := database.InitDatabaseConnection(dbFile, "../sql")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return NewHistory(db, TemplateVersionsTable)
+}
+
+func TestHistory(t *testing.T) {
+	history := newTestHistory(t)
+	record := func(entityId int64, operation Operation, author string, value *entity) error {
+		return database.InTransaction(history.db, func(tx *sql.Tx) error {
+			return history.Record(tx, entityId, operation, author, value)
+		})
+	}
+	require.NoError(t, record(1, Created, "alice", &entity{Name: "a", Body: "line1\nline2\nline3"}))
+	require.NoError(t, record(2, Created, "bob", &entity{Name: "b"}))
+	require.NoError(t, record(1, Updated, "bob", &entity{Name: "a", Body: "line1\nchanged\nline3"}))
+
+	versions, err := history.GetVersions(1)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, int64(2), versions[1].Version)
+	require.Equal(t, Updated, versions[1].Operation)
+	require.Equal(t, "bob", versions[1].Author)
+	require.Nil(t, versions[1].Snapshot)
+
+	version, err := history.GetVersion(1, 1)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"name": "a", "body": "line1\nline2\nline3"}`, string(version.Snapshot))
+	require.Equal(t, "alice", version.Author)
+
+	diff, err := history.Diff(1, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, "--- version 1\n+++ version 2\n@@ -1,4 +1,4 @@\n body: line1\n-line2\n+changed\n line3\n name: a\n", diff)
+
+	latest, err := history.GetLatestVersion(1)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), latest.Version)
+	require.NotNil(t, latest.Snapshot)
+
+	_, err = history.GetVersion(1, 3)
+	require.IsType(t, &VersionNotFoundException{}, err)
+	_, err = history.GetLatestVersion(3)
+	require.IsType(t, &VersionNotFoundException{}, err)
+	_, err = history.Diff(2, 1, 2)
+	require.IsType(t, &VersionNotFoundException{}, err)
+}
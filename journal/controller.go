@@ -0,0 +1,82 @@
+package journal
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"time"
+	"unimock/util"
+)
+
+const unmatchedParamValue = "unmatched"
+
+type JournalHandler struct {
+	journal *Journal
+}
+
+func NewHandler(journal *Journal) *JournalHandler {
+	return &JournalHandler{
+		journal: journal,
+	}
+}
+
+func (handler *JournalHandler) GetRequests(context *fiber.Ctx) error {
+	filter, err := ParseFilter(context)
+	if err != nil {
+		return err
+	}
+
+	return context.JSON(handler.journal.Find(filter))
+}
+
+func (handler *JournalHandler) DeleteRequests(context *fiber.Ctx) error {
+	return handler.journal.Clear()
+}
+
+// ParseFilter читает фильтр журнала из параметров запроса triggerId, subsystem, from, to, contains, outbound и limit
+func ParseFilter(context *fiber.Ctx) (*Filter, error) {
+	filter := &Filter{
+		Subsystem:    context.Query("subsystem"),
+		BodyContains: context.Query("contains"),
+	}
+
+	if triggerIdParam := context.Query("triggerId"); triggerIdParam != "" {
+		triggerId := UnmatchedTriggerId
+		if triggerIdParam != unmatchedParamValue {
+			var err error
+			triggerId, err = strconv.ParseInt(triggerIdParam, 10, 64)
+			if err != nil {
+				return nil, util.CreateParamValidationException("triggerId", err)
+			}
+		}
+		filter.TriggerId = &triggerId
+	}
+
+	var err error
+	if from := context.Query("from"); from != "" {
+		filter.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, util.CreateParamValidationException("from", err)
+		}
+	}
+	if to := context.Query("to"); to != "" {
+		filter.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, util.CreateParamValidationException("to", err)
+		}
+	}
+	if outboundParam := context.Query("outbound"); outboundParam != "" {
+		outbound, err := strconv.ParseBool(outboundParam)
+		if err != nil {
+			return nil, util.CreateParamValidationException("outbound", err)
+		}
+		filter.Outbound = &outbound
+	}
+	if limit := context.Query("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return nil, util.CreateParamValidationException("limit", err)
+		}
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,317 @@
+package journal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"sync"
+	"time"
+	"unimock/database"
+	"unimock/util"
+)
+
+const InsertQuery = "INSERT INTO request_journal (id, timestamp, trigger_id, subsystem, duration_ms, request, response, error, proxied, outbound, near_misses) VALUES (?,?,?,?,?,?,?,?,?,?,?)"
+const SelectLastQuery = "SELECT id, timestamp, trigger_id, subsystem, duration_ms, request, response, error, proxied, outbound, near_misses FROM request_journal ORDER BY id DESC LIMIT ?"
+const DeleteAllQuery = "DELETE FROM request_journal"
+const DeleteOldQuery = "DELETE FROM request_journal WHERE id <= ?"
+
+// UnmatchedTriggerId - id триггера в записи журнала о сообщении, для которого триггер не найден
+const UnmatchedTriggerId int64 = 0
+
+const persistQueueSize = 1024
+
+// persistBatchSize - наибольшее число записей, сохраняемых в БД одной транзакцией
+const persistBatchSize = 100
+
+type Record struct {
+	Id         int64         `json:"id"`
+	Timestamp  time.Time     `json:"timestamp"`
+	TriggerId  int64         `json:"trigger_id"`
+	Subsystem  string        `json:"subsystem"`
+	DurationMs float64       `json:"duration_ms"`
+	Request    *util.Message `json:"request"`
+	Response   *util.Message `json:"response"`
+	Error      string        `json:"error"`
+	// Proxied - ответ получен от вышестоящего сервиса в режиме прокси
+	Proxied bool `json:"proxied"`
+	// Outbound - запись об исходящем запросе unimock, например callback-шаге сценария
+	Outbound bool `json:"outbound"`
+	// NearMisses - ближайшие к сообщению без триггера триггеры на момент его получения, в JSON в формате
+	// пакета triggers
+	NearMisses json.RawMessage `json:"near_misses,omitempty"`
+	// epoch - номер очистки журнала, после которой добавлена запись. Записи, ожидавшие сохранения во время
+	// очистки, в БД не сохраняются
+	epoch int64
+}
+
+func (record *Record) IsMatched() bool {
+	return record.TriggerId != UnmatchedTriggerId
+}
+
+// Filter - условия отбора записей журнала, пустые поля не ограничивают выборку
+type Filter struct {
+	TriggerId    *int64
+	Subsystem    string
+	From         time.Time
+	To           time.Time
+	BodyContains string
+	// Outbound - отбор только исходящих (true) или только входящих (false) запросов
+	Outbound *bool
+	Limit    int
+}
+
+func (filter *Filter) match(record *Record) bool {
+	if filter.TriggerId != nil && *filter.TriggerId != record.TriggerId {
+		return false
+	}
+	if filter.Subsystem != "" && filter.Subsystem != record.Subsystem {
+		return false
+	}
+	if !filter.From.IsZero() && record.Timestamp.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && record.Timestamp.After(filter.To) {
+		return false
+	}
+	if filter.Outbound != nil && *filter.Outbound != record.Outbound {
+		return false
+	}
+	if filter.BodyContains != "" && !strings.Contains(record.Request.Body, filter.BodyContains) {
+		return false
+	}
+	return true
+}
+
+// Journal хранит последние capacity записей в кольцевом буфере и, если передана БД, сохраняет их в таблицу
+// request_journal в фоновом режиме. В таблице остаются только последние capacity записей
+type Journal struct {
+	records []*Record
+	next    int
+	count   int
+	lastId  int64
+	epoch   int64
+	mut     sync.RWMutex
+	db      *sql.DB
+	queue   chan *Record
+	// persistMut упорядочивает сохранение записей с очисткой таблицы
+	persistMut sync.Mutex
+	persisted  chan struct{}
+}
+
+func NewJournal(capacity int, db *sql.DB) *Journal {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	journal := &Journal{
+		records: make([]*Record, capacity),
+		db:      db,
+	}
+	if db != nil {
+		journal.queue = make(chan *Record, persistQueueSize)
+		journal.persisted = make(chan struct{})
+		go journal.persist(journal.queue)
+	}
+	return journal
+}
+
+// Close сохраняет в БД записи из очереди и останавливает сохранение, записи после закрытия хранятся только в памяти
+func (journal *Journal) Close() {
+	journal.mut.Lock()
+	queue := journal.queue
+	journal.queue = nil
+	journal.mut.Unlock()
+
+	if queue != nil {
+		close(queue)
+		<-journal.persisted
+	}
+}
+
+// Record добавляет запись в журнал, присваивая ей id
+func (journal *Journal) Record(record *Record) {
+	journal.mut.Lock()
+	defer journal.mut.Unlock()
+	journal.lastId++
+	record.Id = journal.lastId
+	record.epoch = journal.epoch
+	journal.put(record)
+
+	if journal.queue != nil {
+		select {
+		case journal.queue <- record:
+		default:
+			log.Warn().Int64("recordId", record.Id).Msg("Очередь сохранения журнала переполнена, запись не сохранена в БД")
+		}
+	}
+}
+
+func (journal *Journal) put(record *Record) {
+	journal.records[journal.next] = record
+	journal.next = (journal.next + 1) % len(journal.records)
+	if journal.count < len(journal.records) {
+		journal.count++
+	}
+}
+
+// Find возвращает подходящие под фильтр записи, начиная с самой новой
+func (journal *Journal) Find(filter *Filter) []*Record {
+	journal.mut.RLock()
+	defer journal.mut.RUnlock()
+
+	result := make([]*Record, 0)
+	for i := 1; i <= journal.count; i++ {
+		record := journal.records[(journal.next-i+len(journal.records))%len(journal.records)]
+		if !filter.match(record) {
+			continue
+		}
+		result = append(result, record)
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			break
+		}
+	}
+	return result
+}
+
+func (journal *Journal) Clear() error {
+	journal.persistMut.Lock()
+	defer journal.persistMut.Unlock()
+	journal.mut.Lock()
+	defer journal.mut.Unlock()
+
+	journal.epoch++
+	if journal.db != nil {
+		if _, err := journal.db.Exec(DeleteAllQuery); err != nil {
+			return err
+		}
+	}
+
+	journal.records = make([]*Record, len(journal.records))
+	journal.next = 0
+	journal.count = 0
+	return nil
+}
+
+// persist сохраняет записи из очереди пачками до persistBatchSize записей
+func (journal *Journal) persist(queue <-chan *Record) {
+	defer close(journal.persisted)
+	for record := range queue {
+		batch := []*Record{record}
+	collect:
+		for len(batch) < persistBatchSize {
+			select {
+			case record, ok := <-queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, record)
+			default:
+				break collect
+			}
+		}
+		if err := journal.save(batch); err != nil {
+			log.Error().Err(err).Int("count", len(batch)).Msg("Ошибка при сохранении записей журнала")
+		}
+	}
+}
+
+// save сохраняет записи одной транзакцией и удаляет из таблицы записи, вытесненные из буфера. Записи,
+// добавленные до последней очистки журнала, пропускаются
+func (journal *Journal) save(batch []*Record) error {
+	journal.persistMut.Lock()
+	defer journal.persistMut.Unlock()
+	journal.mut.RLock()
+	epoch, capacity := journal.epoch, len(journal.records)
+	journal.mut.RUnlock()
+
+	return database.InTransaction(journal.db, func(tx *sql.Tx) error {
+		var lastId int64
+		for _, record := range batch {
+			if record.epoch != epoch {
+				continue
+			}
+			request, err := json.Marshal(record.Request)
+			if err != nil {
+				log.Error().Err(err).Msg("Ошибка при сериализации запроса для журнала")
+				continue
+			}
+
+			var response []byte
+			if record.Response != nil {
+				response, err = json.Marshal(record.Response)
+				if err != nil {
+					log.Error().Err(err).Msg("Ошибка при сериализации ответа для журнала")
+					continue
+				}
+			}
+
+			_, err = tx.Exec(InsertQuery, record.Id, record.Timestamp.UnixNano(), record.TriggerId, record.Subsystem,
+				record.DurationMs, string(request), nullableString(response), record.Error, record.Proxied,
+				record.Outbound, nullableString(record.NearMisses))
+			if err != nil {
+				return err
+			}
+			lastId = record.Id
+		}
+		if lastId == 0 {
+			return nil
+		}
+		_, err := tx.Exec(DeleteOldQuery, lastId-int64(capacity))
+		return err
+	})
+}
+
+func nullableString(value []byte) sql.NullString {
+	return sql.NullString{String: string(value), Valid: value != nil}
+}
+
+// UpdateFromDb загружает в буфер последние сохранённые записи
+func (journal *Journal) UpdateFromDb() error {
+	if journal.db == nil {
+		return nil
+	}
+
+	rows, err := journal.db.Query(SelectLastQuery, len(journal.records))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	records := make([]*Record, 0)
+	for rows.Next() {
+		var record Record
+		var timestamp int64
+		var request string
+		var response, nearMisses sql.NullString
+		err = rows.Scan(&record.Id, &timestamp, &record.TriggerId, &record.Subsystem, &record.DurationMs,
+			&request, &response, &record.Error, &record.Proxied, &record.Outbound, &nearMisses)
+		if err != nil {
+			return err
+		}
+
+		record.Timestamp = time.Unix(0, timestamp)
+		if err = json.Unmarshal([]byte(request), &record.Request); err != nil {
+			return err
+		}
+		if response.Valid {
+			if err = json.Unmarshal([]byte(response.String), &record.Response); err != nil {
+				return err
+			}
+		}
+		if nearMisses.Valid {
+			record.NearMisses = json.RawMessage(nearMisses.String)
+		}
+		records = append(records, &record)
+	}
+
+	journal.mut.Lock()
+	defer journal.mut.Unlock()
+
+	for i := len(records) - 1; i >= 0; i-- {
+		journal.put(records[i])
+		if records[i].Id > journal.lastId {
+			journal.lastId = records[i].Id
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package journal
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unimock/database"
+	"unimock/util"
+)
+
+func TestJournalKeepsLastRecords(t *testing.T) {
+	journal := NewJournal(3, nil)
+	for _, body := range []string{"a", "b", "c", "d"} {
+		journal.Record(&Record{Timestamp: time.Now(), Request: &util.Message{Body: body}})
+	}
+
+	records := journal.Find(&Filter{})
+	require.Len(t, records, 3)
+	require.Equal(t, "d", records[0].Request.Body)
+	require.Equal(t, "b", records[2].Request.Body)
+	require.Equal(t, int64(4), records[0].Id)
+}
+
+func TestJournalFilter(t *testing.T) {
+	journal := NewJournal(10, nil)
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	journal.Record(&Record{Timestamp: start, TriggerId: 1, Subsystem: "billing", Request: &util.Message{Body: "order 1"}})
+	journal.Record(&Record{Timestamp: start.Add(time.Hour), TriggerId: 2, Request: &util.Message{Body: "order 2"}})
+	journal.Record(&Record{Timestamp: start.Add(2 * time.Hour), Request: &util.Message{Body: "payment"}})
+
+	triggerId := int64(1)
+	require.Len(t, journal.Find(&Filter{TriggerId: &triggerId}), 1)
+	unmatched := UnmatchedTriggerId
+	require.Equal(t, "payment", journal.Find(&Filter{TriggerId: &unmatched})[0].Request.Body)
+	require.Len(t, journal.Find(&Filter{Subsystem: "billing"}), 1)
+	require.Len(t, journal.Find(&Filter{BodyContains: "order"}), 2)
+	require.Len(t, journal.Find(&Filter{From: start.Add(30 * time.Minute), To: start.Add(90 * time.Minute)}), 1)
+	require.Len(t, journal.Find(&Filter{Limit: 2}), 2)
+
+	require.NoError(t, journal.Clear())
+	require.Empty(t, journal.Find(&Filter{}))
+}
+
+func TestJournalPersistence(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "unimock.db")
+	require.NoError(t, os.WriteFile(dbFile, nil, 0o644))
+	db, err := database.InitDatabaseConnection(dbFile, "../sql")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	journal := NewJournal(3, db)
+	for _, body := range []string{"a", "b"} {
+		journal.Record(&Record{Timestamp: time.Now(), Request: &util.Message{Body: body}})
+	}
+	require.NoError(t, journal.Clear())
+	for _, body := range []string{"c", "d", "e"} {
+		journal.Record(&Record{Timestamp: time.Now(), Request: &util.Message{Body: body}})
+	}
+	journal.Record(&Record{Timestamp: time.Now(), Request: &util.Message{Body: "f"},
+		NearMisses: json.RawMessage(`[{"trigger_id":1}]`)})
+	journal.Close()
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM request_journal").Scan(&count))
+	require.Equal(t, 3, count, "В таблице остаются только последние capacity записей")
+
+	reloaded := NewJournal(10, nil)
+	reloaded.db = db
+	require.NoError(t, reloaded.UpdateFromDb())
+	bodies := make([]string, 0)
+	for _, record := range reloaded.Find(&Filter{}) {
+		bodies = append(bodies, record.Request.Body)
+	}
+	require.Equal(t, []string{"f", "e", "d"}, bodies, "Записи, ожидавшие сохранения при очистке, не возвращаются")
+	require.JSONEq(t, `[{"trigger_id":1}]`, string(reloaded.Find(&Filter{})[0].NearMisses))
+}
@@ -5,13 +5,20 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 	"unimock/database"
 	"unimock/errorhandlers"
+	"unimock/history"
+	"unimock/journal"
+	"unimock/proxy"
 	"unimock/scenarios"
+	"unimock/states"
 	"unimock/templates"
 	"unimock/triggers"
+	"unimock/util"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -29,7 +36,6 @@ func main() {
 	err := viper.ReadInConfig()
 	if err != nil {
 		panic(fmt.Errorf("Не удалось инициализировать конфигурацию\n %w", err))
-		return
 	}
 
 	embeddedMonitor := viper.GetBool("monitoring.embedded")
@@ -58,38 +64,73 @@ func main() {
 	}))
 
 	templateService := templates.NewService(sqlDB)
+	viper.SetDefault("templates.files_directory", "./files")
+	templateService.SetFilesDirectory(viper.GetString("templates.files_directory"))
 	err = templateService.UpdateFromDb()
 	if err != nil {
 		log.Fatal().Err(err).Msg("")
 		return
 	}
 
-	scenarioService := scenarios.NewService(sqlDB, templateService)
+	stateService := states.NewService(sqlDB)
+	err = stateService.UpdateFromDb()
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+		return
+	}
+
+	scenarioService := scenarios.NewService(sqlDB, templateService, stateService, triggers.NewMessageMatcher)
 	err = scenarioService.UpdateFromDb()
 	if err != nil {
 		log.Fatal().Err(err).Msg("")
 		return
 	}
 
-	triggerService := triggers.NewService(sqlDB, scenarioService)
+	triggerService := triggers.NewService(sqlDB, scenarioService, stateService)
 	err = triggerService.UpdateFromDb()
 	if err != nil {
 		log.Fatal().Err(err).Msg("")
 		return
 	}
 
-	triggerHandler := triggers.NewHandler(triggerService)
+	requestJournal := initJournal(sqlDB)
+	err = requestJournal.UpdateFromDb()
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+		return
+	}
+	scenarioService.SetJournal(requestJournal)
+
+	viper.SetDefault("diagnostics.near_misses", 0)
+	triggerHandler := triggers.NewHandler(triggerService, requestJournal, viper.GetInt("diagnostics.near_misses"))
+	responseHooks := util.NewResponseHooks()
+	responseHooks.Install(app.Server())
+	triggerHandler.SetResponseHooks(responseHooks)
+	err = setupProxy(triggerHandler, triggerService, scenarioService, templateService)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+		return
+	}
 	templateHandler := templates.NewHandler(templateService)
 	scenarioHandler := scenarios.NewHandler(scenarioService)
+	journalHandler := journal.NewHandler(requestJournal)
+	stateHandler := states.NewHandler(stateService)
+
+	viper.SetDefault("history.author_header", "X-Unimock-Author")
+	templateVersionHandler := history.NewHandler(templateService.Versions(), templateService.RollbackTemplate)
+	triggerVersionHandler := history.NewHandler(triggerService.Versions(), triggerService.RollbackTrigger)
+	stepVersionHandler := history.NewHandler(scenarioService.Versions(), scenarioService.RollbackStep)
 
 	api := app.Group("/api")
-	api.Use(Middleware())
+	api.Use(Middleware(viper.GetString("history.author_header")))
 	triggersController := api.Group("/triggers")
 	triggersController.Get("", triggerHandler.GetTriggers)
 	triggersController.Post("", triggerHandler.AddTrigger)
+	triggersController.Get("/evaluation-order", triggerHandler.GetTriggersInEvaluationOrder)
 	triggersController.Get("/:id", triggerHandler.GetTriggerById)
 	triggersController.Put("/:id", triggerHandler.UpdateTrigger)
 	triggersController.Delete("/:id", triggerHandler.DeleteTrigger)
+	setupVersionRoutes(triggersController, triggerVersionHandler)
 
 	templateController := api.Group("/templates")
 	templateController.Get("", templateHandler.GetTemplates)
@@ -98,12 +139,39 @@ func main() {
 	templateController.All("/:id/process*", templateHandler.ProcessSpecificTemplate)
 	templateController.Put("/:id", templateHandler.UpdateTemplate)
 	templateController.Delete("/:id", templateHandler.DeleteTemplate)
+	templateController.Get("/:id/extractors", templateHandler.GetExtractors)
+	templateController.Post("/:id/extractors", templateHandler.AddExtractor)
+	templateController.Put("/:id/extractors/:extractorId", templateHandler.UpdateExtractor)
+	templateController.Delete("/:id/extractors/:extractorId", templateHandler.DeleteExtractor)
+	setupVersionRoutes(templateController, templateVersionHandler)
 
 	scenarioController := api.Group("/steps")
 	scenarioController.Get("/field/triggerId/:triggerId", scenarioHandler.GetOrderedStepsByTriggerId)
 	scenarioController.Post("", scenarioHandler.AddStep)
 	scenarioController.Put("/:id", scenarioHandler.UpdateStep)
 	scenarioController.Put("/field/triggerId/:triggerId", scenarioHandler.UpdateStepsForTrigger)
+	scenarioController.Delete("/:id/sequence", scenarioHandler.ResetSequence)
+	setupVersionRoutes(scenarioController, stepVersionHandler)
+
+	journalController := api.Group("/requests")
+	journalController.Get("", journalHandler.GetRequests)
+	journalController.Get("/unmatched", triggerHandler.GetUnmatchedRequests)
+	journalController.Delete("", journalHandler.DeleteRequests)
+	journalController.Post("/verify", triggerHandler.VerifyRequests)
+
+	stateMachineController := api.Group("/state-machines")
+	stateMachineController.Get("", stateHandler.GetMachines)
+	stateMachineController.Post("", stateHandler.AddMachine)
+	stateMachineController.Get("/:id", stateHandler.GetMachineById)
+	stateMachineController.Put("/:id", stateHandler.UpdateMachine)
+	stateMachineController.Delete("/:id", stateHandler.DeleteMachine)
+
+	stateController := api.Group("/states")
+	stateController.Delete("", stateHandler.ResetAllStates)
+	stateController.Get("/:name", stateHandler.GetStates)
+	stateController.Delete("/:name", stateHandler.ResetStates)
+	stateController.Put("/:name/:key", stateHandler.PutState)
+	stateController.Delete("/:name/:key", stateHandler.ResetStates)
 
 	api.All("/http/process*", triggerHandler.ProcessMessage)
 
@@ -119,7 +187,24 @@ func main() {
 		app.Get("/monitor", monitor.New(monitor.Config{Title: "Unimock Metrics Page"}))
 	}
 
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Info().Msg("Остановка сервера")
+		if err := app.Shutdown(); err != nil {
+			log.Error().Err(err).Msg("")
+		}
+	}()
+
 	startServer(app)
+
+	viper.SetDefault("callbacks.shutdown_timeout", 5*time.Second)
+	scenarioService.Shutdown(viper.GetDuration("callbacks.shutdown_timeout"))
+	if err := templateService.Shutdown(); err != nil {
+		log.Error().Err(err).Msg("")
+	}
+	requestJournal.Close()
 }
 
 func setupLogger() {
@@ -150,6 +235,49 @@ func initDatabase() (*sql.DB, error) {
 	return database.InitDatabaseConnection(dbFile, sqlHistoryDirectory)
 }
 
+func initJournal(sqlDB *sql.DB) *journal.Journal {
+	viper.SetDefault("journal.capacity", 1000)
+	viper.SetDefault("journal.persist", false)
+	capacity := viper.GetInt("journal.capacity")
+	if viper.GetBool("journal.persist") {
+		return journal.NewJournal(capacity, sqlDB)
+	}
+	return journal.NewJournal(capacity, nil)
+}
+
+func setupProxy(triggerHandler *triggers.TriggerHandler, triggerService *triggers.TriggerService,
+	scenarioService *scenarios.ScenarioService, templateService *templates.TemplateService) error {
+	viper.SetDefault("proxy.enabled", false)
+	viper.SetDefault("proxy.subsystem_header", "X-Unimock-Subsystem")
+	viper.SetDefault("proxy.timeout", 30*time.Second)
+	viper.SetDefault("proxy.record.enabled", false)
+	viper.SetDefault("proxy.record.key_type", string(triggers.JsonPath))
+
+	if !viper.GetBool("proxy.enabled") {
+		return nil
+	}
+
+	upstreamProxy := proxy.NewProxy(proxy.Config{
+		Upstreams:       viper.GetStringMapString("proxy.upstreams"),
+		SubsystemHeader: viper.GetString("proxy.subsystem_header"),
+		Timeout:         viper.GetDuration("proxy.timeout"),
+	})
+
+	var recorder *triggers.Recorder
+	if viper.GetBool("proxy.record.enabled") {
+		var err error
+		recorder, err = triggers.NewRecorder(triggerService, scenarioService, templateService,
+			triggers.TriggerType(viper.GetString("proxy.record.key_type")), viper.GetString("proxy.record.key"))
+		if err != nil {
+			return err
+		}
+	}
+
+	triggerHandler.SetProxy(upstreamProxy, recorder)
+	log.Info().Msg("Режим прокси включён")
+	return nil
+}
+
 func startServer(app *fiber.App) {
 	viper.SetDefault("server.tls", false)
 	useTLS := viper.GetBool("server.tls")
@@ -182,9 +310,18 @@ func startServer(app *fiber.App) {
 	}
 }
 
-func Middleware() fiber.Handler {
+// setupVersionRoutes добавляет в группу маршруты истории версий объектов группы
+func setupVersionRoutes(controller fiber.Router, handler *history.VersionHandler) {
+	controller.Get("/:id/versions", handler.GetVersions)
+	controller.Get("/:id/versions/diff", handler.DiffVersions)
+	controller.Get("/:id/versions/:version", handler.GetVersion)
+	controller.Post("/:id/versions/:version/rollback", handler.Rollback)
+}
+
+func Middleware(authorHeader string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		start := time.Now()
+		util.SetAuthor(c, string([]byte(c.Get(authorHeader))))
 
 		err := c.Next()
 
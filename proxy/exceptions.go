@@ -0,0 +1,17 @@
+package proxy
+
+type UpstreamNotConfiguredException struct {
+	message string
+}
+
+func (e *UpstreamNotConfiguredException) Error() string {
+	return e.message
+}
+
+type UpstreamException struct {
+	message string
+}
+
+func (e *UpstreamException) Error() string {
+	return e.message
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unimock/util"
+)
+
+const DefaultSubsystem = "default"
+
+// hopByHopHeaders не передаются между клиентом и вышестоящим сервисом
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Host":                true,
+	"Content-Length":      true,
+}
+
+// acceptEncodingHeader клиента не передаётся вышестоящему сервису: http.Client сам запрашивает gzip и распаковывает
+// ответ, поэтому тело ответа всегда приходит в виде текста, который можно вернуть клиенту или записать в шаблон
+const acceptEncodingHeader = "Accept-Encoding"
+
+type Config struct {
+	// Upstreams - базовые URL вышестоящих сервисов по подсистемам, ключ default используется для остальных подсистем
+	Upstreams map[string]string
+	// SubsystemHeader - заголовок запроса с именем подсистемы
+	SubsystemHeader string
+	Timeout         time.Duration
+}
+
+type Proxy struct {
+	upstreams       map[string]string
+	subsystemHeader string
+	client          *http.Client
+}
+
+func NewProxy(config Config) *Proxy {
+	upstreams := make(map[string]string, len(config.Upstreams))
+	for subsystem, upstream := range config.Upstreams {
+		upstreams[strings.ToLower(subsystem)] = strings.TrimSuffix(upstream, "/")
+	}
+	return &Proxy{
+		upstreams:       upstreams,
+		subsystemHeader: config.SubsystemHeader,
+		client: &http.Client{
+			Timeout: config.Timeout,
+			// Перенаправления вышестоящего сервиса возвращаются клиенту как есть
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Subsystem возвращает подсистему сообщения из заголовка SubsystemHeader
+func (proxy *Proxy) Subsystem(message *util.Message) string {
+	return message.Headers[proxy.subsystemHeader]
+}
+
+// Upstream возвращает базовый URL вышестоящего сервиса для подсистемы
+func (proxy *Proxy) Upstream(subsystem string) (string, bool) {
+	if upstream, ok := proxy.upstreams[strings.ToLower(subsystem)]; ok {
+		return upstream, true
+	}
+	upstream, ok := proxy.upstreams[DefaultSubsystem]
+	return upstream, ok
+}
+
+// Forward отправляет сообщение вышестоящему сервису подсистемы сообщения и возвращает его ответ
+func (proxy *Proxy) Forward(message *util.Message) (*util.Message, error) {
+	upstream, ok := proxy.Upstream(proxy.Subsystem(message))
+	if !ok {
+		return nil, &UpstreamNotConfiguredException{
+			message: fmt.Sprintf("Не настроен вышестоящий сервис для подсистемы '%s'", proxy.Subsystem(message)),
+		}
+	}
+	return proxy.ForwardTo(upstream+message.Path, message)
+}
+
+// ForwardTo отправляет сообщение по указанному URL, добавляя параметры запроса сообщения
+func (proxy *Proxy) ForwardTo(target string, message *util.Message) (*util.Message, error) {
+	targetUrl, err := url.Parse(target)
+	if err != nil {
+		return nil, &UpstreamException{message: err.Error()}
+	}
+	if len(message.Query) > 0 {
+		query := targetUrl.Query()
+		for key, value := range message.Query {
+			query.Set(key, value)
+		}
+		targetUrl.RawQuery = query.Encode()
+	}
+
+	method := message.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	request, err := http.NewRequest(method, targetUrl.String(), bytes.NewReader([]byte(message.Body)))
+	if err != nil {
+		return nil, &UpstreamException{message: err.Error()}
+	}
+	for key, value := range message.Headers {
+		canonicalKey := http.CanonicalHeaderKey(key)
+		if !hopByHopHeaders[canonicalKey] && canonicalKey != acceptEncodingHeader && key != proxy.subsystemHeader {
+			request.Header.Set(key, value)
+		}
+	}
+
+	response, err := proxy.client.Do(request)
+	if err != nil {
+		return nil, &UpstreamException{message: fmt.Sprintf("Ошибка при обращении к %s: %v", targetUrl.Redacted(), err)}
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, &UpstreamException{message: fmt.Sprintf("Ошибка при чтении ответа %s: %v", targetUrl.Redacted(), err)}
+	}
+
+	headers := make(map[string]string, len(response.Header))
+	for key, values := range response.Header {
+		if !hopByHopHeaders[key] && len(values) > 0 {
+			headers[key] = strings.Join(values, ", ")
+		}
+	}
+
+	return &util.Message{
+		Body:       string(body),
+		Headers:    headers,
+		StatusCode: response.StatusCode,
+	}, nil
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"unimock/util"
+)
+
+func TestForward(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		require.Equal(t, "/orders/1", request.URL.Path)
+		require.Equal(t, "2", request.URL.Query().Get("v"))
+		require.Empty(t, request.Header.Get("X-Unimock-Subsystem"))
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusCreated)
+		_, _ = writer.Write([]byte(request.Method + " " + string(body)))
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxy(Config{
+		Upstreams:       map[string]string{"CRM": upstream.URL + "/"},
+		SubsystemHeader: "X-Unimock-Subsystem",
+		Timeout:         time.Second,
+	})
+
+	response, err := proxy.Forward(&util.Message{
+		Body:    "ping",
+		Headers: map[string]string{"X-Unimock-Subsystem": "crm"},
+		Method:  "PUT",
+		Path:    "/orders/1",
+		Query:   map[string]string{"v": "2"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	require.Equal(t, "PUT ping", response.Body)
+	require.Equal(t, "application/json", response.Headers["Content-Type"])
+
+	_, err = proxy.Forward(&util.Message{Headers: map[string]string{"X-Unimock-Subsystem": "billing"}})
+	require.IsType(t, &UpstreamNotConfiguredException{}, err)
+}
+
+func TestForwardDecompressesResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		require.Equal(t, "gzip", request.Header.Get("Accept-Encoding"))
+		writer.Header().Set("Content-Encoding", "gzip")
+		compressor := gzip.NewWriter(writer)
+		_, _ = compressor.Write([]byte("pong"))
+		_ = compressor.Close()
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxy(Config{Upstreams: map[string]string{"default": upstream.URL}, Timeout: time.Second})
+	response, err := proxy.Forward(&util.Message{Headers: map[string]string{"accept-encoding": "gzip, br"}})
+	require.NoError(t, err)
+	require.Equal(t, "pong", response.Body)
+	require.Empty(t, response.Headers["Content-Encoding"])
+}
+
+func TestForwardReturnsRedirect(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		require.Equal(t, "/old", request.URL.Path, "Перенаправление не выполняется прокси")
+		http.Redirect(writer, request, "/new", http.StatusFound)
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxy(Config{Upstreams: map[string]string{"default": upstream.URL}, Timeout: time.Second})
+	response, err := proxy.Forward(&util.Message{Method: "GET", Path: "/old"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, response.StatusCode)
+	require.Equal(t, "/new", response.Headers["Location"])
+}
@@ -0,0 +1,150 @@
+package scenarios
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+	"unimock/journal"
+	"unimock/proxy"
+	"unimock/util"
+)
+
+// callback - подготовленный запрос шага callback
+type callback struct {
+	config    *CallbackStepConfig
+	triggerId int64
+	request   *util.Message
+}
+
+// createCallback строит запрос шага callback по текущему сообщению сценария
+func (service *ScenarioService) createCallback(config *CallbackStepConfig, triggerId int64, message, inputMessage *util.Message) (*callback, error) {
+	template, err := service.templateService.GetTemplateById(config.TemplateId)
+	if err != nil {
+		return nil, err
+	}
+	message = service.templateMessage(template, message, inputMessage)
+	request, err := template.ProcessMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	target, err := template.ProcessText(config.Url, message)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = url.ParseRequestURI(target); err != nil {
+		return nil, &CallbackException{message: fmt.Sprintf("Некорректный url шага callback: %v", err)}
+	}
+
+	request.Method = config.Method
+	if request.Method == "" {
+		request.Method = http.MethodPost
+	}
+	request.Path = target
+	request.StatusCode = 0
+	request.Variables = nil
+	return &callback{config: config, triggerId: triggerId, request: request}, nil
+}
+
+// sendCallback отправляет запрос с задержкой и повторами, каждая попытка записывается в журнал
+func (service *ScenarioService) sendCallback(ctx context.Context, callback *callback) error {
+	if err := sleep(ctx, time.Duration(callback.config.DelayMs)*time.Millisecond); err != nil {
+		return err
+	}
+
+	sender := proxy.NewProxy(proxy.Config{Timeout: time.Duration(callback.config.TimeoutMs) * time.Millisecond})
+	for attempt := 0; ; attempt++ {
+		record := &journal.Record{
+			Timestamp: time.Now(),
+			TriggerId: callback.triggerId,
+			Request:   callback.request,
+			Outbound:  true,
+		}
+
+		response, err := sender.ForwardTo(callback.request.Path, callback.request)
+		if err == nil && response.StatusCode >= http.StatusInternalServerError {
+			err = &CallbackException{message: fmt.Sprintf("Callback %s вернул код %d", callback.request.Path, response.StatusCode)}
+		}
+
+		record.DurationMs = float64(time.Since(record.Timestamp).Microseconds()) / 1000
+		record.Response = response
+		if err != nil {
+			record.Error = err.Error()
+		}
+		if service.journal != nil {
+			service.journal.Record(record)
+		}
+
+		if err == nil || attempt >= callback.config.Retries {
+			return err
+		}
+		log.Warn().Err(err).Int("attempt", attempt+1).Msg("Ошибка отправки callback, запрос будет повторён")
+		if err = sleep(ctx, time.Duration(callback.config.RetryDelayMs)*time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
+// sendAsyncCallbacks отправляет отложенные запросы в фоне после отправки ответа на входящий запрос. Запросы не
+// связаны с входящим запросом и прерываются только остановкой сервиса
+func (service *ScenarioService) sendAsyncCallbacks(ctx context.Context, callbacks []*callback) {
+	if len(callbacks) == 0 {
+		return
+	}
+	util.AfterResponse(ctx, func() {
+		service.callbacks.mut.Lock()
+		defer service.callbacks.mut.Unlock()
+		if service.callbacks.stopped {
+			log.Warn().Int("count", len(callbacks)).Msg("Сервис останавливается, callback не отправлены")
+			return
+		}
+		for _, asyncCallback := range callbacks {
+			service.callbacks.wg.Add(1)
+			go func(asyncCallback *callback) {
+				defer service.callbacks.wg.Done()
+				if err := service.sendCallback(service.callbacks.ctx, asyncCallback); err != nil {
+					log.Error().Err(err).Int64("triggerId", asyncCallback.triggerId).Msg("Не удалось отправить callback")
+				}
+			}(asyncCallback)
+		}
+	})
+}
+
+// asyncCallbacks - отправляемые в фоне запросы шагов callback, ctx отменяется при остановке сервиса
+type asyncCallbacks struct {
+	mut     sync.Mutex
+	wg      sync.WaitGroup
+	stopped bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newAsyncCallbacks() *asyncCallbacks {
+	callbacks := new(asyncCallbacks)
+	callbacks.ctx, callbacks.cancel = context.WithCancel(context.Background())
+	return callbacks
+}
+
+// Shutdown ждёт отправки фоновых callback не дольше timeout, затем прерывает оставшиеся. Новые callback после
+// вызова не отправляются
+func (service *ScenarioService) Shutdown(timeout time.Duration) {
+	service.callbacks.mut.Lock()
+	service.callbacks.stopped = true
+	service.callbacks.mut.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		service.callbacks.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn().Msg("Фоновые callback не завершились, отправка прерывается")
+	}
+	service.callbacks.cancel()
+	<-done
+}
@@ -0,0 +1,154 @@
+package scenarios
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unimock/database"
+	"unimock/journal"
+	"unimock/states"
+	"unimock/templates"
+	"unimock/util"
+)
+
+func newTestService(t *testing.T) (*ScenarioService, *templates.TemplateService, *states.StateService) {
+	dbFile := filepath.Join(t.TempDir(), "unimock.db")
+	require.NoError(t, os.WriteFile(dbFile, nil, 0o644))
+
+	db, err := database.InitDatabaseConnection(dbFile, "../sql")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	templateService := templates.NewService(db)
+	require.NoError(t, templateService.UpdateFromDb())
+	stateService := states.NewService(db)
+	require.NoError(t, stateService.UpdateFromDb())
+	service := NewService(db, templateService, stateService, newRegexMatcher)
+	require.NoError(t, service.UpdateFromDb())
+	return service, templateService, stateService
+}
+
+// regexMatcher заменяет в тестах условия триггеров, пакет triggers зависит от scenarios
+type regexMatcher struct {
+	expression *regexp.Regexp
+}
+
+func (matcher regexMatcher) TriggerOnMessage(message *util.Message) bool {
+	return matcher.expression.MatchString(message.Body)
+}
+
+func newRegexMatcher(matcherType string, expression string) (MessageMatcher, error) {
+	if matcherType != "regex" {
+		return nil, fmt.Errorf("unsupported matcher type %s", matcherType)
+	}
+	compiled, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	return regexMatcher{expression: compiled}, nil
+}
+
+func TestCallbackStep(t *testing.T) {
+	var calls atomic.Int32
+	received := make(chan string, 10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- r.Method + " " + r.URL.Path + " " + r.Header.Get("X-Event") + " " + string(body)
+	}))
+	defer upstream.Close()
+
+	service, templateService, _ := newTestService(t)
+	requestJournal := journal.NewJournal(10, nil)
+	service.SetJournal(requestJournal)
+
+	template := &templates.Template{Name: "callback", Body: `{"order":"${id}"}`, Headers: map[string]string{"X-Event": "created"}}
+	require.NoError(t, templateService.AddTemplate(template, ""))
+	require.NoError(t, templateService.AddExtractor(&templates.Extractor{
+		TemplateId: template.Id, Name: "id", ExtractorType: templates.JsonPathExtractorType, Expression: "$.id",
+	}))
+
+	config := &CallbackStepConfig{TemplateId: template.Id, Url: upstream.URL + "/callbacks/${id}", Retries: 1}
+	step := &ScenarioStep{OrderNumber: 1, TriggerId: 1, StepType: Callback, Config: CreateStepConfig(config)}
+	require.NoError(t, service.AddStep(step, ""))
+
+	input := &util.Message{Body: `{"id": "42"}`}
+	output, err := service.ProcessMessage(context.Background(), input, 1)
+	require.NoError(t, err)
+	require.Equal(t, input, output, "Ответ callback не меняет сообщение сценария")
+	require.Equal(t, `POST /callbacks/42 created {"order":"42"}`, <-received)
+
+	records := requestJournal.Find(&journal.Filter{})
+	require.Len(t, records, 2, "Каждая попытка записывается в журнал")
+	require.True(t, records[0].Outbound)
+	require.Empty(t, records[0].Error)
+	require.NotEmpty(t, records[1].Error)
+
+	config.Async = true
+	config.DelayMs = 50
+	step.Config = CreateStepConfig(config)
+	require.NoError(t, service.UpdateStep(step, ""))
+
+	startTime := time.Now()
+	_, err = service.ProcessMessage(context.Background(), input, 1)
+	require.NoError(t, err)
+	require.Less(t, time.Since(startTime), 50*time.Millisecond, "Асинхронный callback не задерживает ответ")
+	select {
+	case callback := <-received:
+		require.Equal(t, `POST /callbacks/42 created {"order":"42"}`, callback)
+	case <-time.After(time.Second):
+		require.Fail(t, "Асинхронный callback не отправлен")
+	}
+
+	config.DelayMs = 0
+	step.Config = CreateStepConfig(config)
+	require.NoError(t, service.UpdateStep(step, ""))
+	requestCtx, afterResponse := util.WithAfterResponse(context.Background())
+	_, err = service.ProcessMessage(requestCtx, input, 1)
+	require.NoError(t, err)
+	select {
+	case <-received:
+		require.Fail(t, "Асинхронный callback отправлен до ответа")
+	case <-time.After(50 * time.Millisecond):
+	}
+	afterResponse()
+	select {
+	case callback := <-received:
+		require.Equal(t, `POST /callbacks/42 created {"order":"42"}`, callback)
+	case <-time.After(time.Second):
+		require.Fail(t, "Асинхронный callback не отправлен после ответа")
+	}
+
+	config.DelayMs = 10000
+	step.Config = CreateStepConfig(config)
+	require.NoError(t, service.UpdateStep(step, ""))
+	_, err = service.ProcessMessage(context.Background(), input, 1)
+	require.NoError(t, err)
+	startTime = time.Now()
+	service.Shutdown(50 * time.Millisecond)
+	require.Less(t, time.Since(startTime), time.Second, "Остановка сервиса прерывает ожидающие callback")
+	_, err = service.ProcessMessage(context.Background(), input, 1)
+	require.NoError(t, err)
+	select {
+	case <-received:
+		require.Fail(t, "Callback отправлен после остановки сервиса")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	missingTemplate := &ScenarioStep{TriggerId: 1, StepType: Callback,
+		Config: json.RawMessage(fmt.Sprintf(`{"template_id": %d, "url": "http://localhost"}`, template.Id+1))}
+	require.Error(t, service.AddStep(missingTemplate, ""))
+}
@@ -32,7 +32,7 @@ func (handler *ScenarioHandler) AddStep(context *fiber.Ctx) error {
 	if err := json.Unmarshal(context.Body(), step); err != nil {
 		return &StepValidationException{message: err.Error()}
 	}
-	if err := handler.scenarioService.AddStep(step); err != nil {
+	if err := handler.scenarioService.AddStep(step, util.GetAuthor(context)); err != nil {
 		return err
 	}
 	return context.JSON(step)
@@ -48,7 +48,7 @@ func (handler *ScenarioHandler) UpdateStep(context *fiber.Ctx) error {
 		return util.CreateParamValidationException("id", err)
 	}
 	step.Id = id
-	if err := handler.scenarioService.UpdateStep(step); err != nil {
+	if err := handler.scenarioService.UpdateStep(step, util.GetAuthor(context)); err != nil {
 		return err
 	}
 	return nil
@@ -64,9 +64,17 @@ func (handler *ScenarioHandler) UpdateStepsForTrigger(context *fiber.Ctx) error
 		return util.CreateParamValidationException("triggerId", err)
 	}
 
-	steps, err = handler.scenarioService.UpdateStepsForTrigger(steps, triggerId)
+	steps, err = handler.scenarioService.UpdateStepsForTrigger(steps, triggerId, util.GetAuthor(context))
 	if err != nil {
 		return err
 	}
 	return context.JSON(steps)
 }
+
+func (handler *ScenarioHandler) ResetSequence(context *fiber.Ctx) error {
+	id, err := strconv.ParseInt(context.Params("id"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("id", err)
+	}
+	return handler.scenarioService.ResetSequence(id)
+}
@@ -0,0 +1,295 @@
+package scenarios
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+	"unimock/util"
+)
+
+type BodyFormat string
+
+const (
+	JsonFormat BodyFormat = "json"
+	XmlFormat  BodyFormat = "xml"
+	FormFormat BodyFormat = "form"
+)
+
+const contentTypeHeader = "Content-Type"
+
+// defaultXmlRoot - корневой элемент XML, если значение JSON не является объектом с одним полем верхнего уровня
+const defaultXmlRoot = "root"
+
+// xmlItemElement - элемент XML для значений массива JSON верхнего уровня
+const xmlItemElement = "item"
+
+var contentTypes = map[BodyFormat]string{
+	JsonFormat: "application/json",
+	XmlFormat:  "application/xml",
+	FormFormat: "application/x-www-form-urlencoded",
+}
+
+// ConvertStepConfig - параметры шага convert, который преобразует тело текущего сообщения из формата From в формат To
+// и устанавливает соответствующий Content-Type
+type ConvertStepConfig struct {
+	// From - исходный формат, если не указан, определяется по Content-Type или содержимому тела
+	From BodyFormat `json:"from,omitempty"`
+	To   BodyFormat `json:"to"`
+	// Root - корневой элемент XML для значения JSON, которое не является объектом с одним полем верхнего уровня
+	Root string `json:"root,omitempty"`
+}
+
+func (config *ConvertStepConfig) validate() error {
+	if _, ok := contentTypes[config.To]; !ok {
+		return &StepValidationException{message: fmt.Sprintf("Неизвестный формат преобразования '%s'", config.To)}
+	}
+	if _, ok := contentTypes[config.From]; config.From != "" && !ok {
+		return &StepValidationException{message: fmt.Sprintf("Неизвестный формат преобразования '%s'", config.From)}
+	}
+	return nil
+}
+
+// convert возвращает копию сообщения с телом в формате To
+func (config *ConvertStepConfig) convert(message *util.Message) (*util.Message, error) {
+	from := config.From
+	if from == "" {
+		from = detectFormat(message)
+	}
+
+	value, err := parseBody(from, message.Body)
+	if err != nil {
+		return nil, &ConversionException{message: fmt.Sprintf("Не удалось разобрать тело как %s: %v", from, err)}
+	}
+	body, err := formatBody(config.To, value, config.Root)
+	if err != nil {
+		return nil, &ConversionException{message: fmt.Sprintf("Не удалось преобразовать тело в %s: %v", config.To, err)}
+	}
+
+	result := *message
+	result.Body = body
+	result.Headers = make(map[string]string, len(message.Headers)+1)
+	for key, value := range message.Headers {
+		if !strings.EqualFold(key, contentTypeHeader) {
+			result.Headers[key] = value
+		}
+	}
+	result.Headers[contentTypeHeader] = contentTypes[config.To]
+	return &result, nil
+}
+
+func detectFormat(message *util.Message) BodyFormat {
+	for key, value := range message.Headers {
+		if !strings.EqualFold(key, contentTypeHeader) {
+			continue
+		}
+		switch {
+		case strings.Contains(value, "json"):
+			return JsonFormat
+		case strings.Contains(value, "xml"):
+			return XmlFormat
+		case strings.Contains(value, "x-www-form-urlencoded"):
+			return FormFormat
+		}
+	}
+
+	body := strings.TrimSpace(message.Body)
+	switch {
+	case strings.HasPrefix(body, "{") || strings.HasPrefix(body, "["):
+		return JsonFormat
+	case strings.HasPrefix(body, "<"):
+		return XmlFormat
+	default:
+		return FormFormat
+	}
+}
+
+func parseBody(format BodyFormat, body string) (interface{}, error) {
+	switch format {
+	case JsonFormat:
+		decoder := json.NewDecoder(strings.NewReader(body))
+		decoder.UseNumber()
+		var value interface{}
+		err := decoder.Decode(&value)
+		return value, err
+	case XmlFormat:
+		// Префиксы и объявления xmlns сохраняются, чтобы обратное преобразование вернуло те же пространства имён
+		return util.ParseXmlPrefixed(body)
+	default:
+		return parseForm(body)
+	}
+}
+
+func formatBody(format BodyFormat, value interface{}, root string) (string, error) {
+	switch format {
+	case JsonFormat:
+		result, err := json.Marshal(value)
+		return string(result), err
+	case XmlFormat:
+		return formatXml(value, root)
+	default:
+		return formatForm(value), nil
+	}
+}
+
+// formatXml записывает объект с единственным полем, значение которого не массив, как корневой элемент, иначе
+// оборачивает значение в элемент root. Элементы массива верхнего уровня записываются элементами item
+func formatXml(value interface{}, root string) (string, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString(xml.Header)
+
+	object, ok := value.(map[string]interface{})
+	if ok && len(object) == 1 && root == "" {
+		for name, child := range object {
+			if _, isList := child.([]interface{}); !isList {
+				err := writeXmlElement(&buffer, name, child)
+				return buffer.String(), err
+			}
+		}
+	}
+
+	if root == "" {
+		root = defaultXmlRoot
+	}
+	if list, ok := value.([]interface{}); ok {
+		value = map[string]interface{}{xmlItemElement: list}
+	}
+	err := writeXmlElement(&buffer, root, value)
+	return buffer.String(), err
+}
+
+func writeXmlElement(buffer *bytes.Buffer, name string, value interface{}) error {
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			if err := writeXmlElement(buffer, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !isXmlName(name) {
+		return fmt.Errorf("'%s' не может быть именем элемента XML", name)
+	}
+	buffer.WriteString("<" + name)
+	object, isObject := value.(map[string]interface{})
+	keys := sortedKeys(object)
+	for _, key := range keys {
+		if strings.HasPrefix(key, util.XmlAttributePrefix) {
+			attribute := strings.TrimPrefix(key, util.XmlAttributePrefix)
+			if !isXmlName(attribute) {
+				return fmt.Errorf("'%s' не может быть именем атрибута XML", attribute)
+			}
+			buffer.WriteString(" " + attribute + `="`)
+			_ = xml.EscapeText(buffer, []byte(scalarToString(object[key])))
+			buffer.WriteString(`"`)
+		}
+	}
+	buffer.WriteString(">")
+
+	if isObject {
+		for _, key := range keys {
+			switch {
+			case key == util.XmlTextKey:
+				_ = xml.EscapeText(buffer, []byte(scalarToString(object[key])))
+			case !strings.HasPrefix(key, util.XmlAttributePrefix):
+				if err := writeXmlElement(buffer, key, object[key]); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		_ = xml.EscapeText(buffer, []byte(scalarToString(value)))
+	}
+	buffer.WriteString("</" + name + ">")
+	return nil
+}
+
+// isXmlName проверяет, что строка - имя XML (Name), возможно с префиксом пространства имён
+func isXmlName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, char := range name {
+		switch {
+		case unicode.IsLetter(char) || char == '_' || char == ':':
+		case i > 0 && (unicode.IsDigit(char) || char == '-' || char == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseForm возвращает объект, в котором повторяющиеся параметры собираются в массив
+func parseForm(body string) (interface{}, error) {
+	values, err := url.ParseQuery(strings.TrimSpace(body))
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{}, len(values))
+	for key, list := range values {
+		if len(list) == 1 {
+			result[key] = list[0]
+			continue
+		}
+		items := make([]interface{}, len(list))
+		for i, item := range list {
+			items[i] = item
+		}
+		result[key] = items
+	}
+	return result, nil
+}
+
+// formatForm кодирует вложенные объекты ключами через точку, а массивы - повторяющимися параметрами
+func formatForm(value interface{}) string {
+	values := url.Values{}
+	addFormValues(values, "", value)
+	return values.Encode()
+}
+
+func addFormValues(values url.Values, prefix string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			addFormValues(values, name, child)
+		}
+	case []interface{}:
+		for _, item := range v {
+			addFormValues(values, prefix, item)
+		}
+	default:
+		values.Add(prefix, scalarToString(v))
+	}
+}
+
+func scalarToString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,78 @@
+package scenarios
+
+import (
+	"context"
+	"encoding/xml"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unimock/util"
+)
+
+func TestConvertStep(t *testing.T) {
+	service, _, _ := newTestService(t)
+	require.NoError(t, service.AddStep(&ScenarioStep{OrderNumber: 1, TriggerId: 1, StepType: Convert,
+		Config: CreateStepConfig(&ConvertStepConfig{To: XmlFormat})}, ""))
+	require.NoError(t, service.AddStep(&ScenarioStep{OrderNumber: 2, TriggerId: 1, StepType: Convert,
+		Config: CreateStepConfig(&ConvertStepConfig{From: XmlFormat, To: FormFormat})}, ""))
+	require.NoError(t, service.AddStep(&ScenarioStep{OrderNumber: 3, TriggerId: 1, StepType: Convert,
+		Config: CreateStepConfig(&ConvertStepConfig{To: JsonFormat})}, ""))
+
+	input := &util.Message{
+		Headers: map[string]string{"content-type": "application/json", "X-Id": "1"},
+		Body:    `{"order": {"-id": "7", "amount": 10.5, "items": ["a", "b"]}}`,
+	}
+	output, err := service.ProcessMessage(context.Background(), input, 1)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"order.amount":"10.5","order.-id":"7","order.items":["a","b"]}`, output.Body)
+	require.Equal(t, map[string]string{"Content-Type": "application/json", "X-Id": "1"}, output.Headers)
+
+	xmlStep := &ConvertStepConfig{To: XmlFormat, Root: "request"}
+	message, err := xmlStep.convert(&util.Message{Body: `{"a": "1 < 2", "b": [1, 2]}`})
+	require.NoError(t, err)
+	require.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+`+`<request><a>1 &lt; 2</a><b>1</b><b>2</b></request>`, message.Body)
+	require.Equal(t, "application/xml", message.Headers["Content-Type"])
+
+	jsonStep := &ConvertStepConfig{To: JsonFormat}
+	message, err = jsonStep.convert(&util.Message{Body: `<?xml version="1.0"?><order id="7"><item>a</item><item>b</item><note>x<b>y</b></note></order>`})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"order":{"-id":"7","item":["a","b"],"note":{"#text":"x","b":"y"}}}`, message.Body)
+
+	_, err = jsonStep.convert(&util.Message{Body: `<order>`})
+	require.IsType(t, &ConversionException{}, err)
+
+	require.Error(t, service.AddStep(&ScenarioStep{OrderNumber: 4, TriggerId: 1, StepType: Convert,
+		Config: CreateStepConfig(&ConvertStepConfig{To: "yaml"})}, ""))
+}
+
+func TestConvertXmlNamesAndNamespaces(t *testing.T) {
+	toXml := &ConvertStepConfig{To: XmlFormat}
+	message, err := toXml.convert(&util.Message{Body: `[1, 2]`})
+	require.NoError(t, err)
+	require.Equal(t, xml.Header+`<root><item>1</item><item>2</item></root>`, message.Body)
+
+	message, err = toXml.convert(&util.Message{Body: `{"a": [1, 2]}`})
+	require.NoError(t, err)
+	require.Equal(t, xml.Header+`<root><a>1</a><a>2</a></root>`, message.Body, "Массив не становится несколькими корнями")
+
+	for _, body := range []string{`{"a b": 1}`, `{"2x": 2}`, `{"a": {"x\"><y": 1}}`, `{"a": {"-on\"x": "1"}}`} {
+		_, err = toXml.convert(&util.Message{Body: body})
+		require.IsType(t, &ConversionException{}, err, body)
+	}
+
+	soap := `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns="urn:orders">` +
+		`<soap:Body><order id="7"><item>a</item></order></soap:Body></soap:Envelope>`
+	toJson := &ConvertStepConfig{To: JsonFormat}
+	message, err = toJson.convert(&util.Message{Body: soap})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"soap:Envelope": {"-xmlns:soap": "http://schemas.xmlsoap.org/soap/envelope/",
+		"-xmlns": "urn:orders", "soap:Body": {"order": {"-id": "7", "item": "a"}}}}`, message.Body)
+
+	message, err = toXml.convert(message)
+	require.NoError(t, err)
+	require.Equal(t, xml.Header+`<soap:Envelope xmlns="urn:orders" xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">`+
+		`<soap:Body><order id="7"><item>a</item></order></soap:Body></soap:Envelope>`, message.Body)
+
+	_, err = toJson.convert(&util.Message{Body: `<soap:a xmlns:soap="urn:x"></soap:b>`})
+	require.IsType(t, &ConversionException{}, err)
+}
@@ -15,3 +15,53 @@ type StepNotFoundException struct {
 func (e *StepNotFoundException) Error() string {
 	return e.message
 }
+
+// FaultException - ошибка сбоя fail, ответ отправляется с кодом StatusCode
+type FaultException struct {
+	message    string
+	statusCode int
+}
+
+func (e *FaultException) Error() string {
+	return e.message
+}
+
+func (e *FaultException) StatusCode() int {
+	return e.statusCode
+}
+
+// CallbackException - ошибка отправки запроса шага callback
+type CallbackException struct {
+	message string
+}
+
+func (e *CallbackException) Error() string {
+	return e.message
+}
+
+// ScriptException - ошибка вычисления выражения шага script
+type ScriptException struct {
+	message string
+}
+
+func (e *ScriptException) Error() string {
+	return e.message
+}
+
+// ConversionException - ошибка преобразования тела шагом convert
+type ConversionException struct {
+	message string
+}
+
+func (e *ConversionException) Error() string {
+	return e.message
+}
+
+// SequenceExhaustedException - шаг sequence с политикой fail вызван после последнего шаблона
+type SequenceExhaustedException struct {
+	message string
+}
+
+func (e *SequenceExhaustedException) Error() string {
+	return e.message
+}
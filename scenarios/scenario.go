@@ -1,68 +1,162 @@
 package scenarios
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
 	"sort"
 	"sync"
 	"time"
+	"unimock/database"
+	"unimock/history"
+	"unimock/journal"
+	"unimock/proxy"
+	"unimock/states"
 	"unimock/templates"
 	"unimock/util"
 )
 
-const SelectAllQuery = "SELECT * FROM scenario_steps"
-const SelectByTriggerIdQuery = "SELECT * FROM scenario_steps where trigger_id = ?"
-const InsertQuery = "INSERT INTO scenario_steps (order_number, value, trigger_id, step_type) VALUES (?,?,?,?)"
-const UpdateQuery = "UPDATE scenario_steps SET order_number = ?, value = ?, trigger_id = ?, step_type = ? where id = ?"
-
-type ScenarioStep struct {
-	Id          int64            `json:"id"`
-	OrderNumber int              `json:"order_number"`
-	Value       int64            `json:"value"`
-	TriggerId   int64            `json:"trigger_id"`
-	StepType    ScenarioStepType `json:"step_type"`
-}
-
-type ScenarioStepType string
-
-const (
-	TemplateProcessing ScenarioStepType = "template_processing"
-	Delay                               = "delay"
-)
-
-type Steps []*ScenarioStep
-
-func (steps Steps) Len() int           { return len(steps) }
-func (steps Steps) Swap(i, j int)      { steps[i], steps[j] = steps[j], steps[i] }
-func (steps Steps) Less(i, j int) bool { return steps[i].OrderNumber < steps[j].OrderNumber }
+const SelectAllQuery = "SELECT id, order_number, trigger_id, step_type, label, config FROM scenario_steps"
+const SelectByTriggerIdQuery = "SELECT id, order_number, trigger_id, step_type, label, config FROM scenario_steps where trigger_id = ?"
+const InsertQuery = "INSERT INTO scenario_steps (order_number, trigger_id, step_type, label, config) VALUES (?,?,?,?,?)"
+const DeleteByTriggerIdQuery = "DELETE FROM scenario_steps WHERE trigger_id = ?"
+const InsertWithIdQuery = "INSERT INTO scenario_steps (id, order_number, trigger_id, step_type, label, config) VALUES (?,?,?,?,?,?)"
+const UpdateQuery = "UPDATE scenario_steps SET order_number = ?, trigger_id = ?, step_type = ?, label = ?, config = ? where id = ?"
 
 type ScenarioService struct {
 	steps           map[int64]Steps
 	db              *sql.DB
 	templateService *templates.TemplateService
+	stateService    *states.StateService
+	newMatcher      MatcherFactory
+	journal         *journal.Journal
+	versions        *history.History
+	sequences       *sequenceCounters
+	callbacks       *asyncCallbacks
 	mut             sync.RWMutex
 }
 
-func NewService(db *sql.DB, templateService *templates.TemplateService) *ScenarioService {
-	return &ScenarioService{
+func NewService(db *sql.DB, templateService *templates.TemplateService, stateService *states.StateService,
+	newMatcher MatcherFactory) *ScenarioService {
+	service := &ScenarioService{
 		steps:           make(map[int64]Steps),
 		db:              db,
 		templateService: templateService,
+		stateService:    stateService,
+		newMatcher:      newMatcher,
+		versions:        history.NewHistory(db, history.ScenarioStepVersionsTable),
+		sequences:       newSequenceCounters(),
+		callbacks:       newAsyncCallbacks(),
 	}
+	stateService.AddUsage(service.machineUsages)
+	return service
 }
 
-func (service *ScenarioService) AddStep(step *ScenarioStep) error {
-	insertStatement, err := service.db.Prepare(InsertQuery)
-	if err != nil {
+// machineUsages возвращает шаги set_state, которые переводят ключи автомата name
+func (service *ScenarioService) machineUsages(name string) []string {
+	service.mut.RLock()
+	defer service.mut.RUnlock()
+
+	usages := make([]string, 0)
+	for _, steps := range service.steps {
+		for _, step := range steps {
+			if config, ok := step.config.(*SetStateStepConfig); ok && config.StateMachine == name {
+				usages = append(usages, fmt.Sprintf("шаг %d", step.Id))
+			}
+		}
+	}
+	sort.Strings(usages)
+	return usages
+}
+
+// SetJournal задаёт журнал, в который записываются запросы шагов callback
+func (service *ScenarioService) SetJournal(requestJournal *journal.Journal) {
+	service.journal = requestJournal
+}
+
+// prepareStep разбирает параметры шага и компилирует условие шага if
+func (service *ScenarioService) prepareStep(step *ScenarioStep) error {
+	if err := step.prepare(); err != nil {
+		return err
+	}
+	if config, ok := step.config.(*IfStepConfig); ok {
+		matcher, err := service.newMatcher(config.MatcherType, config.Expression)
+		if err != nil {
+			return &StepValidationException{message: fmt.Sprintf("Некорректное условие шага if: %v", err)}
+		}
+		config.matcher = matcher
+	}
+	return nil
+}
+
+// validateStep разбирает параметры шага и проверяет существование шаблонов и автоматов, на которые он ссылается
+func (service *ScenarioService) validateStep(step *ScenarioStep) error {
+	if err := service.prepareStep(step); err != nil {
 		return err
 	}
+	var templateIds []int64
+	switch config := step.config.(type) {
+	case *TemplateStepConfig:
+		templateIds = []int64{config.TemplateId}
+	case *CallbackStepConfig:
+		templateIds = []int64{config.TemplateId}
+	case *SequenceStepConfig:
+		templateIds = config.TemplateIds
+	case *SetStateStepConfig:
+		if _, err := service.stateService.GetMachineByName(config.StateMachine); err != nil {
+			return &StepValidationException{message: err.Error()}
+		}
+		return nil
+	default:
+		return nil
+	}
+	for _, templateId := range templateIds {
+		if _, err := service.templateService.GetTemplateById(templateId); err != nil {
+			return &StepValidationException{message: fmt.Sprintf("Шаблон с id = %d не найден", templateId)}
+		}
+	}
+	return nil
+}
 
-	defer insertStatement.Close()
-	res, err := insertStatement.Exec(step.OrderNumber, step.Value, step.TriggerId, step.StepType)
-	if err != nil {
+// Versions возвращает историю изменений шагов сценариев
+func (service *ScenarioService) Versions() *history.History {
+	return service.versions
+}
+
+func (service *ScenarioService) AddStep(step *ScenarioStep, author string) error {
+	return service.addStep(step, author, history.Created)
+}
+
+// addStep сохраняет новый шаг. При восстановлении удалённого шага из версии (операция RolledBack) шаг
+// сохраняется с прежним id, чтобы история версий осталась связана с ним
+func (service *ScenarioService) addStep(step *ScenarioStep, author string, operation history.Operation) error {
+	if err := service.validateStep(step); err != nil {
+		return err
+	}
+	if err := service.validateLabels(step.TriggerId, Steps{step}); err != nil {
 		return err
 	}
 
-	step.Id, err = res.LastInsertId()
+	err := database.InTransaction(service.db, func(tx *sql.Tx) error {
+		var res sql.Result
+		var err error
+		if operation == history.RolledBack {
+			res, err = tx.Exec(InsertWithIdQuery, step.Id, step.OrderNumber, step.TriggerId, step.StepType, step.Label,
+				step.configForDb())
+		} else {
+			res, err = tx.Exec(InsertQuery, step.OrderNumber, step.TriggerId, step.StepType, step.Label,
+				step.configForDb())
+		}
+		if err != nil {
+			return err
+		}
+		if step.Id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		return service.versions.Record(tx, step.Id, operation, author, step)
+	})
 	if err != nil {
 		return err
 	}
@@ -73,13 +167,127 @@ func (service *ScenarioService) AddStep(step *ScenarioStep) error {
 	return nil
 }
 
-func (service *ScenarioService) UpdateStep(step *ScenarioStep) error {
-	updateStatement, err := service.db.Prepare(UpdateQuery)
+// DeleteStepsForTrigger удаляет в транзакции tx шаги удалённого триггера. Каскадное удаление в SQLite без
+// foreign_keys не выполняется, а оставшиеся шаги продолжали бы ссылаться на шаблоны и автоматы. Возвращённую
+// функцию forget нужно вызвать после фиксации транзакции, она убирает шаги из памяти
+func (service *ScenarioService) DeleteStepsForTrigger(tx *sql.Tx, triggerId int64, author string) (forget func(), err error) {
+	if _, err = tx.Exec(DeleteByTriggerIdQuery, triggerId); err != nil {
+		return nil, err
+	}
+
+	steps := service.GetOrderedStepsByTriggerId(triggerId)
+	for _, step := range steps {
+		if err = service.versions.Record(tx, step.Id, history.Deleted, author, step); err != nil {
+			return nil, err
+		}
+	}
+
+	return func() {
+		service.mut.Lock()
+		delete(service.steps, triggerId)
+		service.mut.Unlock()
+		for _, step := range steps {
+			service.sequences.reset(step.Id)
+		}
+	}, nil
+}
+
+// RestoreStepsForTrigger сохраняет в транзакции tx шаги восстановленного триггера с прежними id. Шаги, которые
+// уже восстановлены по отдельности, пропускаются. Возвращённую функцию restore нужно вызвать после фиксации
+// транзакции, она добавляет шаги в память
+func (service *ScenarioService) RestoreStepsForTrigger(tx *sql.Tx, steps Steps, author string) (restore func(), err error) {
+	restored := make(Steps, 0, len(steps))
+	for _, step := range steps {
+		if _, err = service.getStepById(step.Id); err == nil {
+			continue
+		}
+		if err = service.validateStep(step); err != nil {
+			return nil, err
+		}
+		restored = append(restored, step)
+	}
+	if len(restored) == 0 {
+		return func() {}, nil
+	}
+	triggerId := restored[0].TriggerId
+	if err = service.validateLabels(triggerId, restored); err != nil {
+		return nil, err
+	}
+
+	for _, step := range restored {
+		_, err = tx.Exec(InsertWithIdQuery, step.Id, step.OrderNumber, step.TriggerId, step.StepType, step.Label,
+			step.configForDb())
+		if err != nil {
+			return nil, err
+		}
+		if err = service.versions.Record(tx, step.Id, history.RolledBack, author, step); err != nil {
+			return nil, err
+		}
+	}
+
+	return func() {
+		service.mut.Lock()
+		service.steps[triggerId] = append(service.steps[triggerId], restored...)
+		service.mut.Unlock()
+	}, nil
+}
+
+func (service *ScenarioService) UpdateStep(step *ScenarioStep, author string) error {
+	return service.updateStep(step, author, history.Updated)
+}
+
+// RollbackStep восстанавливает шаг из сохранённой версии, откат записывается в историю новой версией.
+// Удалённый шаг создаётся заново
+func (service *ScenarioService) RollbackStep(id int64, version int64, author string) error {
+	snapshot, err := service.versions.GetVersion(id, version)
 	if err != nil {
 		return err
 	}
-	defer updateStatement.Close()
-	_, err = updateStatement.Exec(step.OrderNumber, step.Value, step.TriggerId, step.StepType, step.Id)
+	step := new(ScenarioStep)
+	if err = json.Unmarshal(snapshot.Snapshot, step); err != nil {
+		return err
+	}
+	step.Id = id
+	if _, err = service.getStepById(id); err != nil {
+		return service.addStep(step, author, history.RolledBack)
+	}
+	return service.updateStep(step, author, history.RolledBack)
+}
+
+func (service *ScenarioService) getStepById(id int64) (*ScenarioStep, error) {
+	service.mut.RLock()
+	defer service.mut.RUnlock()
+	for _, steps := range service.steps {
+		if index, err := findStepIndexByID(steps, id); err == nil {
+			return steps[index], nil
+		}
+	}
+	return nil, &StepNotFoundException{message: fmt.Sprintf("Шаг с id = %d не найден", id)}
+}
+
+func (service *ScenarioService) updateStep(step *ScenarioStep, author string, operation history.Operation) error {
+	if err := service.validateStep(step); err != nil {
+		return err
+	}
+	if err := service.validateLabels(step.TriggerId, Steps{step}); err != nil {
+		return err
+	}
+
+	err := database.InTransaction(service.db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(UpdateQuery, step.OrderNumber, step.TriggerId, step.StepType, step.Label,
+			step.configForDb(), step.Id)
+		if err != nil {
+			return err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return &StepNotFoundException{message: fmt.Sprintf("Шаг с id = %d не найден", step.Id)}
+		}
+		return service.versions.Record(tx, step.Id, operation, author, step)
+	})
 	if err != nil {
 		return err
 	}
@@ -87,53 +295,59 @@ func (service *ScenarioService) UpdateStep(step *ScenarioStep) error {
 	service.mut.Lock()
 	stepIndex, err := findStepIndexByID(service.steps[step.TriggerId], step.Id)
 	if err != nil {
+		service.mut.Unlock()
 		return service.UpdateFromDb()
 	}
 	service.steps[step.TriggerId][stepIndex] = step
 	service.mut.Unlock()
+	service.sequences.reset(step.Id)
 	return nil
 }
 
-func (service *ScenarioService) UpdateStepsForTrigger(steps Steps, triggerId int64) (Steps, error) {
-	tx, err := service.db.Begin()
-	if err != nil {
-		return nil, err
-	}
-	updateStatement, err := service.db.Prepare(UpdateQuery)
-	if err != nil {
-		return nil, err
+func (service *ScenarioService) UpdateStepsForTrigger(steps Steps, triggerId int64, author string) (Steps, error) {
+	for _, step := range steps {
+		if err := service.validateStep(step); err != nil {
+			return nil, err
+		}
 	}
-
-	defer updateStatement.Close()
-
-	insertStatement, err := service.db.Prepare(InsertQuery)
-	if err != nil {
+	if err := service.validateLabels(triggerId, steps); err != nil {
 		return nil, err
 	}
 
-	defer insertStatement.Close()
-
-	for i, _ := range steps {
-		if steps[i].Id == -1 {
-			_, err := insertStatement.Exec(steps[i].OrderNumber, steps[i].Value, steps[i].TriggerId, steps[i].StepType)
-			if err != nil {
-				_ = tx.Rollback()
-				return nil, err
+	err := database.InTransaction(service.db, func(tx *sql.Tx) error {
+		for _, step := range steps {
+			operation := history.Updated
+			if step.Id == -1 {
+				operation = history.Created
+				res, err := tx.Exec(InsertQuery, step.OrderNumber, step.TriggerId, step.StepType, step.Label,
+					step.configForDb())
+				if err != nil {
+					return err
+				}
+				if step.Id, err = res.LastInsertId(); err != nil {
+					return err
+				}
+			} else {
+				_, err := tx.Exec(UpdateQuery, step.OrderNumber, step.TriggerId, step.StepType, step.Label,
+					step.configForDb(), step.Id)
+				if err != nil {
+					return err
+				}
 			}
-		} else {
-			_, err = updateStatement.Exec(steps[i].OrderNumber, steps[i].Value, steps[i].TriggerId, steps[i].StepType, steps[i].Id)
-			if err != nil {
-				_ = tx.Rollback()
-				return nil, err
+			if err := service.versions.Record(tx, step.Id, operation, author, step); err != nil {
+				return err
 			}
 		}
-	}
-
-	err = tx.Commit()
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	for _, step := range steps {
+		service.sequences.reset(step.Id)
+	}
+
 	if err := service.updateStepsForTriggerFromDb(triggerId); err != nil {
 		return nil, err
 	}
@@ -141,24 +355,48 @@ func (service *ScenarioService) UpdateStepsForTrigger(steps Steps, triggerId int
 	return service.GetOrderedStepsByTriggerId(triggerId), nil
 }
 
+// scanStep читает шаг из строки выборки. Шаг, параметры которого не разбираются, например унаследованный
+// от старой версии или переставший компилироваться после обновления, пропускается с записью в лог,
+// чтобы один такой шаг не мешал запуску сервиса. Для пропущенного шага возвращается nil
+func (service *ScenarioService) scanStep(rows *sql.Rows) (*ScenarioStep, error) {
+	step := new(ScenarioStep)
+	var config sql.NullString
+	if err := rows.Scan(&step.Id, &step.OrderNumber, &step.TriggerId, &step.StepType, &step.Label, &config); err != nil {
+		return nil, err
+	}
+	step.setConfigFromDb(config.String)
+	if err := service.prepareStep(step); err != nil {
+		log.Error().Err(err).Int64("stepId", step.Id).Int64("triggerId", step.TriggerId).
+			Msg("Шаг сценария пропущен: некорректные параметры")
+		return nil, nil
+	}
+	return step, nil
+}
+
 func (service *ScenarioService) UpdateFromDb() error {
 	rows, err := service.db.Query(SelectAllQuery)
 	if err != nil {
 		return err
 	}
 
-	service.mut.Lock()
-	service.steps = make(map[int64]Steps)
+	defer rows.Close()
+
+	steps := make(map[int64]Steps)
 
 	for rows.Next() {
-		var step ScenarioStep
-		err = rows.Scan(&step.Id, &step.OrderNumber, &step.Value, &step.TriggerId, &step.StepType)
+		step, err := service.scanStep(rows)
 		if err != nil {
 			return err
 		}
+		if step == nil {
+			continue
+		}
 
-		service.steps[step.TriggerId] = append(service.steps[step.TriggerId], &step)
+		steps[step.TriggerId] = append(steps[step.TriggerId], step)
 	}
+
+	service.mut.Lock()
+	service.steps = steps
 	service.mut.Unlock()
 	return nil
 }
@@ -169,18 +407,24 @@ func (service *ScenarioService) updateStepsForTriggerFromDb(triggerId int64) err
 		return err
 	}
 
-	service.mut.Lock()
-	service.steps[triggerId] = make(Steps, 0)
+	defer rows.Close()
+
+	steps := make(Steps, 0)
 
 	for rows.Next() {
-		var step ScenarioStep
-		err = rows.Scan(&step.Id, &step.OrderNumber, &step.Value, &step.TriggerId, &step.StepType)
+		step, err := service.scanStep(rows)
 		if err != nil {
 			return err
 		}
+		if step == nil {
+			continue
+		}
 
-		service.steps[step.TriggerId] = append(service.steps[step.TriggerId], &step)
+		steps = append(steps, step)
 	}
+
+	service.mut.Lock()
+	service.steps[triggerId] = steps
 	service.mut.Unlock()
 	return nil
 }
@@ -200,29 +444,163 @@ func (service *ScenarioService) GetOrderedStepsByTriggerId(triggerId int64) Step
 	return stepsCopy
 }
 
-func (service *ScenarioService) ProcessMessage(inputMessage *util.Message, triggerId int64) (*util.Message, error) {
+// ProcessMessage выполняет шаги сценария триггера. Отмена ctx, например при отключении клиента, прерывает задержки
+func (service *ScenarioService) ProcessMessage(ctx context.Context, inputMessage *util.Message, triggerId int64) (*util.Message, error) {
 	steps := service.GetOrderedStepsByTriggerId(triggerId)
 	if len(steps) == 0 {
 		return &util.Message{}, nil
 	}
 	message := inputMessage
-	for _, step := range steps {
-		switch step.StepType {
-		case TemplateProcessing:
-			var err error
-			message, err = service.templateService.ProcessMessage(step.Value, message)
-			if err != nil {
-				return nil, err
+	asyncCallbacks := make([]*callback, 0)
+	for i := 0; i < len(steps); i++ {
+		step := steps[i]
+		var err error
+		switch config := step.config.(type) {
+		case *TemplateStepConfig:
+			message, err = service.processTemplateStep(config.TemplateId, message, inputMessage)
+		case *DelayStepConfig:
+			err = sleep(ctx, config.duration())
+		case *ProxyStepConfig:
+			message, err = processProxyStep(config, message)
+		case *FaultStepConfig:
+			if config.happens() {
+				message, err = config.apply(message)
+			}
+		case *CallbackStepConfig:
+			var stepCallback *callback
+			stepCallback, err = service.createCallback(config, triggerId, message, inputMessage)
+			if err == nil && config.Async {
+				asyncCallbacks = append(asyncCallbacks, stepCallback)
+			} else if err == nil {
+				err = service.sendCallback(ctx, stepCallback)
+			}
+		case *SetStateStepConfig:
+			// Ключ извлекается из входящего сообщения, а не из результата предыдущих шагов
+			err = service.stateService.SetState(config.StateMachine, inputMessage, config.State)
+		case *ScriptStepConfig:
+			message, err = config.run(ctx, message, service.messageStates(inputMessage))
+		case *ConvertStepConfig:
+			message, err = config.convert(message)
+		case *SequenceStepConfig:
+			message, err = service.processSequenceStep(step, config, message, inputMessage)
+		case *IfStepConfig:
+			if config.matches(message) {
+				// Цикл увеличит индекс, поэтому переход выполняется на шаг перед целевым
+				i, err = nextStepIndex(steps, i, config)
+				i--
 			}
-		case Delay:
-			time.Sleep(time.Duration(step.Value) * time.Millisecond)
 		default:
 
 		}
+		if err != nil {
+			return nil, err
+		}
 	}
+	service.sendAsyncCallbacks(ctx, asyncCallbacks)
 	return message, nil
 }
 
+// processTemplateStep обрабатывает сообщение шаблоном, шаблонам gotemplate передаются состояния входящего сообщения
+func (service *ScenarioService) processTemplateStep(templateId int64, message, inputMessage *util.Message) (*util.Message, error) {
+	template, err := service.templateService.GetTemplateById(templateId)
+	if err != nil {
+		return nil, err
+	}
+	return template.ProcessMessage(service.templateMessage(template, message, inputMessage))
+}
+
+// templateMessage возвращает копию сообщения с состояниями автоматов, если они нужны движку шаблона
+func (service *ScenarioService) templateMessage(template *templates.Template, message, inputMessage *util.Message) *util.Message {
+	if template.Engine != templates.GoTemplateEngine {
+		return message
+	}
+	result := *message
+	result.States = service.messageStates(inputMessage)
+	return &result
+}
+
+// messageStates возвращает состояния ключа сообщения по всем автоматам, из которых ключ удалось извлечь
+func (service *ScenarioService) messageStates(message *util.Message) map[string]string {
+	result := make(map[string]string)
+	for _, machine := range service.stateService.GetMachines() {
+		if _, state, err := service.stateService.GetState(machine.Name, message); err == nil {
+			result[machine.Name] = state
+		}
+	}
+	return result
+}
+
+// validateLabels проверяет, что метки переходов шагов if находятся среди последующих шагов триггера после
+// сохранения шагов changed: новых, без id, и заменяющих сохранённые шаги с тем же id
+func (service *ScenarioService) validateLabels(triggerId int64, changed Steps) error {
+	steps := service.GetOrderedStepsByTriggerId(triggerId)
+	for _, step := range changed {
+		index, err := findStepIndexByID(steps, step.Id)
+		if err != nil || step.Id <= 0 {
+			steps = append(steps, step)
+		} else {
+			steps[index] = step
+		}
+	}
+	sort.Sort(steps)
+
+	for i, step := range steps {
+		if config, ok := step.config.(*IfStepConfig); ok && config.Goto != "" {
+			if _, err := nextStepIndex(steps, i, config); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nextStepIndex возвращает индекс шага, к которому переходит выполненное условие шага if с индексом current.
+// Переход возможен только вперёд, чтобы сценарий не мог зациклиться
+func nextStepIndex(steps Steps, current int, config *IfStepConfig) (int, error) {
+	if config.Goto == "" {
+		return current + 1 + config.Skip, nil
+	}
+	for i := current + 1; i < len(steps); i++ {
+		if steps[i].Label == config.Goto {
+			return i, nil
+		}
+	}
+	return 0, &StepValidationException{
+		message: fmt.Sprintf("Метка %s не найдена среди шагов после шага %d", config.Goto, steps[current].Id),
+	}
+}
+
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func processProxyStep(config *ProxyStepConfig, message *util.Message) (*util.Message, error) {
+	target := config.Url
+	if config.AppendPath {
+		target += message.Path
+	}
+
+	upstreamProxy := proxy.NewProxy(proxy.Config{Timeout: time.Duration(config.TimeoutMs) * time.Millisecond})
+	response, err := upstreamProxy.ForwardTo(target, message)
+	if err != nil {
+		return nil, err
+	}
+
+	// Следующие шаги могут извлекать значения из пути и параметров исходного запроса
+	response.Method = message.Method
+	response.Path = message.Path
+	response.Query = message.Query
+	response.Variables = message.Variables
+	return response, nil
+}
+
 func findStepIndexByID(steps Steps, id int64) (int, error) {
 	for i := range steps {
 		if steps[i].Id == id {
@@ -1,12 +1,21 @@
 package scenarios
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"io"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"sort"
 	"sync"
 	"testing"
+	"time"
+	"unimock/states"
+	"unimock/templates"
+	"unimock/util"
 )
 
 func TestGetOrderedStepsByNotExistedTriggerId(t *testing.T) {
@@ -81,3 +90,226 @@ func (steps Steps) Print() {
 	}
 	fmt.Println()
 }
+
+func TestProxyStep(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		writer.WriteHeader(http.StatusAccepted)
+		_, _ = writer.Write([]byte(request.URL.Path + ":" + string(body)))
+	}))
+	defer upstream.Close()
+
+	step := &ScenarioStep{Id: 1, OrderNumber: 1, TriggerId: 1, StepType: Proxy,
+		Config: CreateStepConfig(&ProxyStepConfig{Url: upstream.URL + "/real", AppendPath: true, TimeoutMs: 1000})}
+	require.NoError(t, step.prepare())
+	service := &ScenarioService{steps: map[int64]Steps{1: {step}}}
+
+	output, err := service.ProcessMessage(context.Background(), &util.Message{Body: "ping", Method: "POST", Path: "/orders"}, 1)
+	require.NoError(t, err)
+	require.Equal(t, "/real/orders:ping", output.Body)
+	require.Equal(t, http.StatusAccepted, output.StatusCode)
+	require.Equal(t, "/orders", output.Path)
+
+	require.Error(t, (&ScenarioStep{StepType: Proxy, Config: json.RawMessage(`{"url": "not a url"}`)}).prepare())
+}
+
+func TestStepConfigValidation(t *testing.T) {
+	valid := &ScenarioStep{StepType: Delay, Config: json.RawMessage(`{"ms": 10}`)}
+	require.NoError(t, valid.prepare())
+	require.Equal(t, &DelayStepConfig{Ms: 10}, valid.config)
+
+	invalid := []*ScenarioStep{
+		{StepType: Delay, Config: json.RawMessage(`{"ms": -1}`)},
+		{StepType: Delay},
+		{StepType: Delay, Config: json.RawMessage(`{"millis": 10}`)},
+		{StepType: TemplateProcessing, Config: json.RawMessage(`{"template_id": 0}`)},
+		{StepType: "unknown", Config: json.RawMessage(`{}`)},
+	}
+	for _, step := range invalid {
+		require.Error(t, step.prepare(), string(step.Config))
+	}
+}
+
+func TestDelayModes(t *testing.T) {
+	configs := []string{
+		`{"mode": "uniform", "min_ms": 10, "max_ms": 20, "seed": 42}`,
+		`{"mode": "normal", "mean_ms": 50, "std_dev_ms": 10, "max_ms": 80, "seed": 42}`,
+		`{"mode": "lognormal", "median_ms": 30, "sigma": 0.5, "max_ms": 200, "seed": 42}`,
+		`{"mode": "percentiles", "p50_ms": 10, "p95_ms": 50, "p99_ms": 100, "seed": 42}`,
+	}
+	for _, config := range configs {
+		first := &ScenarioStep{StepType: Delay, Config: json.RawMessage(config)}
+		second := &ScenarioStep{StepType: Delay, Config: json.RawMessage(config)}
+		require.NoError(t, first.prepare(), config)
+		require.NoError(t, second.prepare(), config)
+		for i := 0; i < 100; i++ {
+			delay := first.config.(*DelayStepConfig).duration()
+			require.Equal(t, delay, second.config.(*DelayStepConfig).duration(), "Одинаковый seed дает одинаковые задержки")
+			require.GreaterOrEqual(t, delay, time.Duration(0))
+			require.LessOrEqual(t, delay, 200*time.Millisecond)
+		}
+	}
+
+	percentiles := &DelayStepConfig{Mode: PercentileDelay, P50Ms: 10, P95Ms: 50, P99Ms: 100}
+	require.Equal(t, 10.0, percentiles.percentile(0.5))
+	require.Equal(t, 30.0, percentiles.percentile(0.725))
+	require.Equal(t, 100.0, percentiles.percentile(1))
+
+	for _, config := range []string{
+		`{"mode": "uniform", "min_ms": 20, "max_ms": 10}`,
+		`{"mode": "lognormal", "median_ms": 0}`,
+		`{"mode": "percentiles", "p50_ms": 50, "p95_ms": 10, "p99_ms": 100}`,
+		`{"mode": "pareto"}`,
+	} {
+		require.Error(t, (&ScenarioStep{StepType: Delay, Config: json.RawMessage(config)}).prepare(), config)
+	}
+}
+
+func TestDelayCancellation(t *testing.T) {
+	step := &ScenarioStep{Id: 1, TriggerId: 1, StepType: Delay, Config: json.RawMessage(`{"ms": 10000}`)}
+	require.NoError(t, step.prepare())
+	service := &ScenarioService{steps: map[int64]Steps{1: {step}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	startTime := time.Now()
+	_, err := service.ProcessMessage(ctx, &util.Message{}, 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(startTime), time.Second)
+}
+
+func TestFaultStep(t *testing.T) {
+	step := &ScenarioStep{Id: 1, TriggerId: 1, StepType: Fault, Config: json.RawMessage(`{"fault": "trickle", "bytes_per_second": 10}`)}
+	require.NoError(t, step.prepare())
+	service := &ScenarioService{steps: map[int64]Steps{1: {step}}}
+
+	input := &util.Message{Body: "pong"}
+	output, err := service.ProcessMessage(context.Background(), input, 1)
+	require.NoError(t, err)
+	require.Equal(t, &util.Fault{Type: util.FaultTrickle, BytesPerSecond: 10}, output.Fault)
+	require.Equal(t, "pong", output.Body)
+	require.Nil(t, input.Fault)
+
+	step.Config = json.RawMessage(`{"fault": "fail", "status_code": 503, "probability": 0.5, "seed": 7}`)
+	require.NoError(t, step.prepare())
+	failures := 0
+	for i := 0; i < 1000; i++ {
+		_, err = service.ProcessMessage(context.Background(), input, 1)
+		if err != nil {
+			require.Equal(t, 503, err.(*FaultException).StatusCode())
+			failures++
+		}
+	}
+	require.InDelta(t, 500, failures, 60)
+
+	for _, config := range []string{
+		`{"fault": "trickle"}`,
+		`{"fault": "reset", "probability": 1.5}`,
+		`{"fault": "fail", "status_code": 42}`,
+		`{"fault": "explode"}`,
+	} {
+		require.Error(t, (&ScenarioStep{StepType: Fault, Config: json.RawMessage(config)}).prepare(), config)
+	}
+}
+
+func TestIfStep(t *testing.T) {
+	service := &ScenarioService{newMatcher: newRegexMatcher}
+	steps := Steps{
+		{Id: 1, StepType: If, Config: json.RawMessage(`{"type": "regex", "expression": "vip", "goto": "vip"}`)},
+		{Id: 2, StepType: If, Config: json.RawMessage(`{"type": "regex", "expression": "^ping$", "negate": true, "skip": 1}`)},
+		{Id: 3, StepType: Fault, Config: json.RawMessage(`{"fault": "empty_body"}`)},
+		{Id: 4, StepType: If, Config: json.RawMessage(`{"type": "regex", "expression": "", "goto": "end"}`)},
+		{Id: 5, StepType: Fault, Label: "vip", Config: json.RawMessage(`{"fault": "close"}`)},
+		{Id: 6, StepType: Delay, Label: "end", Config: json.RawMessage(`{"ms": 0}`)},
+	}
+	for _, step := range steps {
+		require.NoError(t, service.prepareStep(step))
+	}
+	service.steps = map[int64]Steps{1: steps}
+
+	process := func(body string) *util.Fault {
+		output, err := service.ProcessMessage(context.Background(), &util.Message{Body: body}, 1)
+		require.NoError(t, err)
+		return output.Fault
+	}
+	require.Equal(t, &util.Fault{Type: util.FaultClose}, process("vip"), "Переход к метке")
+	require.Nil(t, process("pong"), "Отрицание условия пропускает один шаг")
+	require.Equal(t, &util.Fault{Type: util.FaultEmptyBody}, process("ping"))
+
+	index, err := nextStepIndex(steps, 5, &IfStepConfig{Goto: "vip"})
+	require.Error(t, err, "Переход назад запрещён")
+	require.Zero(t, index)
+
+	for _, config := range []string{
+		`{"type": "regex", "expression": "a"}`,
+		`{"type": "regex", "expression": "a", "goto": "x", "skip": 1}`,
+		`{"type": "regex", "expression": "(", "skip": 1}`,
+		`{"type": "gson", "expression": "a", "skip": 1}`,
+	} {
+		require.Error(t, service.prepareStep(&ScenarioStep{StepType: If, Config: json.RawMessage(config)}), config)
+	}
+}
+
+func TestGoTemplateStepStates(t *testing.T) {
+	service, templateService, stateService := newTestService(t)
+	require.NoError(t, stateService.AddMachine(&states.Machine{
+		Name: "orders", KeyType: templates.JsonPathExtractorType, KeyExpression: "$.id",
+	}))
+	template := &templates.Template{Name: "state", Engine: templates.GoTemplateEngine, Body: `{{ .State.orders }}`}
+	require.NoError(t, templateService.AddTemplate(template, ""))
+	require.NoError(t, service.AddStep(&ScenarioStep{OrderNumber: 1, TriggerId: 1, StepType: TemplateProcessing,
+		Config: CreateStepConfig(&TemplateStepConfig{TemplateId: template.Id})}, ""))
+
+	output, err := service.ProcessMessage(context.Background(), &util.Message{Body: `{"id": "A-1"}`}, 1)
+	require.NoError(t, err)
+	require.Equal(t, states.DefaultInitialState, output.Body)
+}
+
+func TestUpdateFromDbSkipsInvalidSteps(t *testing.T) {
+	service, _, _ := newTestService(t)
+	_, err := service.db.Exec(`INSERT INTO scenario_steps (order_number, trigger_id, step_type, label, config) VALUES
+		(1, 1, 'template_processing', '', '{"template_id":0}'),
+		(2, 1, 'if', '', '{"type":"regex","expression":"(","skip":1}'),
+		(3, 1, 'unknown', '', ''),
+		(4, 1, 'delay', '', '{"ms":1}')`)
+	require.NoError(t, err)
+
+	require.NoError(t, service.UpdateFromDb())
+	steps := service.GetOrderedStepsByTriggerId(1)
+	require.Len(t, steps, 1)
+	require.Equal(t, Delay, steps[0].StepType)
+}
+
+func TestGotoLabelsValidatedOnSave(t *testing.T) {
+	service, _, _ := newTestService(t)
+	ifStep := func(order int, label string) *ScenarioStep {
+		return &ScenarioStep{OrderNumber: order, TriggerId: 1, StepType: If,
+			Config: json.RawMessage(fmt.Sprintf(`{"type": "regex", "expression": "a", "goto": "%s"}`, label))}
+	}
+	delayStep := func(order int, label string) *ScenarioStep {
+		return &ScenarioStep{OrderNumber: order, TriggerId: 1, StepType: Delay, Label: label,
+			Config: json.RawMessage(`{"ms": 0}`)}
+	}
+
+	require.IsType(t, &StepValidationException{}, service.AddStep(ifStep(1, "end"), ""), "Метка не найдена")
+
+	end := delayStep(2, "end")
+	require.NoError(t, service.AddStep(end, ""))
+	require.NoError(t, service.AddStep(ifStep(1, "end"), ""))
+	require.IsType(t, &StepValidationException{}, service.AddStep(ifStep(3, "end"), ""), "Переход назад")
+
+	renamed := *end
+	renamed.Label = "finish"
+	require.IsType(t, &StepValidationException{}, service.UpdateStep(&renamed, ""), "Метка используется шагом if")
+
+	newIf := ifStep(3, "last")
+	newIf.Id = -1
+	newTarget := delayStep(4, "last")
+	newTarget.Id = -1
+	_, err := service.UpdateStepsForTrigger(Steps{newIf, newTarget}, 1, "")
+	require.NoError(t, err, "Шаг if и его метка сохраняются вместе")
+
+	newTarget.OrderNumber = 0
+	_, err = service.UpdateStepsForTrigger(Steps{newTarget}, 1, "")
+	require.IsType(t, &StepValidationException{}, err)
+}
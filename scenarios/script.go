@@ -0,0 +1,213 @@
+package scenarios
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/PaesslerAG/gval"
+	"github.com/PaesslerAG/jsonpath"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+	"unimock/util"
+)
+
+var variableNameRegexp = regexp.MustCompile(`^[\w.\-]+$`)
+
+// scriptLanguage - язык выражений шага script: полный gval, JSONPath от корня параметров ($.body...)
+// и функции для вычислений над сообщением
+var scriptLanguage = gval.Full(
+	jsonpath.Language(),
+	gval.Function("sum", scriptSum),
+	gval.Function("count", scriptCount),
+	gval.Function("round", scriptRound),
+	gval.Function("upper", func(value string) string { return strings.ToUpper(value) }),
+	gval.Function("lower", func(value string) string { return strings.ToLower(value) }),
+	gval.Function("trim", func(value string) string { return strings.TrimSpace(value) }),
+	gval.Function("replace", func(value, old, new string) string { return strings.ReplaceAll(value, old, new) }),
+	gval.Function("now", func() string { return time.Now().Format(time.RFC3339) }),
+	gval.Function("dateAdd", scriptDateAdd),
+	gval.Function("formatDate", scriptFormatDate),
+)
+
+// ScriptVariable - переменная, значение которой вычисляется выражением Expression. Переменные доступны
+// в шаблонах следующих шагов как плейсхолдеры ${Name}
+type ScriptVariable struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+	eval       gval.Evaluable
+}
+
+// ScriptStepConfig - параметры шага script. Переменные вычисляются по порядку, в выражении доступны параметры
+// body (разобранный JSON или текст), raw, headers, query, method, path, state (состояния ключа сообщения
+// по автоматам) и vars (уже вычисленные переменные)
+type ScriptStepConfig struct {
+	Variables []*ScriptVariable `json:"variables"`
+}
+
+func (config *ScriptStepConfig) validate() error {
+	if len(config.Variables) == 0 {
+		return &StepValidationException{message: "Не указаны переменные шага script"}
+	}
+	for _, variable := range config.Variables {
+		if !variableNameRegexp.MatchString(variable.Name) {
+			return &StepValidationException{message: fmt.Sprintf("Некорректное имя переменной '%s'", variable.Name)}
+		}
+		// Выражения компилируются при разборе параметров шага, а не при каждом сообщении
+		var err error
+		variable.eval, err = scriptLanguage.NewEvaluable(variable.Expression)
+		if err != nil {
+			return &StepValidationException{message: fmt.Sprintf("Некорректное выражение переменной %s: %v", variable.Name, err)}
+		}
+	}
+	return nil
+}
+
+// run вычисляет переменные и возвращает копию сообщения с ними
+func (config *ScriptStepConfig) run(ctx context.Context, message *util.Message, states map[string]string) (*util.Message, error) {
+	var body interface{} = message.Body
+	var parsedBody interface{}
+	if json.Unmarshal([]byte(message.Body), &parsedBody) == nil {
+		body = parsedBody
+	}
+
+	vars := make(map[string]interface{}, len(message.Variables)+len(config.Variables))
+	for name, value := range message.Variables {
+		vars[name] = value
+	}
+	parameters := map[string]interface{}{
+		"body":    body,
+		"raw":     message.Body,
+		"headers": message.Headers,
+		"query":   message.Query,
+		"method":  message.Method,
+		"path":    message.Path,
+		"state":   states,
+		"vars":    vars,
+	}
+
+	result := *message
+	result.Variables = make(map[string]string, len(vars))
+	for name, value := range message.Variables {
+		result.Variables[name] = value
+	}
+	for _, variable := range config.Variables {
+		value, err := variable.eval(ctx, parameters)
+		if err != nil {
+			return nil, &ScriptException{message: fmt.Sprintf("Ошибка вычисления переменной %s: %v", variable.Name, err)}
+		}
+		vars[variable.Name] = value
+		result.Variables[variable.Name] = scriptValueToString(value)
+	}
+	return &result, nil
+}
+
+func scriptValueToString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		result, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(result)
+	}
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		var result float64
+		if _, err := fmt.Sscan(v, &result); err != nil {
+			return 0, fmt.Errorf("%q не является числом", v)
+		}
+		return result, nil
+	default:
+		return 0, fmt.Errorf("%v не является числом", v)
+	}
+}
+
+// scriptSum складывает числа, массивы чисел разворачиваются
+func scriptSum(arguments ...interface{}) (interface{}, error) {
+	total := 0.0
+	for _, argument := range arguments {
+		values, ok := argument.([]interface{})
+		if !ok {
+			values = []interface{}{argument}
+		}
+		for _, value := range values {
+			number, err := toFloat(value)
+			if err != nil {
+				return nil, err
+			}
+			total += number
+		}
+	}
+	return total, nil
+}
+
+// scriptCount возвращает количество элементов массива или объекта, либо длину строки
+func scriptCount(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		return float64(len(v)), nil
+	case map[string]interface{}:
+		return float64(len(v)), nil
+	case string:
+		return float64(len([]rune(v))), nil
+	case nil:
+		return 0.0, nil
+	default:
+		return nil, fmt.Errorf("count() не применим к %v", v)
+	}
+}
+
+func scriptRound(value interface{}, digits interface{}) (interface{}, error) {
+	number, err := toFloat(value)
+	if err != nil {
+		return nil, err
+	}
+	precision, err := toFloat(digits)
+	if err != nil {
+		return nil, err
+	}
+	scale := math.Pow(10, precision)
+	return math.Round(number*scale) / scale, nil
+}
+
+// scriptDateAdd прибавляет к дате в формате RFC3339 длительность Go, например 24h или -90m
+func scriptDateAdd(date string, duration string) (string, error) {
+	parsedDate, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return "", err
+	}
+	parsedDuration, err := time.ParseDuration(duration)
+	if err != nil {
+		return "", err
+	}
+	return parsedDate.Add(parsedDuration).Format(time.RFC3339), nil
+}
+
+// scriptFormatDate форматирует дату в формате RFC3339 по шаблону Go, например 2006-01-02
+func scriptFormatDate(date string, layout string) (string, error) {
+	parsedDate, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return "", err
+	}
+	return parsedDate.Format(layout), nil
+}
@@ -0,0 +1,56 @@
+package scenarios
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unimock/states"
+	"unimock/templates"
+	"unimock/util"
+)
+
+func TestScriptStep(t *testing.T) {
+	service, templateService, stateService := newTestService(t)
+	require.NoError(t, stateService.AddMachine(&states.Machine{
+		Name: "orders", KeyType: templates.JsonPathExtractorType, KeyExpression: "$.id",
+	}))
+	template := &templates.Template{Name: "total", Body: `{"id":"${id}","total":${total},"items":${count},"state":"${state}","due":"${due}"}`}
+	require.NoError(t, templateService.AddTemplate(template, ""))
+	require.NoError(t, templateService.AddExtractor(&templates.Extractor{
+		TemplateId: template.Id, Name: "id", ExtractorType: templates.JsonPathExtractorType, Expression: "$.id",
+	}))
+
+	script := &ScriptStepConfig{Variables: []*ScriptVariable{
+		{Name: "total", Expression: `round(sum($.body.items[*].price), 2)`},
+		{Name: "count", Expression: `count(body.items)`},
+		{Name: "state", Expression: `lower(state.orders)`},
+		{Name: "due", Expression: `formatDate(dateAdd(body.created, "48h"), "2006-01-02")`},
+		{Name: "id", Expression: `"overridden"`},
+	}}
+	require.NoError(t, service.AddStep(&ScenarioStep{OrderNumber: 1, TriggerId: 1, StepType: Script,
+		Config: CreateStepConfig(script)}, ""))
+	require.NoError(t, service.AddStep(&ScenarioStep{OrderNumber: 2, TriggerId: 1, StepType: TemplateProcessing,
+		Config: CreateStepConfig(&TemplateStepConfig{TemplateId: template.Id})}, ""))
+
+	input := &util.Message{Body: `{"id": "A-1", "created": "2024-02-28T10:00:00Z", "items": [{"price": 1.1}, {"price": 2.2}]}`}
+	output, err := service.ProcessMessage(context.Background(), input, 1)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id":"A-1","total":3.3,"items":2,"state":"started","due":"2024-03-01"}`, output.Body,
+		"Извлечённые шаблоном значения важнее переменных")
+	require.Equal(t, "3.3", output.Variables["total"])
+
+	failing := &ScenarioStep{TriggerId: 2, StepType: Script,
+		Config: json.RawMessage(`{"variables": [{"name": "x", "expression": "dateAdd(body, \"1d\")"}]}`)}
+	require.NoError(t, service.AddStep(failing, ""))
+	_, err = service.ProcessMessage(context.Background(), &util.Message{Body: "text"}, 2)
+	require.IsType(t, &ScriptException{}, err)
+
+	for _, config := range []string{
+		`{"variables": []}`,
+		`{"variables": [{"name": "bad name", "expression": "1"}]}`,
+		`{"variables": [{"name": "x", "expression": "1 +"}]}`,
+	} {
+		require.Error(t, service.AddStep(&ScenarioStep{TriggerId: 2, StepType: Script, Config: json.RawMessage(config)}, ""), config)
+	}
+}
@@ -0,0 +1,146 @@
+package scenarios
+
+import (
+	"fmt"
+	"sync"
+	"unimock/templates"
+	"unimock/util"
+)
+
+type SequencePolicy string
+
+const (
+	// StickPolicy - после последнего шаблона всегда используется последний
+	StickPolicy SequencePolicy = "stick"
+	// WrapPolicy - после последнего шаблона последовательность начинается сначала
+	WrapPolicy SequencePolicy = "wrap"
+	// FailPolicy - после последнего шаблона шаг завершается ошибкой SequenceExhaustedException
+	FailPolicy SequencePolicy = "fail"
+)
+
+// SequenceStepConfig - параметры шага sequence, который на i-й вызов обрабатывает сообщение i-м шаблоном из
+// TemplateIds. Вызовы считаются для триггера или, если указан KeyType, отдельно для каждого извлечённого ключа
+type SequenceStepConfig struct {
+	TemplateIds []int64 `json:"template_ids"`
+	// Policy - поведение после последнего шаблона, по умолчанию stick
+	Policy        SequencePolicy          `json:"policy,omitempty"`
+	KeyType       templates.ExtractorType `json:"key_type,omitempty"`
+	KeyExpression string                  `json:"key_expression,omitempty"`
+	keyExtractor  templates.MessageExtractor
+}
+
+func (config *SequenceStepConfig) validate() error {
+	if len(config.TemplateIds) == 0 {
+		return &StepValidationException{message: "Не указаны шаблоны шага sequence"}
+	}
+	switch config.Policy {
+	case "":
+		config.Policy = StickPolicy
+	case StickPolicy, WrapPolicy, FailPolicy:
+	default:
+		return &StepValidationException{message: fmt.Sprintf("Неизвестная политика последовательности '%s'", config.Policy)}
+	}
+	if config.KeyType == "" {
+		return nil
+	}
+	var err error
+	if config.keyExtractor, err = templates.NewMessageExtractor(config.KeyType, config.KeyExpression); err != nil {
+		return &StepValidationException{message: err.Error()}
+	}
+	return nil
+}
+
+// templateId возвращает шаблон для вызова с номером call, начиная с 0
+func (config *SequenceStepConfig) templateId(call int) (int64, error) {
+	count := len(config.TemplateIds)
+	switch {
+	case call < count:
+		return config.TemplateIds[call], nil
+	case config.Policy == WrapPolicy:
+		return config.TemplateIds[call%count], nil
+	case config.Policy == FailPolicy:
+		return 0, &SequenceExhaustedException{
+			message: fmt.Sprintf("Последовательность из %d ответов исчерпана", count),
+		}
+	default:
+		return config.TemplateIds[count-1], nil
+	}
+}
+
+// key возвращает ключ счётчика вызовов, без KeyType счётчик общий для триггера
+func (config *SequenceStepConfig) key(message *util.Message) (string, error) {
+	if config.keyExtractor == nil {
+		return "", nil
+	}
+	key, ok := config.keyExtractor.Extract(message)
+	if !ok {
+		return "", &StepValidationException{
+			message: fmt.Sprintf("Не удалось извлечь ключ последовательности выражением %s", config.KeyExpression),
+		}
+	}
+	return key, nil
+}
+
+// maxSequenceCounters - наибольшее число счётчиков в памяти. Ключи извлекаются из сообщений и не ограничены,
+// поэтому при переполнении вытесняется счётчик, который дольше всех не использовался, и его ключ начинает
+// последовательность сначала
+const maxSequenceCounters = 10000
+
+type sequenceCounterKey struct {
+	stepId int64
+	key    string
+}
+
+// sequenceCounters - количество вызовов шагов sequence, хранится в памяти до изменения шага или сброса
+type sequenceCounters struct {
+	mut    sync.Mutex
+	values *util.LRU[sequenceCounterKey, int]
+}
+
+func newSequenceCounters() *sequenceCounters {
+	return &sequenceCounters{values: util.NewLRU[sequenceCounterKey, int](maxSequenceCounters)}
+}
+
+// next возвращает номер текущего вызова, начиная с 0, и увеличивает счётчик
+func (counters *sequenceCounters) next(stepId int64, key string) int {
+	counters.mut.Lock()
+	defer counters.mut.Unlock()
+	counterKey := sequenceCounterKey{stepId: stepId, key: key}
+	call, _ := counters.values.Get(counterKey)
+	counters.values.Put(counterKey, call+1)
+	return call
+}
+
+func (counters *sequenceCounters) reset(stepId int64) {
+	counters.mut.Lock()
+	defer counters.mut.Unlock()
+	counters.values.RemoveIf(func(key sequenceCounterKey) bool { return key.stepId == stepId })
+}
+
+// processSequenceStep обрабатывает сообщение шаблоном, соответствующим номеру вызова. Ключ счётчика извлекается
+// из входящего сообщения
+func (service *ScenarioService) processSequenceStep(step *ScenarioStep, config *SequenceStepConfig,
+	message, inputMessage *util.Message) (*util.Message, error) {
+	key, err := config.key(inputMessage)
+	if err != nil {
+		return nil, err
+	}
+	templateId, err := config.templateId(service.sequences.next(step.Id, key))
+	if err != nil {
+		return nil, err
+	}
+	return service.processTemplateStep(templateId, message, inputMessage)
+}
+
+// ResetSequence сбрасывает счётчики вызовов шага sequence
+func (service *ScenarioService) ResetSequence(stepId int64) error {
+	step, err := service.getStepById(stepId)
+	if err != nil {
+		return err
+	}
+	if _, ok := step.config.(*SequenceStepConfig); !ok {
+		return &StepValidationException{message: fmt.Sprintf("Шаг с id = %d не является шагом sequence", stepId)}
+	}
+	service.sequences.reset(stepId)
+	return nil
+}
@@ -0,0 +1,93 @@
+package scenarios
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unimock/templates"
+	"unimock/util"
+)
+
+func TestSequenceStep(t *testing.T) {
+	service, templateService, _ := newTestService(t)
+	templateIds := make([]int64, 0)
+	for _, body := range []string{"first", "second", "third"} {
+		template := &templates.Template{Name: body, Body: body}
+		require.NoError(t, templateService.AddTemplate(template, ""))
+		templateIds = append(templateIds, template.Id)
+	}
+
+	process := func(triggerId int64, headers map[string]string) (string, error) {
+		output, err := service.ProcessMessage(context.Background(), &util.Message{Headers: headers}, triggerId)
+		if err != nil {
+			return "", err
+		}
+		return output.Body, nil
+	}
+
+	policies := map[int64]SequencePolicy{1: "", 2: WrapPolicy, 3: FailPolicy}
+	for triggerId, policy := range policies {
+		require.NoError(t, service.AddStep(&ScenarioStep{OrderNumber: 1, TriggerId: triggerId, StepType: Sequence,
+			Config: CreateStepConfig(&SequenceStepConfig{TemplateIds: templateIds, Policy: policy})}, ""))
+	}
+	for _, expected := range []string{"first", "second", "third", "third"} {
+		body, err := process(1, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, body)
+	}
+	for _, expected := range []string{"first", "second", "third", "first"} {
+		body, err := process(2, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, body)
+	}
+	for range templateIds {
+		_, err := process(3, nil)
+		require.NoError(t, err)
+	}
+	_, err := process(3, nil)
+	require.IsType(t, &SequenceExhaustedException{}, err)
+
+	step := service.GetOrderedStepsByTriggerId(3)[0]
+	require.NoError(t, service.ResetSequence(step.Id))
+	body, err := process(3, nil)
+	require.NoError(t, err)
+	require.Equal(t, "first", body)
+
+	keyed := &ScenarioStep{OrderNumber: 1, TriggerId: 4, StepType: Sequence,
+		Config: CreateStepConfig(&SequenceStepConfig{TemplateIds: templateIds,
+			KeyType: templates.HeaderExtractorType, KeyExpression: "X-Client"})}
+	require.NoError(t, service.AddStep(keyed, ""))
+	for _, call := range []struct{ client, expected string }{{"a", "first"}, {"a", "second"}, {"b", "first"}} {
+		body, err := process(4, map[string]string{"X-Client": call.client})
+		require.NoError(t, err)
+		require.Equal(t, call.expected, body)
+	}
+	_, err = process(4, nil)
+	require.IsType(t, &StepValidationException{}, err)
+
+	require.NoError(t, service.UpdateStep(keyed, ""))
+	body, err = process(4, map[string]string{"X-Client": "a"})
+	require.NoError(t, err)
+	require.Equal(t, "first", body)
+
+	require.Error(t, service.AddStep(&ScenarioStep{OrderNumber: 1, TriggerId: 5, StepType: Sequence,
+		Config: CreateStepConfig(&SequenceStepConfig{})}, ""))
+	require.Error(t, service.AddStep(&ScenarioStep{OrderNumber: 1, TriggerId: 5, StepType: Sequence,
+		Config: CreateStepConfig(&SequenceStepConfig{TemplateIds: []int64{100}})}, ""))
+	require.Error(t, service.AddStep(&ScenarioStep{OrderNumber: 1, TriggerId: 5, StepType: Sequence,
+		Config: CreateStepConfig(&SequenceStepConfig{TemplateIds: templateIds, Policy: "random"})}, ""))
+}
+
+func TestSequenceCountersEviction(t *testing.T) {
+	counters := &sequenceCounters{values: util.NewLRU[sequenceCounterKey, int](2)}
+	require.Equal(t, 0, counters.next(1, "a"))
+	require.Equal(t, 0, counters.next(1, "b"))
+	require.Equal(t, 1, counters.next(1, "a"))
+	require.Equal(t, 0, counters.next(1, "c"))
+	require.Equal(t, 2, counters.next(1, "a"), "Недавно использованный счётчик не вытесняется")
+	require.Equal(t, 0, counters.next(1, "b"), "Давно не использованный счётчик вытеснен")
+
+	counters.next(2, "a")
+	counters.reset(1)
+	require.Equal(t, 1, counters.values.Len())
+}
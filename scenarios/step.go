@@ -0,0 +1,435 @@
+package scenarios
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+	"unimock/util"
+)
+
+type ScenarioStep struct {
+	Id          int64            `json:"id"`
+	OrderNumber int              `json:"order_number"`
+	TriggerId   int64            `json:"trigger_id"`
+	StepType    ScenarioStepType `json:"step_type"`
+	// Label - метка шага для перехода из шага if
+	Label string `json:"label,omitempty"`
+	// Config - параметры шага в JSON, структура зависит от StepType
+	Config json.RawMessage `json:"config"`
+	config StepConfig
+}
+
+type ScenarioStepType string
+
+const (
+	TemplateProcessing ScenarioStepType = "template_processing"
+	Delay              ScenarioStepType = "delay"
+	Proxy              ScenarioStepType = "proxy"
+	Fault              ScenarioStepType = "fault"
+	Callback           ScenarioStepType = "callback"
+	SetState           ScenarioStepType = "set_state"
+	If                 ScenarioStepType = "if"
+	Script             ScenarioStepType = "script"
+	Convert            ScenarioStepType = "convert"
+	Sequence           ScenarioStepType = "sequence"
+)
+
+// StepConfig - разобранные параметры шага определённого типа
+type StepConfig interface {
+	validate() error
+}
+
+// TemplateStepConfig - параметры шага template_processing, который обрабатывает сообщение шаблоном TemplateId
+type TemplateStepConfig struct {
+	TemplateId int64 `json:"template_id"`
+}
+
+func (config *TemplateStepConfig) validate() error {
+	if config.TemplateId <= 0 {
+		return &StepValidationException{message: "Не указан шаблон шага template_processing"}
+	}
+	return nil
+}
+
+// seededRandom - генератор случайных чисел шага. Seed задаётся в параметрах шага, чтобы прогон можно было
+// воспроизвести, без него используется текущее время
+type seededRandom struct {
+	Seed *int64 `json:"seed,omitempty"`
+
+	random *rand.Rand
+	mut    sync.Mutex
+}
+
+// use вызывает action с генератором, генератор создаётся при первом обращении
+func (seeded *seededRandom) use(action func(random *rand.Rand)) {
+	seeded.mut.Lock()
+	defer seeded.mut.Unlock()
+
+	if seeded.random == nil {
+		seed := time.Now().UnixNano()
+		if seeded.Seed != nil {
+			seed = *seeded.Seed
+		}
+		seeded.random = rand.New(rand.NewSource(seed))
+	}
+	action(seeded.random)
+}
+
+type DelayMode string
+
+const (
+	FixedDelay      DelayMode = "fixed"
+	UniformDelay    DelayMode = "uniform"
+	NormalDelay     DelayMode = "normal"
+	LogNormalDelay  DelayMode = "lognormal"
+	PercentileDelay DelayMode = "percentiles"
+)
+
+// DelayStepConfig - параметры шага delay. Задержка выбирается по распределению Mode, для случайных режимов
+// можно задать seed
+type DelayStepConfig struct {
+	// Mode - режим задержки, по умолчанию fixed
+	Mode DelayMode `json:"mode,omitempty"`
+	// Ms - задержка режима fixed
+	Ms int64 `json:"ms"`
+	// MinMs и MaxMs - границы режима uniform, в остальных случайных режимах ограничивают полученное значение
+	MinMs int64 `json:"min_ms,omitempty"`
+	MaxMs int64 `json:"max_ms,omitempty"`
+	// MeanMs и StdDevMs - среднее и стандартное отклонение режима normal
+	MeanMs   float64 `json:"mean_ms,omitempty"`
+	StdDevMs float64 `json:"std_dev_ms,omitempty"`
+	// MedianMs и Sigma - медиана задержки и стандартное отклонение её логарифма в режиме lognormal
+	MedianMs float64 `json:"median_ms,omitempty"`
+	Sigma    float64 `json:"sigma,omitempty"`
+	// P50Ms, P95Ms и P99Ms - перцентили режима percentiles, между ними задержка интерполируется линейно
+	P50Ms int64 `json:"p50_ms,omitempty"`
+	P95Ms int64 `json:"p95_ms,omitempty"`
+	P99Ms int64 `json:"p99_ms,omitempty"`
+	seededRandom
+}
+
+func (config *DelayStepConfig) validate() error {
+	if config.MinMs < 0 || config.MaxMs < 0 {
+		return &StepValidationException{message: "Границы задержки шага delay не могут быть отрицательными"}
+	}
+	if config.MaxMs > 0 && config.MinMs > config.MaxMs {
+		return &StepValidationException{message: "Минимальная задержка шага delay больше максимальной"}
+	}
+
+	switch config.Mode {
+	case "", FixedDelay:
+		if config.Ms < 0 {
+			return &StepValidationException{message: "Задержка шага delay не может быть отрицательной"}
+		}
+	case UniformDelay:
+		if config.MaxMs == 0 {
+			return &StepValidationException{message: "Не указана максимальная задержка режима uniform"}
+		}
+	case NormalDelay:
+		if config.MeanMs < 0 || config.StdDevMs < 0 {
+			return &StepValidationException{message: "Параметры режима normal не могут быть отрицательными"}
+		}
+	case LogNormalDelay:
+		if config.MedianMs <= 0 || config.Sigma < 0 {
+			return &StepValidationException{message: "Медиана режима lognormal должна быть положительной, sigma - неотрицательной"}
+		}
+	case PercentileDelay:
+		if config.P50Ms < config.MinMs || config.P95Ms < config.P50Ms || config.P99Ms < config.P95Ms {
+			return &StepValidationException{message: "Перцентили режима percentiles должны не убывать: min_ms <= p50_ms <= p95_ms <= p99_ms"}
+		}
+	default:
+		return &StepValidationException{message: fmt.Sprintf("Неизвестный режим задержки %s", config.Mode)}
+	}
+	return nil
+}
+
+// duration возвращает задержку для очередного сообщения
+func (config *DelayStepConfig) duration() time.Duration {
+	if config.Mode == "" || config.Mode == FixedDelay {
+		return time.Duration(config.Ms) * time.Millisecond
+	}
+
+	var ms float64
+	config.use(func(random *rand.Rand) {
+		switch config.Mode {
+		case UniformDelay:
+			ms = float64(config.MinMs) + random.Float64()*float64(config.MaxMs-config.MinMs)
+		case NormalDelay:
+			ms = config.MeanMs + random.NormFloat64()*config.StdDevMs
+		case LogNormalDelay:
+			ms = config.MedianMs * math.Exp(random.NormFloat64()*config.Sigma)
+		case PercentileDelay:
+			ms = config.percentile(random.Float64())
+		}
+	})
+
+	ms = math.Max(ms, float64(config.MinMs))
+	if config.MaxMs > 0 {
+		ms = math.Min(ms, float64(config.MaxMs))
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// percentile возвращает задержку для квантиля q, интерполируя между min_ms, p50_ms, p95_ms, p99_ms и max_ms
+func (config *DelayStepConfig) percentile(q float64) float64 {
+	upper := config.P99Ms
+	if config.MaxMs > upper {
+		upper = config.MaxMs
+	}
+	points := []struct{ q, ms float64 }{
+		{0, float64(config.MinMs)},
+		{0.5, float64(config.P50Ms)},
+		{0.95, float64(config.P95Ms)},
+		{0.99, float64(config.P99Ms)},
+		{1, float64(upper)},
+	}
+	for i := 1; i < len(points); i++ {
+		if q <= points[i].q {
+			low, high := points[i-1], points[i]
+			return low.ms + (q-low.q)/(high.q-low.q)*(high.ms-low.ms)
+		}
+	}
+	return float64(upper)
+}
+
+// ProxyStepConfig - параметры шага proxy, который отправляет текущее сообщение по Url и продолжает сценарий с ответом
+type ProxyStepConfig struct {
+	Url string `json:"url"`
+	// AppendPath - добавлять к Url путь исходного запроса
+	AppendPath bool  `json:"append_path"`
+	TimeoutMs  int64 `json:"timeout_ms"`
+}
+
+func (config *ProxyStepConfig) validate() error {
+	if _, err := url.ParseRequestURI(config.Url); err != nil {
+		return &StepValidationException{message: fmt.Sprintf("Некорректный url шага proxy: %v", err)}
+	}
+	if config.TimeoutMs < 0 {
+		return &StepValidationException{message: "Таймаут шага proxy не может быть отрицательным"}
+	}
+	return nil
+}
+
+// FailFault - сбой шага fault, при котором сценарий прерывается ответом с кодом StatusCode
+const FailFault util.FaultType = "fail"
+
+// FaultStepConfig - параметры шага fault, который с вероятностью Probability воспроизводит сетевой сбой
+// при отправке ответа
+type FaultStepConfig struct {
+	Fault util.FaultType `json:"fault"`
+	// Probability - вероятность сбоя от 0 до 1, если не указана, сбой происходит всегда
+	Probability *float64 `json:"probability,omitempty"`
+	// BytesPerSecond - скорость отправки тела для сбоя trickle
+	BytesPerSecond int64 `json:"bytes_per_second,omitempty"`
+	// StatusCode - код ответа для сбоя fail, по умолчанию 500
+	StatusCode int `json:"status_code,omitempty"`
+	seededRandom
+}
+
+func (config *FaultStepConfig) validate() error {
+	switch config.Fault {
+	case util.FaultReset, util.FaultClose, util.FaultEmptyBody, util.FaultMalformedChunked:
+	case util.FaultTrickle:
+		if config.BytesPerSecond <= 0 {
+			return &StepValidationException{message: "Скорость сбоя trickle должна быть положительной"}
+		}
+	case FailFault:
+		if config.StatusCode != 0 && (config.StatusCode < 100 || config.StatusCode > 599) {
+			return &StepValidationException{message: fmt.Sprintf("Некорректный код ответа сбоя fail: %d", config.StatusCode)}
+		}
+	default:
+		return &StepValidationException{message: fmt.Sprintf("Неизвестный тип сбоя %s", config.Fault)}
+	}
+	if config.Probability != nil && (*config.Probability < 0 || *config.Probability > 1) {
+		return &StepValidationException{message: "Вероятность сбоя должна быть от 0 до 1"}
+	}
+	return nil
+}
+
+// happens определяет, происходит ли сбой для очередного сообщения
+func (config *FaultStepConfig) happens() bool {
+	if config.Probability == nil {
+		return true
+	}
+	happens := false
+	config.use(func(random *rand.Rand) {
+		happens = random.Float64() < *config.Probability
+	})
+	return happens
+}
+
+// apply возвращает копию сообщения с сбоем или ошибку для сбоя fail
+func (config *FaultStepConfig) apply(message *util.Message) (*util.Message, error) {
+	if config.Fault == FailFault {
+		statusCode := config.StatusCode
+		if statusCode == 0 {
+			statusCode = http.StatusInternalServerError
+		}
+		return nil, &FaultException{message: "Сбой, заданный шагом сценария", statusCode: statusCode}
+	}
+
+	faulted := *message
+	faulted.Fault = &util.Fault{Type: config.Fault, BytesPerSecond: config.BytesPerSecond}
+	return &faulted, nil
+}
+
+// CallbackStepConfig - параметры шага callback, который отправляет HTTP запрос с телом и заголовками шаблона
+// TemplateId, применённого к текущему сообщению. Ответ на запрос не меняет сообщение сценария
+type CallbackStepConfig struct {
+	TemplateId int64 `json:"template_id"`
+	// Url - адрес запроса, может содержать плейсхолдеры шаблона
+	Url string `json:"url"`
+	// Method - метод запроса, по умолчанию POST
+	Method string `json:"method,omitempty"`
+	// Async - отправить запрос после завершения сценария, не задерживая ответ
+	Async   bool  `json:"async"`
+	DelayMs int64 `json:"delay_ms,omitempty"`
+	// Retries - количество повторов при ошибке соединения или коде ответа 5xx
+	Retries      int   `json:"retries,omitempty"`
+	RetryDelayMs int64 `json:"retry_delay_ms,omitempty"`
+	TimeoutMs    int64 `json:"timeout_ms,omitempty"`
+}
+
+func (config *CallbackStepConfig) validate() error {
+	if config.TemplateId <= 0 {
+		return &StepValidationException{message: "Не указан шаблон шага callback"}
+	}
+	if config.Url == "" {
+		return &StepValidationException{message: "Не указан url шага callback"}
+	}
+	if config.DelayMs < 0 || config.RetryDelayMs < 0 || config.TimeoutMs < 0 || config.Retries < 0 {
+		return &StepValidationException{message: "Задержки, таймаут и количество повторов шага callback не могут быть отрицательными"}
+	}
+	return nil
+}
+
+// SetStateStepConfig - параметры шага set_state, который переводит ключ входящего сообщения в автомате
+// StateMachine в состояние State
+type SetStateStepConfig struct {
+	StateMachine string `json:"state_machine"`
+	State        string `json:"state"`
+}
+
+func (config *SetStateStepConfig) validate() error {
+	if config.StateMachine == "" || config.State == "" {
+		return &StepValidationException{message: "Не указаны автомат или состояние шага set_state"}
+	}
+	return nil
+}
+
+// MessageMatcher - условие на сообщение, например выражение триггера
+type MessageMatcher interface {
+	TriggerOnMessage(message *util.Message) bool
+}
+
+// MatcherFactory создаёт условие шага if по типу и выражению. Типы выражений совпадают с типами триггеров
+type MatcherFactory func(matcherType string, expression string) (MessageMatcher, error)
+
+// IfStepConfig - параметры шага if. Если условие выполнено для текущего сообщения, сценарий переходит к следующему
+// шагу с меткой Goto или пропускает Skip следующих шагов, иначе продолжается со следующего шага
+type IfStepConfig struct {
+	// MatcherType - тип выражения: regex, gson, jsonpath или xpath
+	MatcherType string `json:"type"`
+	Expression  string `json:"expression"`
+	// Negate - переходить, если условие не выполнено
+	Negate  bool   `json:"negate,omitempty"`
+	Goto    string `json:"goto,omitempty"`
+	Skip    int    `json:"skip,omitempty"`
+	matcher MessageMatcher
+}
+
+func (config *IfStepConfig) validate() error {
+	if config.MatcherType == "" {
+		return &StepValidationException{message: "Не указан тип условия шага if"}
+	}
+	if (config.Goto == "") == (config.Skip == 0) {
+		return &StepValidationException{message: "Для шага if указывается либо метка перехода, либо количество пропускаемых шагов"}
+	}
+	if config.Skip < 0 {
+		return &StepValidationException{message: "Количество пропускаемых шагов не может быть отрицательным"}
+	}
+	return nil
+}
+
+// matches проверяет условие шага с учётом Negate
+func (config *IfStepConfig) matches(message *util.Message) bool {
+	return config.matcher.TriggerOnMessage(message) != config.Negate
+}
+
+func newStepConfig(stepType ScenarioStepType) (StepConfig, error) {
+	switch stepType {
+	case TemplateProcessing:
+		return new(TemplateStepConfig), nil
+	case Delay:
+		return new(DelayStepConfig), nil
+	case Proxy:
+		return new(ProxyStepConfig), nil
+	case Fault:
+		return new(FaultStepConfig), nil
+	case Callback:
+		return new(CallbackStepConfig), nil
+	case SetState:
+		return new(SetStateStepConfig), nil
+	case If:
+		return new(IfStepConfig), nil
+	case Script:
+		return new(ScriptStepConfig), nil
+	case Convert:
+		return new(ConvertStepConfig), nil
+	case Sequence:
+		return new(SequenceStepConfig), nil
+	default:
+		return nil, &StepValidationException{message: fmt.Sprintf("Неизвестный тип шага %s", stepType)}
+	}
+}
+
+// CreateStepConfig сериализует параметры шага для поля Config
+func CreateStepConfig(config StepConfig) json.RawMessage {
+	result, _ := json.Marshal(config)
+	return result
+}
+
+// prepare разбирает и проверяет параметры шага в соответствии с его типом
+func (step *ScenarioStep) prepare() error {
+	config, err := newStepConfig(step.StepType)
+	if err != nil {
+		return err
+	}
+	if len(step.Config) == 0 {
+		return &StepValidationException{message: fmt.Sprintf("Не указаны параметры шага %s", step.StepType)}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(step.Config))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(config); err != nil {
+		return &StepValidationException{message: fmt.Sprintf("Некорректные параметры шага %s: %v", step.StepType, err)}
+	}
+	if err = config.validate(); err != nil {
+		return err
+	}
+
+	step.config = config
+	return nil
+}
+
+func (step *ScenarioStep) configForDb() string {
+	return string(step.Config)
+}
+
+func (step *ScenarioStep) setConfigFromDb(config string) {
+	if config != "" {
+		step.Config = json.RawMessage(config)
+	}
+}
+
+type Steps []*ScenarioStep
+
+func (steps Steps) Len() int           { return len(steps) }
+func (steps Steps) Swap(i, j int)      { steps[i], steps[j] = steps[j], steps[i] }
+func (steps Steps) Less(i, j int) bool { return steps[i].OrderNumber < steps[j].OrderNumber }
@@ -0,0 +1,15 @@
+create table if not exists template_extractors
+(
+    id          INTEGER not null
+        primary key autoincrement,
+    template_id INTEGER not null
+        constraint template_extractors_templates_id_fk
+            references templates
+            on update cascade on delete cascade,
+    number      INTEGER not null,
+    type        TEXT    not null,
+    expression  TEXT    not null
+);
+
+create unique index if not exists template_extractors_template_number_uindex
+    on template_extractors (template_id, number);
@@ -0,0 +1,21 @@
+create table if not exists state_machines
+(
+    id             INTEGER not null
+        primary key autoincrement,
+    name           TEXT    not null,
+    key_type       TEXT    not null,
+    key_expression TEXT    not null,
+    initial_state  TEXT    not null
+);
+
+create unique index if not exists state_machines_name_uindex
+    on state_machines (name);
+
+alter table triggers add column state_machine TEXT default '' not null;
+
+alter table triggers add column state TEXT default '' not null;
+
+drop index if exists Triggers_expression_header_method_path_query;
+
+create unique index if not exists Triggers_expression_header_method_path_query_state
+    on triggers (expression, headers, method, path, query, state_machine, state);
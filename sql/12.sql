@@ -0,0 +1 @@
+alter table scenario_steps add column label TEXT default '' not null;
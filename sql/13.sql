@@ -0,0 +1 @@
+alter table templates add column engine TEXT default 'simple' not null;
@@ -0,0 +1,44 @@
+create table if not exists template_versions
+(
+    id         INTEGER not null
+        primary key autoincrement,
+    entity_id  INTEGER not null,
+    version    INTEGER not null,
+    operation  TEXT    not null,
+    author     TEXT    not null,
+    created_at INTEGER not null,
+    snapshot   TEXT    not null
+);
+
+create unique index if not exists template_versions_entity_id_version_uindex
+    on template_versions (entity_id, version);
+
+create table if not exists trigger_versions
+(
+    id         INTEGER not null
+        primary key autoincrement,
+    entity_id  INTEGER not null,
+    version    INTEGER not null,
+    operation  TEXT    not null,
+    author     TEXT    not null,
+    created_at INTEGER not null,
+    snapshot   TEXT    not null
+);
+
+create unique index if not exists trigger_versions_entity_id_version_uindex
+    on trigger_versions (entity_id, version);
+
+create table if not exists scenario_step_versions
+(
+    id         INTEGER not null
+        primary key autoincrement,
+    entity_id  INTEGER not null,
+    version    INTEGER not null,
+    operation  TEXT    not null,
+    author     TEXT    not null,
+    created_at INTEGER not null,
+    snapshot   TEXT    not null
+);
+
+create unique index if not exists scenario_step_versions_entity_id_version_uindex
+    on scenario_step_versions (entity_id, version);
@@ -0,0 +1,3 @@
+alter table templates add column content_type TEXT default '' not null;
+alter table templates add column binary_body BLOB;
+alter table templates add column file TEXT default '' not null;
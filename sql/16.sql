@@ -0,0 +1,7 @@
+create table if not exists template_sequences
+(
+    template_id INTEGER not null,
+    name        TEXT    not null,
+    value       INTEGER not null,
+    primary key (template_id, name)
+);
@@ -0,0 +1,24 @@
+-- Заголовки и параметры запроса триггеров хранятся в JSON вместо формата "ключ=значение, "
+update triggers
+set headers = coalesce(nullif((with recursive pairs(pair, rest) as (select '', coalesce(triggers.headers, '') || ','
+                                                                   union all
+                                                                   select trim(substr(rest, 1, instr(rest, ',') - 1)),
+                                                                          substr(rest, instr(rest, ',') + 1)
+                                                                   from pairs
+                                                                   where rest <> '')
+                               select json_group_object(substr(pair, 1, instr(pair, '=') - 1),
+                                                        substr(pair, instr(pair, '=') + 1))
+                               from pairs
+                               where instr(pair, '=') > 0), '{}'), '');
+
+update triggers
+set query = coalesce(nullif((with recursive pairs(pair, rest) as (select '', triggers.query || ','
+                                                                 union all
+                                                                 select trim(substr(rest, 1, instr(rest, ',') - 1)),
+                                                                        substr(rest, instr(rest, ',') + 1)
+                                                                 from pairs
+                                                                 where rest <> '')
+                             select json_group_object(substr(pair, 1, instr(pair, '=') - 1),
+                                                      substr(pair, instr(pair, '=') + 1))
+                             from pairs
+                             where instr(pair, '=') > 0), '{}'), '');
@@ -0,0 +1 @@
+alter table request_journal add column near_misses TEXT;
@@ -0,0 +1,25 @@
+create table if not exists template_extractors_new
+(
+    id          INTEGER not null
+        primary key autoincrement,
+    template_id INTEGER not null
+        constraint template_extractors_templates_id_fk
+            references templates
+            on update cascade on delete cascade,
+    name        TEXT    not null,
+    type        TEXT    not null,
+    expression  TEXT    not null
+);
+
+insert into template_extractors_new (id, template_id, name, type, expression)
+select id, template_id, cast(number as TEXT), type, expression
+from template_extractors;
+
+drop table template_extractors;
+
+alter table template_extractors_new rename to template_extractors;
+
+create unique index if not exists template_extractors_template_name_uindex
+    on template_extractors (template_id, name);
+
+alter table templates add column strict BOOLEAN default 0 not null;
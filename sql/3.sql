@@ -0,0 +1,3 @@
+alter table templates add column status_code INTEGER default 0 not null;
+
+alter table templates add column headers TEXT default '' not null;
@@ -0,0 +1,10 @@
+alter table triggers add column method TEXT default '' not null;
+
+alter table triggers add column path TEXT default '' not null;
+
+alter table triggers add column query CHAR(700) default '' not null;
+
+drop index if exists Triggers_expression_header;
+
+create unique index if not exists Triggers_expression_header_method_path_query
+    on triggers (expression, headers, method, path, query);
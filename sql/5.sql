@@ -0,0 +1 @@
+alter table triggers add column priority INTEGER default 0 not null;
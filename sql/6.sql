@@ -0,0 +1,15 @@
+create table if not exists request_journal
+(
+    id          INTEGER not null
+        primary key,
+    timestamp   INTEGER not null,
+    trigger_id  INTEGER not null,
+    subsystem   TEXT    not null,
+    duration_ms REAL    not null,
+    request     TEXT    not null,
+    response    TEXT,
+    error       TEXT    not null
+);
+
+create index if not exists request_journal_timestamp_index
+    on request_journal (timestamp);
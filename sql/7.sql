@@ -0,0 +1 @@
+alter table request_journal add column proxied BOOLEAN default 0 not null;
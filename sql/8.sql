@@ -0,0 +1 @@
+alter table scenario_steps add column config TEXT default '' not null;
@@ -0,0 +1,16 @@
+-- Шаги template_processing без шаблона не могут выполниться и не проходят проверку параметров
+delete
+from scenario_steps
+where step_type = 'template_processing'
+  and config = ''
+  and coalesce(cast(value as INTEGER), 0) = 0;
+
+update scenario_steps
+set config = '{"template_id":' || cast(value as INTEGER) || '}'
+where step_type = 'template_processing' and config = '';
+
+update scenario_steps
+set config = '{"ms":' || coalesce(cast(value as INTEGER), 0) || '}'
+where step_type = 'delay' and config = '';
+
+alter table scenario_steps drop column value;
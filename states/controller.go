@@ -0,0 +1,100 @@
+package states
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"unimock/util"
+)
+
+type StateHandler struct {
+	stateService *StateService
+}
+
+func NewHandler(service *StateService) *StateHandler {
+	return &StateHandler{
+		stateService: service,
+	}
+}
+
+// StateUpdate - тело запроса на установку состояния ключа
+type StateUpdate struct {
+	State string `json:"state"`
+}
+
+func (handler *StateHandler) GetMachines(context *fiber.Ctx) error {
+	return context.JSON(handler.stateService.GetMachines())
+}
+
+func (handler *StateHandler) GetMachineById(context *fiber.Ctx) error {
+	id, err := strconv.ParseInt(context.Params("id"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("id", err)
+	}
+	machine, err := handler.stateService.GetMachineById(id)
+	if err != nil {
+		return err
+	}
+	return context.JSON(machine)
+}
+
+func (handler *StateHandler) AddMachine(context *fiber.Ctx) error {
+	machine := new(Machine)
+	if err := json.Unmarshal(context.Body(), machine); err != nil {
+		return &MachineValidationException{message: err.Error()}
+	}
+	if err := handler.stateService.AddMachine(machine); err != nil {
+		return err
+	}
+	return context.JSON(machine)
+}
+
+func (handler *StateHandler) UpdateMachine(context *fiber.Ctx) error {
+	machine := new(Machine)
+	if err := json.Unmarshal(context.Body(), machine); err != nil {
+		return &MachineValidationException{message: err.Error()}
+	}
+	id, err := strconv.ParseInt(context.Params("id"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("id", err)
+	}
+	machine.Id = id
+	return handler.stateService.UpdateMachine(machine)
+}
+
+func (handler *StateHandler) DeleteMachine(context *fiber.Ctx) error {
+	id, err := strconv.ParseInt(context.Params("id"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("id", err)
+	}
+	return handler.stateService.DeleteMachine(id)
+}
+
+func (handler *StateHandler) GetStates(context *fiber.Ctx) error {
+	states, err := handler.stateService.GetStates(context.Params("name"))
+	if err != nil {
+		return err
+	}
+	return context.JSON(states)
+}
+
+func (handler *StateHandler) PutState(context *fiber.Ctx) error {
+	update := new(StateUpdate)
+	if err := json.Unmarshal(context.Body(), update); err != nil {
+		return &MachineValidationException{message: err.Error()}
+	}
+	if update.State == "" {
+		return &MachineValidationException{message: "Не указано состояние"}
+	}
+	// Ключ сохраняется в сервисе, поэтому копируется из буфера запроса
+	return handler.stateService.PutState(context.Params("name"), string([]byte(context.Params("key"))), update.State)
+}
+
+func (handler *StateHandler) ResetStates(context *fiber.Ctx) error {
+	return handler.stateService.ResetStates(context.Params("name"), context.Params("key"))
+}
+
+func (handler *StateHandler) ResetAllStates(context *fiber.Ctx) error {
+	handler.stateService.ResetAll()
+	return nil
+}
@@ -0,0 +1,35 @@
+package states
+
+type MachineValidationException struct {
+	message string
+}
+
+func (e *MachineValidationException) Error() string {
+	return e.message
+}
+
+type MachineNotFoundException struct {
+	message string
+}
+
+func (e *MachineNotFoundException) Error() string {
+	return e.message
+}
+
+// StateKeyNotFoundException - из сообщения не удалось извлечь ключ состояния
+type StateKeyNotFoundException struct {
+	message string
+}
+
+func (e *StateKeyNotFoundException) Error() string {
+	return e.message
+}
+
+// MachineInUseException - автомат нельзя удалить или переименовать, пока на него ссылаются триггеры или шаги
+type MachineInUseException struct {
+	message string
+}
+
+func (e *MachineInUseException) Error() string {
+	return e.message
+}
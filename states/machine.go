@@ -0,0 +1,58 @@
+package states
+
+import (
+	"fmt"
+	"regexp"
+	"unimock/templates"
+	"unimock/util"
+)
+
+// DefaultInitialState - состояние ключа, для которого состояние ещё не устанавливалось
+const DefaultInitialState = "STARTED"
+
+var machineNameRegexp = regexp.MustCompile(`^[\w.\-]+$`)
+
+// Machine - именованный автомат состояний. Состояние хранится отдельно для каждого ключа, который извлекается
+// из сообщения выражением KeyExpression типа KeyType, например для каждого id заказа
+type Machine struct {
+	Id            int64                   `json:"id"`
+	Name          string                  `json:"name"`
+	KeyType       templates.ExtractorType `json:"key_type"`
+	KeyExpression string                  `json:"key_expression"`
+	// InitialState - состояние нового ключа, по умолчанию STARTED
+	InitialState string `json:"initial_state"`
+	keyExtractor templates.MessageExtractor
+}
+
+func (machine *Machine) validate() error {
+	if !machineNameRegexp.MatchString(machine.Name) {
+		return &MachineValidationException{message: fmt.Sprintf("Некорректное имя автомата состояний '%s'", machine.Name)}
+	}
+	if machine.KeyType == "" {
+		return &MachineValidationException{message: "Не указан тип извлечения ключа состояния"}
+	}
+	return nil
+}
+
+func (machine *Machine) prepare() error {
+	if machine.InitialState == "" {
+		machine.InitialState = DefaultInitialState
+	}
+	extractor, err := templates.NewMessageExtractor(machine.KeyType, machine.KeyExpression)
+	if err != nil {
+		return &MachineValidationException{message: err.Error()}
+	}
+	machine.keyExtractor = extractor
+	return nil
+}
+
+// Key извлекает из сообщения ключ, для которого хранится состояние
+func (machine *Machine) Key(message *util.Message) (string, error) {
+	key, ok := machine.keyExtractor.Extract(message)
+	if !ok {
+		return "", &StateKeyNotFoundException{
+			message: fmt.Sprintf("Не удалось извлечь ключ автомата состояний %s из сообщения", machine.Name),
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,307 @@
+package states
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unimock/util"
+)
+
+const SelectAllQuery = "SELECT id, name, key_type, key_expression, initial_state FROM state_machines"
+const InsertQuery = "INSERT INTO state_machines (name, key_type, key_expression, initial_state) VALUES (?,?,?,?)"
+const UpdateQuery = "UPDATE state_machines SET name = ?, key_type = ?, key_expression = ?, initial_state = ? where id = ?"
+const DeleteQuery = "DELETE FROM state_machines WHERE id = ?"
+
+// maxStates - наибольшее число ключей в памяти по всем автоматам. Ключи извлекаются из сообщений и не ограничены,
+// поэтому при переполнении вытесняется ключ, который дольше всех не использовался, и он возвращается
+// в начальное состояние
+const maxStates = 100000
+
+// stateKey - ключ сообщения в автомате machine
+type stateKey struct {
+	machine string
+	key     string
+}
+
+// MachineUsage возвращает описания объектов, которые ссылаются на автомат с именем name
+type MachineUsage func(name string) []string
+
+// StateService хранит описания автоматов в БД, а текущие состояния ключей - в памяти, до перезапуска или сброса
+type StateService struct {
+	machines map[int64]*Machine
+	// states - состояния ключей автоматов
+	states *util.LRU[stateKey, string]
+	// usages проверяют ссылки на автомат перед удалением и переименованием, их регистрируют сервисы
+	// триггеров и сценариев, от которых пакет states не зависит
+	usages []MachineUsage
+	db     *sql.DB
+	mut    sync.RWMutex
+}
+
+func NewService(db *sql.DB) *StateService {
+	return &StateService{
+		machines: make(map[int64]*Machine),
+		states:   util.NewLRU[stateKey, string](maxStates),
+		db:       db,
+	}
+}
+
+// AddUsage регистрирует проверку ссылок на автоматы
+func (service *StateService) AddUsage(usage MachineUsage) {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+	service.usages = append(service.usages, usage)
+}
+
+// checkNotUsed возвращает MachineInUseException, если на автомат name есть ссылки
+func (service *StateService) checkNotUsed(name string) error {
+	references := make([]string, 0)
+	for _, usage := range service.usages {
+		references = append(references, usage(name)...)
+	}
+	if len(references) == 0 {
+		return nil
+	}
+	return &MachineInUseException{
+		message: fmt.Sprintf("На автомат %s ссылаются: %s", name, strings.Join(references, ", ")),
+	}
+}
+
+func (service *StateService) GetMachines() []*Machine {
+	service.mut.RLock()
+	defer service.mut.RUnlock()
+
+	machines := make([]*Machine, 0, len(service.machines))
+	for _, machine := range service.machines {
+		machines = append(machines, machine)
+	}
+	sort.Slice(machines, func(i, j int) bool { return machines[i].Id < machines[j].Id })
+	return machines
+}
+
+func (service *StateService) GetMachineById(id int64) (*Machine, error) {
+	service.mut.RLock()
+	defer service.mut.RUnlock()
+
+	machine, ok := service.machines[id]
+	if !ok {
+		return nil, &MachineNotFoundException{message: fmt.Sprintf("Автомат состояний с id = %d не найден", id)}
+	}
+	return machine, nil
+}
+
+// GetMachineByName возвращает автомат по имени, на которое ссылаются триггеры и шаги set_state
+func (service *StateService) GetMachineByName(name string) (*Machine, error) {
+	service.mut.RLock()
+	defer service.mut.RUnlock()
+
+	return service.findMachine(name)
+}
+
+func (service *StateService) findMachine(name string) (*Machine, error) {
+	for _, machine := range service.machines {
+		if machine.Name == name {
+			return machine, nil
+		}
+	}
+	return nil, &MachineNotFoundException{message: fmt.Sprintf("Автомат состояний %s не найден", name)}
+}
+
+func (service *StateService) AddMachine(machine *Machine) error {
+	if err := machine.validate(); err != nil {
+		return err
+	}
+	if err := machine.prepare(); err != nil {
+		return err
+	}
+
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	res, err := service.db.Exec(InsertQuery, machine.Name, machine.KeyType, machine.KeyExpression, machine.InitialState)
+	if err != nil {
+		return err
+	}
+	machine.Id, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	service.machines[machine.Id] = machine
+	return nil
+}
+
+// UpdateMachine сохраняет автомат и сбрасывает состояния его ключей, так как способ извлечения ключа мог измениться
+func (service *StateService) UpdateMachine(machine *Machine) error {
+	if err := machine.validate(); err != nil {
+		return err
+	}
+	if err := machine.prepare(); err != nil {
+		return err
+	}
+
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	previous, ok := service.machines[machine.Id]
+	if !ok {
+		return &MachineNotFoundException{message: fmt.Sprintf("Автомат состояний с id = %d не найден", machine.Id)}
+	}
+	if previous.Name != machine.Name {
+		if err := service.checkNotUsed(previous.Name); err != nil {
+			return err
+		}
+	}
+	_, err := service.db.Exec(UpdateQuery, machine.Name, machine.KeyType, machine.KeyExpression, machine.InitialState,
+		machine.Id)
+	if err != nil {
+		return err
+	}
+
+	service.removeStates(previous.Name)
+	service.machines[machine.Id] = machine
+	return nil
+}
+
+func (service *StateService) DeleteMachine(id int64) error {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	if machine, ok := service.machines[id]; ok {
+		if err := service.checkNotUsed(machine.Name); err != nil {
+			return err
+		}
+	}
+	if _, err := service.db.Exec(DeleteQuery, id); err != nil {
+		return err
+	}
+	if machine, ok := service.machines[id]; ok {
+		service.removeStates(machine.Name)
+		delete(service.machines, id)
+	}
+	return nil
+}
+
+func (service *StateService) UpdateFromDb() error {
+	rows, err := service.db.Query(SelectAllQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	machines := make(map[int64]*Machine)
+	for rows.Next() {
+		var machine Machine
+		err = rows.Scan(&machine.Id, &machine.Name, &machine.KeyType, &machine.KeyExpression, &machine.InitialState)
+		if err != nil {
+			return err
+		}
+		if err = machine.prepare(); err != nil {
+			return err
+		}
+		machines[machine.Id] = &machine
+	}
+
+	service.mut.Lock()
+	service.machines = machines
+	service.mut.Unlock()
+	return nil
+}
+
+// GetState возвращает ключ сообщения и его текущее состояние в автомате machineName
+func (service *StateService) GetState(machineName string, message *util.Message) (string, string, error) {
+	// Чтение отмечает ключ как использованный, поэтому выполняется под блокировкой на запись
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	machine, err := service.findMachine(machineName)
+	if err != nil {
+		return "", "", err
+	}
+	key, err := machine.Key(message)
+	if err != nil {
+		return "", "", err
+	}
+	if state, ok := service.states.Get(stateKey{machine: machineName, key: key}); ok {
+		return key, state, nil
+	}
+	return key, machine.InitialState, nil
+}
+
+// SetState переводит ключ сообщения в автомате machineName в состояние state
+func (service *StateService) SetState(machineName string, message *util.Message, state string) error {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	machine, err := service.findMachine(machineName)
+	if err != nil {
+		return err
+	}
+	key, err := machine.Key(message)
+	if err != nil {
+		return err
+	}
+	service.putState(machineName, key, state)
+	return nil
+}
+
+// PutState устанавливает состояние ключа напрямую, без извлечения из сообщения
+func (service *StateService) PutState(machineName string, key string, state string) error {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	if _, err := service.findMachine(machineName); err != nil {
+		return err
+	}
+	service.putState(machineName, key, state)
+	return nil
+}
+
+func (service *StateService) putState(machineName string, key string, state string) {
+	service.states.Put(stateKey{machine: machineName, key: key}, state)
+}
+
+// removeStates возвращает в начальное состояние все ключи автомата machineName
+func (service *StateService) removeStates(machineName string) {
+	service.states.RemoveIf(func(key stateKey) bool { return key.machine == machineName })
+}
+
+// GetStates возвращает копию состояний ключей автомата, ключи в начальном состоянии не включаются
+func (service *StateService) GetStates(machineName string) (map[string]string, error) {
+	service.mut.RLock()
+	defer service.mut.RUnlock()
+
+	if _, err := service.findMachine(machineName); err != nil {
+		return nil, err
+	}
+	states := make(map[string]string)
+	service.states.Range(func(key stateKey, state string) {
+		if key.machine == machineName {
+			states[key.key] = state
+		}
+	})
+	return states, nil
+}
+
+// ResetStates возвращает ключи автомата в начальное состояние. Пустой key сбрасывает все ключи
+func (service *StateService) ResetStates(machineName string, key string) error {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	if _, err := service.findMachine(machineName); err != nil {
+		return err
+	}
+	service.states.RemoveIf(func(state stateKey) bool {
+		return state.machine == machineName && (key == "" || state.key == key)
+	})
+	return nil
+}
+
+// ResetAll возвращает в начальное состояние ключи всех автоматов
+func (service *StateService) ResetAll() {
+	service.mut.Lock()
+	service.states = util.NewLRU[stateKey, string](maxStates)
+	service.mut.Unlock()
+}
@@ -0,0 +1,91 @@
+package states
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"unimock/database"
+	"unimock/templates"
+	"unimock/util"
+)
+
+func newTestService(t *testing.T) *StateService {
+	dbFile := filepath.Join(t.TempDir(), "unimock.db")
+	require.NoError(t, os.WriteFile(dbFile, nil, 0o644))
+
+	db, err := database.InitDatabaseConnection(dbFile, "../sql")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	service := NewService(db)
+	require.NoError(t, service.UpdateFromDb())
+	return service
+}
+
+func TestMachineStates(t *testing.T) {
+	service := newTestService(t)
+	machine := &Machine{Name: "orders", KeyType: templates.JsonPathExtractorType, KeyExpression: "$.id", InitialState: "NEW"}
+	require.NoError(t, service.AddMachine(machine))
+
+	message := &util.Message{Body: `{"id": "7"}`}
+	key, state, err := service.GetState("orders", message)
+	require.NoError(t, err)
+	require.Equal(t, "7", key)
+	require.Equal(t, "NEW", state)
+
+	require.NoError(t, service.SetState("orders", message, "PAID"))
+	_, state, err = service.GetState("orders", message)
+	require.NoError(t, err)
+	require.Equal(t, "PAID", state)
+
+	_, _, err = service.GetState("orders", &util.Message{Body: `{}`})
+	require.IsType(t, &StateKeyNotFoundException{}, err)
+	_, _, err = service.GetState("payments", message)
+	require.IsType(t, &MachineNotFoundException{}, err)
+
+	reloaded := NewService(service.db)
+	require.NoError(t, reloaded.UpdateFromDb())
+	loaded, err := reloaded.GetMachineByName("orders")
+	require.NoError(t, err)
+	require.Equal(t, machine.Id, loaded.Id)
+	require.Equal(t, "NEW", loaded.InitialState)
+
+	updated := &Machine{Id: machine.Id, Name: "orders", KeyType: templates.HeaderExtractorType, KeyExpression: "X-Order"}
+	require.NoError(t, service.UpdateMachine(updated))
+	states, err := service.GetStates("orders")
+	require.NoError(t, err)
+	require.Empty(t, states, "Изменение автомата сбрасывает состояния")
+	require.Equal(t, DefaultInitialState, updated.InitialState)
+
+	require.Error(t, service.AddMachine(&Machine{Name: "bad name", KeyType: templates.HeaderExtractorType}))
+	require.Error(t, service.AddMachine(&Machine{Name: "bad-regex", KeyType: templates.RegexExtractorType, KeyExpression: "("}))
+
+	require.NoError(t, service.DeleteMachine(machine.Id))
+	_, err = service.GetStates("orders")
+	require.IsType(t, &MachineNotFoundException{}, err)
+}
+
+func TestStatesEviction(t *testing.T) {
+	service := newTestService(t)
+	service.states = util.NewLRU[stateKey, string](2)
+	machine := &Machine{Name: "orders", KeyType: templates.JsonPathExtractorType, KeyExpression: "$.id", InitialState: "NEW"}
+	require.NoError(t, service.AddMachine(machine))
+
+	for _, key := range []string{"1", "2", "3"} {
+		require.NoError(t, service.PutState("orders", key, "PAID"))
+	}
+	states, err := service.GetStates("orders")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"2": "PAID", "3": "PAID"}, states)
+	_, state, err := service.GetState("orders", &util.Message{Body: `{"id": "1"}`})
+	require.NoError(t, err)
+	require.Equal(t, "NEW", state, "Вытесненный ключ возвращается в начальное состояние")
+
+	_, _, err = service.GetState("orders", &util.Message{Body: `{"id": "2"}`})
+	require.NoError(t, err)
+	require.NoError(t, service.PutState("orders", "4", "PAID"))
+	states, err = service.GetStates("orders")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"2": "PAID", "4": "PAID"}, states, "Прочитанный ключ не вытесняется")
+}
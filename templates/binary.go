@@ -0,0 +1,191 @@
+package templates
+
+import (
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unimock/util"
+)
+
+const contentTypeHeader = "Content-Type"
+
+// isBinary - тело шаблона двоичное или хранится в файле, плейсхолдеры в нём не подставляются
+func (template *Template) isBinary() bool {
+	return len(template.BinaryBody) > 0 || template.File != ""
+}
+
+func (template *Template) validateBinary() error {
+	if !template.isBinary() {
+		return nil
+	}
+	if template.Body != "" {
+		return &TemplateValidationException{message: "Двоичный шаблон не может содержать текстовое тело"}
+	}
+	if template.File == "" {
+		return nil
+	}
+	cleaned := filepath.Clean(template.File)
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return &TemplateValidationException{
+			message: fmt.Sprintf("Файл шаблона %s должен находиться в каталоге файлов шаблонов", template.File),
+		}
+	}
+	template.File = cleaned
+	return nil
+}
+
+// stagedFile - загруженное тело файлового шаблона, записанное во временный файл рядом с файлом шаблона.
+// Файл шаблона заменяется последним действием транзакции, прежний файл сохраняется до фиксации изменения в БД
+type stagedFile struct {
+	tempPath   string
+	path       string
+	backupPath string
+	applied    bool
+}
+
+// apply переносит временный файл на место файла шаблона, прежний файл остаётся в резервной копии
+func (file *stagedFile) apply() error {
+	if file == nil {
+		return nil
+	}
+	if _, err := os.Stat(file.path); err == nil {
+		backupPath := strings.TrimSuffix(file.tempPath, ".tmp") + ".bak"
+		// Жёсткая ссылка сохраняет прежний файл, не убирая его с места до замены
+		if err = os.Link(file.path, backupPath); err != nil {
+			if err = os.Rename(file.path, backupPath); err != nil {
+				return err
+			}
+		}
+		file.backupPath = backupPath
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(file.tempPath, file.path); err != nil {
+		return err
+	}
+	file.tempPath = ""
+	file.applied = true
+	return nil
+}
+
+// release удаляет резервную копию прежнего файла после фиксации изменения в БД
+func (file *stagedFile) release() {
+	if file == nil {
+		return
+	}
+	if file.backupPath != "" {
+		_ = os.Remove(file.backupPath)
+		file.backupPath = ""
+	}
+	file.applied = false
+}
+
+// discard удаляет временный файл и возвращает прежний файл шаблона, если изменение не было сохранено
+func (file *stagedFile) discard() {
+	if file == nil {
+		return
+	}
+	if file.tempPath != "" {
+		_ = os.Remove(file.tempPath)
+	}
+	if file.backupPath != "" {
+		if err := os.Rename(file.backupPath, file.path); err != nil {
+			log.Error().Err(err).Str("file", file.path).Msg("Ошибка восстановления файла шаблона")
+		}
+	} else if file.applied {
+		_ = os.Remove(file.path)
+	}
+}
+
+// removeFile удаляет файл удалённого шаблона
+func (template *Template) removeFile() {
+	if template.filePath == "" {
+		return
+	}
+	if err := os.Remove(template.filePath); err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Str("file", template.filePath).Msg("Ошибка удаления файла шаблона")
+	}
+}
+
+// storeFile проверяет файл шаблона, если он указан, и записывает загруженное тело во временный файл.
+// Без загруженного тела файл уже должен существовать в каталоге файлов шаблонов
+func (service *TemplateService) storeFile(template *Template) (*stagedFile, error) {
+	if template.File == "" {
+		return nil, nil
+	}
+	if service.filesDirectory == "" {
+		return nil, &TemplateValidationException{message: "Каталог файлов шаблонов не настроен"}
+	}
+	for _, other := range service.registry.Load().templates {
+		if other.Id != template.Id && other.File == template.File {
+			return nil, &TemplateValidationException{
+				message: fmt.Sprintf("Файл %s уже используется шаблоном %s", template.File, other.Name),
+			}
+		}
+	}
+	template.filePath = filepath.Join(service.filesDirectory, template.File)
+
+	if len(template.BinaryBody) == 0 {
+		if _, err := os.Stat(template.filePath); err != nil {
+			return nil, &TemplateValidationException{
+				message: fmt.Sprintf("Файл шаблона %s недоступен: %v", template.File, err),
+			}
+		}
+		return nil, nil
+	}
+	directory := filepath.Dir(template.filePath)
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, err
+	}
+	temp, err := os.CreateTemp(directory, "."+filepath.Base(template.filePath)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	staged := &stagedFile{tempPath: temp.Name(), path: template.filePath}
+	_, err = temp.Write(template.BinaryBody)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(staged.tempPath, 0o644)
+	}
+	if err != nil {
+		staged.discard()
+		return nil, err
+	}
+	// Тело файлового шаблона не хранится ни в БД, ни в памяти
+	template.BinaryBody = nil
+	return staged, nil
+}
+
+// processBinary возвращает тело шаблона без изменений, подставляются только заголовки
+func (template *Template) processBinary(message *util.Message, generator *generator) (*util.Message, error) {
+	unresolved := make([]string, 0)
+
+	result := &util.Message{
+		Body:       string(template.BinaryBody),
+		BodyFile:   template.filePath,
+		Headers:    make(map[string]string, len(template.Headers)+1),
+		StatusCode: template.StatusCode,
+		Variables:  message.Variables,
+	}
+	template.setContentType(result)
+	for name, value := range template.Headers {
+		HeaderUpdater{headerName: name}.Update(result, template.substitute(value, message, generator, &unresolved))
+	}
+	generator.setSeedHeader(result)
+
+	if err := template.checkUnresolved(unresolved); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// setContentType задаёт Content-Type ответа, заголовки шаблона могут его переопределить
+func (template *Template) setContentType(message *util.Message) {
+	if template.ContentType != "" {
+		message.Headers[contentTypeHeader] = template.ContentType
+	}
+}
@@ -0,0 +1,113 @@
+package templates
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"unimock/util"
+)
+
+func TestBinaryTemplates(t *testing.T) {
+	service := newTestService(t)
+	service.SetFilesDirectory(t.TempDir())
+	message := &util.Message{Body: `{"id": 1}`}
+
+	pdf := []byte("%PDF-1.4\x00\xff${id}")
+	blob := &Template{Name: "statement", BinaryBody: pdf, ContentType: "application/pdf",
+		Headers: map[string]string{"Content-Disposition": "attachment; filename=${name:-statement}.pdf"}}
+	require.NoError(t, service.AddTemplate(blob, ""))
+	result, err := service.ProcessMessage(blob.Id, message)
+	require.NoError(t, err)
+	require.Equal(t, string(pdf), result.Body, "Плейсхолдеры в двоичном теле не подставляются")
+	require.Equal(t, "application/pdf", result.Headers["Content-Type"])
+	require.Equal(t, "attachment; filename=statement.pdf", result.Headers["Content-Disposition"])
+
+	archive := &Template{Name: "archive", File: "downloads/../archive.zip", BinaryBody: []byte("PK\x03\x04")}
+	require.NoError(t, service.AddTemplate(archive, ""))
+	require.Equal(t, "archive.zip", archive.File)
+	require.Nil(t, archive.BinaryBody, "Тело файлового шаблона не хранится в памяти")
+	content, err := os.ReadFile(filepath.Join(service.filesDirectory, "archive.zip"))
+	require.NoError(t, err)
+	require.Equal(t, "PK\x03\x04", string(content))
+
+	require.NoError(t, service.UpdateFromDb())
+	result, err = service.ProcessMessage(archive.Id, message)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(service.filesDirectory, "archive.zip"), result.BodyFile)
+	require.Empty(t, result.Body)
+	result, err = service.ProcessMessage(blob.Id, message)
+	require.NoError(t, err)
+	require.Equal(t, string(pdf), result.Body)
+
+	for _, template := range []*Template{
+		{Name: "outside", File: "../secret"},
+		{Name: "missing", File: "missing.bin"},
+		{Name: "mixed", File: "mixed.bin", Body: "text"},
+	} {
+		require.IsType(t, &TemplateValidationException{}, service.AddTemplate(template, ""), template.Name)
+	}
+}
+
+func TestFileTemplateUploads(t *testing.T) {
+	service := newTestService(t)
+	service.SetFilesDirectory(t.TempDir())
+	archive := &Template{Name: "archive", File: "archive.zip", BinaryBody: []byte("v1")}
+	require.NoError(t, service.AddTemplate(archive, ""))
+
+	duplicate := &Template{Name: "copy", File: "./archive.zip", BinaryBody: []byte("copy")}
+	require.IsType(t, &TemplateValidationException{}, service.AddTemplate(duplicate, ""))
+
+	require.IsType(t, &TemplateValidationException{}, service.RollbackTemplate(archive.Id, 1, ""),
+		"Содержимое файла в версиях не хранится")
+
+	updated := *archive
+	updated.BinaryBody = []byte("v2")
+	require.NoError(t, service.UpdateTemplate(&updated, ""))
+
+	report := &Template{Name: "report", File: "report.pdf", BinaryBody: []byte("%PDF")}
+	require.NoError(t, service.AddTemplate(report, ""))
+	require.NoError(t, service.DeleteTemplate(report.Id, ""))
+	require.NoFileExists(t, filepath.Join(service.filesDirectory, "report.pdf"), "Файл удалённого шаблона удалён")
+
+	_, err := service.db.Exec("DROP TABLE template_versions")
+	require.NoError(t, err)
+	changed := *archive
+	changed.BinaryBody = []byte("v3")
+	require.Error(t, service.UpdateTemplate(&changed, ""))
+
+	content, err := os.ReadFile(filepath.Join(service.filesDirectory, "archive.zip"))
+	require.NoError(t, err)
+	require.Equal(t, "v2", string(content), "Файл не заменяется, если изменение не сохранено в БД")
+	entries, err := os.ReadDir(service.filesDirectory)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "Временные файлы и резервные копии удалены")
+}
+
+func TestStagedFileRestore(t *testing.T) {
+	service := newTestService(t)
+	service.SetFilesDirectory(t.TempDir())
+	path := filepath.Join(service.filesDirectory, "archive.zip")
+	require.NoError(t, os.WriteFile(path, []byte("v1"), 0o644))
+
+	file, err := service.storeFile(&Template{File: "archive.zip", BinaryBody: []byte("v2")})
+	require.NoError(t, err)
+	require.NoError(t, file.apply())
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "v2", string(content), "Файл заменяется до фиксации транзакции")
+	file.discard()
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "v1", string(content), "Прежний файл восстанавливается, если фиксация не удалась")
+
+	created, err := service.storeFile(&Template{File: "new.bin", BinaryBody: []byte("new")})
+	require.NoError(t, err)
+	require.NoError(t, created.apply())
+	created.discard()
+	require.NoFileExists(t, filepath.Join(service.filesDirectory, "new.bin"))
+
+	entries, err := os.ReadDir(service.filesDirectory)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
@@ -50,7 +50,7 @@ func (handler *TemplateHandler) AddTemplate(context *fiber.Ctx) error {
 	if err := json.Unmarshal(context.Body(), template); err != nil {
 		return &TemplateValidationException{message: err.Error()}
 	}
-	if err := handler.templateService.AddTemplate(template); err != nil {
+	if err := handler.templateService.AddTemplate(template, util.GetAuthor(context)); err != nil {
 		return err
 	}
 	return context.JSON(template)
@@ -66,7 +66,7 @@ func (handler *TemplateHandler) UpdateTemplate(context *fiber.Ctx) error {
 		return util.CreateParamValidationException("id", err)
 	}
 	template.Id = id
-	if err := handler.templateService.UpdateTemplate(template); err != nil {
+	if err := handler.templateService.UpdateTemplate(template, util.GetAuthor(context)); err != nil {
 		return err
 	}
 	return nil
@@ -78,7 +78,7 @@ func (handler *TemplateHandler) DeleteTemplate(context *fiber.Ctx) error {
 		return util.CreateParamValidationException("id", err)
 	}
 
-	if err := handler.templateService.DeleteTemplate(id); err != nil {
+	if err := handler.templateService.DeleteTemplate(id, util.GetAuthor(context)); err != nil {
 		return err
 	}
 	return nil
@@ -90,21 +90,86 @@ func (handler *TemplateHandler) ProcessSpecificTemplate(context *fiber.Ctx) erro
 		return util.CreateParamValidationException("id", err)
 	}
 
-	inputMessage := util.Message{
-		Body:    string(context.Body()),
-		Headers: context.GetReqHeaders(),
-	}
+	inputMessage := util.CreateMessageFromRequest(context, "*")
 
 	log.Debug().Any("headers", inputMessage.Headers).Str("body", inputMessage.Body).Msg("Получено сообщение")
 
-	outputMessage, err := handler.templateService.ProcessMessage(templateId, &inputMessage)
+	outputMessage, err := handler.templateService.ProcessMessage(templateId, inputMessage)
 	if err != nil {
 		return err
 	}
 
+	if outputMessage.StatusCode != 0 {
+		context.Status(outputMessage.StatusCode)
+	}
+
 	for key, value := range outputMessage.Headers {
-		context.Append(key, value)
+		context.Set(key, value)
+	}
+
+	return util.SendMessageBody(context, outputMessage)
+}
+
+func (handler *TemplateHandler) GetExtractors(context *fiber.Ctx) error {
+	templateId, err := strconv.ParseInt(context.Params("id"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("id", err)
+	}
+
+	extractors, err := handler.templateService.GetExtractors(templateId)
+	if err != nil {
+		return err
+	}
+
+	return context.JSON(extractors)
+}
+
+func (handler *TemplateHandler) AddExtractor(context *fiber.Ctx) error {
+	extractor := new(Extractor)
+	if err := json.Unmarshal(context.Body(), extractor); err != nil {
+		return &TemplateValidationException{message: err.Error()}
+	}
+	templateId, err := strconv.ParseInt(context.Params("id"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("id", err)
+	}
+	extractor.TemplateId = templateId
+	if err := handler.templateService.AddExtractor(extractor); err != nil {
+		return err
+	}
+	return context.JSON(extractor)
+}
+
+func (handler *TemplateHandler) UpdateExtractor(context *fiber.Ctx) error {
+	extractor := new(Extractor)
+	if err := json.Unmarshal(context.Body(), extractor); err != nil {
+		return &TemplateValidationException{message: err.Error()}
+	}
+	templateId, err := strconv.ParseInt(context.Params("id"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("id", err)
+	}
+	id, err := strconv.ParseInt(context.Params("extractorId"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("extractorId", err)
+	}
+	extractor.TemplateId = templateId
+	extractor.Id = id
+	if err := handler.templateService.UpdateExtractor(extractor); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (handler *TemplateHandler) DeleteExtractor(context *fiber.Ctx) error {
+	templateId, err := strconv.ParseInt(context.Params("id"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("id", err)
+	}
+	id, err := strconv.ParseInt(context.Params("extractorId"), 10, 64)
+	if err != nil {
+		return util.CreateParamValidationException("extractorId", err)
 	}
 
-	return context.SendString(outputMessage.Body)
+	return handler.templateService.DeleteExtractor(templateId, id)
 }
@@ -0,0 +1,243 @@
+package templates
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/PaesslerAG/jsonpath"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"strings"
+	gotemplate "text/template"
+	"time"
+	"unimock/util"
+)
+
+type TemplateEngine string
+
+const (
+	// SimpleEngine подставляет в тело значения плейсхолдеров ${name}
+	SimpleEngine TemplateEngine = "simple"
+	// GoTemplateEngine выполняет тело и заголовки как text/template
+	GoTemplateEngine TemplateEngine = "gotemplate"
+)
+
+const bodyTemplateName = "body"
+const headerTemplatePrefix = "header:"
+const textTemplateName = "text"
+
+// templateData - данные, доступные в шаблоне gotemplate: {{ .Body.order.id }}, {{ index .Headers "X-Id" }} и т.д.
+type templateData struct {
+	// Body - тело, разобранное как JSON или XML (в представлении util.ParseXml), иначе исходная строка
+	Body    interface{}
+	Raw     string
+	Headers map[string]string
+	Query   map[string]string
+	Method  string
+	Path    string
+	State   map[string]string
+	Vars    map[string]string
+	// xmlBody - документ для функции xpath, разбирается при первом обращении
+	xmlBody   *xmlquery.Node
+	generator *generator
+}
+
+func newTemplateData(message *util.Message, generator *generator) *templateData {
+	return &templateData{
+		Body:      parseTemplateBody(message.Body),
+		Raw:       message.Body,
+		Headers:   message.Headers,
+		Query:     message.Query,
+		Method:    message.Method,
+		Path:      message.Path,
+		State:     message.States,
+		Vars:      message.Variables,
+		generator: generator,
+	}
+}
+
+func parseTemplateBody(body string) interface{} {
+	trimmed := strings.TrimSpace(body)
+	switch {
+	case strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "["):
+		decoder := json.NewDecoder(strings.NewReader(trimmed))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err == nil {
+			return value
+		}
+	case strings.HasPrefix(trimmed, "<"):
+		if value, err := util.ParseXml(trimmed); err == nil {
+			return value
+		}
+	}
+	return body
+}
+
+// funcs возвращает функции шаблонов. jsonPath, xpath и генераторы связаны с сообщением, поэтому при выполнении
+// шаблон клонируется и функции переопределяются для конкретного сообщения
+func (data *templateData) funcs() gotemplate.FuncMap {
+	return gotemplate.FuncMap{
+		"uuid":      data.generator.uuid,
+		"fake":      data.generator.generate,
+		"now":       now,
+		"randomInt": data.generator.randomInt,
+		"base64":    encodeBase64,
+		"sha256":    hashSha256,
+		"dateAdd":   dateAdd,
+		"jsonPath":  data.jsonPath,
+		"xpath":     data.xpath,
+	}
+}
+
+// now возвращает текущее время в формате Go, по умолчанию RFC3339
+func now(format ...string) string {
+	return time.Now().Format(timeFormat(format))
+}
+
+func encodeBase64(value interface{}) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(value)))
+}
+
+func hashSha256(value interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(value)))
+	return hex.EncodeToString(sum[:])
+}
+
+// dateAdd прибавляет к дате в формате RFC3339 или 2006-01-02 длительность Go (например 48h или -30m)
+// и возвращает результат в формате format, по умолчанию RFC3339
+func dateAdd(date interface{}, duration string, format ...string) (string, error) {
+	var value time.Time
+	switch v := date.(type) {
+	case time.Time:
+		value = v
+	default:
+		var err error
+		text := fmt.Sprint(v)
+		if value, err = time.Parse(time.RFC3339, text); err != nil {
+			if value, err = time.Parse("2006-01-02", text); err != nil {
+				return "", fmt.Errorf("dateAdd: некорректная дата '%s'", text)
+			}
+		}
+	}
+	delta, err := time.ParseDuration(duration)
+	if err != nil {
+		return "", fmt.Errorf("dateAdd: %v", err)
+	}
+	return value.Add(delta).Format(timeFormat(format)), nil
+}
+
+func timeFormat(format []string) string {
+	if len(format) > 0 && format[0] != "" {
+		return format[0]
+	}
+	return time.RFC3339
+}
+
+// jsonPath возвращает значение выражения JSONPath по телу сообщения, например {{ range jsonPath "$.items[*]" }}
+func (data *templateData) jsonPath(expression string) (interface{}, error) {
+	return jsonpath.Get(expression, data.Body)
+}
+
+// xpath возвращает текст первого узла, найденного выражением XPath, или пустую строку
+func (data *templateData) xpath(expression string) (string, error) {
+	compiled, err := xpath.Compile(expression)
+	if err != nil {
+		return "", err
+	}
+	if data.xmlBody == nil {
+		if data.xmlBody, err = xmlquery.Parse(strings.NewReader(data.Raw)); err != nil {
+			return "", err
+		}
+	}
+	node := xmlquery.QuerySelector(data.xmlBody, compiled)
+	if node == nil {
+		return "", nil
+	}
+	return node.InnerText(), nil
+}
+
+// compile разбирает тело и заголовки шаблона gotemplate, результат используется при каждом выполнении
+func (template *Template) compile() error {
+	template.compiled = nil
+	if template.Engine != GoTemplateEngine || template.isBinary() {
+		return nil
+	}
+
+	root := gotemplate.New(template.Name).Funcs((&templateData{generator: &generator{}}).funcs())
+	if template.Strict {
+		root.Option("missingkey=error")
+	}
+	if _, err := root.New(bodyTemplateName).Parse(template.body()); err != nil {
+		return &TemplateValidationException{message: fmt.Sprintf("Ошибка в теле шаблона %s: %v", template.Name, err)}
+	}
+	for name, value := range template.Headers {
+		if _, err := root.New(headerTemplatePrefix + name).Parse(value); err != nil {
+			return &TemplateValidationException{
+				message: fmt.Sprintf("Ошибка в заголовке %s шаблона %s: %v", name, template.Name, err),
+			}
+		}
+	}
+	template.compiled = root
+	return nil
+}
+
+// render выполняет скомпилированный шаблон gotemplate
+func (template *Template) render(message *util.Message, generator *generator) (*util.Message, error) {
+	compiled, data, err := template.bind(message, generator)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &util.Message{
+		Headers:    make(map[string]string, len(template.Headers)),
+		StatusCode: template.StatusCode,
+		Variables:  message.Variables,
+	}
+	template.setContentType(result)
+	if result.Body, err = template.execute(compiled, bodyTemplateName, data); err != nil {
+		return nil, err
+	}
+	for name := range template.Headers {
+		value, err := template.execute(compiled, headerTemplatePrefix+name, data)
+		if err != nil {
+			return nil, err
+		}
+		HeaderUpdater{headerName: name}.Update(result, value)
+	}
+	generator.setSeedHeader(result)
+	return result, nil
+}
+
+// renderText выполняет произвольный текст с функциями и данными шаблона gotemplate
+func (template *Template) renderText(text string, message *util.Message, generator *generator) (string, error) {
+	compiled, data, err := template.bind(message, generator)
+	if err != nil {
+		return "", err
+	}
+	if _, err = compiled.New(textTemplateName).Parse(text); err != nil {
+		return "", &TemplateValidationException{message: fmt.Sprintf("Ошибка в тексте для шаблона %s: %v", template.Name, err)}
+	}
+	return template.execute(compiled, textTemplateName, data)
+}
+
+// bind клонирует скомпилированный шаблон и связывает его функции с сообщением
+func (template *Template) bind(message *util.Message, generator *generator) (*gotemplate.Template, *templateData, error) {
+	data := newTemplateData(message, generator)
+	compiled, err := template.compiled.Clone()
+	if err != nil {
+		return nil, nil, err
+	}
+	return compiled.Funcs(data.funcs()), data, nil
+}
+
+func (template *Template) execute(compiled *gotemplate.Template, name string, data *templateData) (string, error) {
+	var buffer bytes.Buffer
+	if err := compiled.ExecuteTemplate(&buffer, name, data); err != nil {
+		return "", &TemplateValidationException{message: fmt.Sprintf("Не удалось выполнить шаблон %s: %v", template.Name, err)}
+	}
+	return buffer.String(), nil
+}
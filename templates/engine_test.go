@@ -0,0 +1,67 @@
+package templates
+
+import (
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+	"unimock/util"
+)
+
+func TestGoTemplateEngine(t *testing.T) {
+	service := newTestService(t)
+	template := &Template{
+		Name:   "order",
+		Engine: GoTemplateEngine,
+		Body: `{"id": "{{ .Body.id }}", "items": [{{ range $i, $item := .Body.items }}{{ if $i }},{{ end }}"{{ $item.sku }}"{{ end }}],` +
+			` "first": "{{ jsonPath "$.items[0].sku" }}", "page": "{{ .Query.page }}", "state": "{{ .State.orders }}",` +
+			` "due": "{{ dateAdd .Body.created "48h" "2006-01-02" }}", "hash": "{{ sha256 "a" }}", "b64": "{{ base64 .Method }}",` +
+			` "uuid": "{{ uuid }}", "random": {{ randomInt 5 5 }}, "year": "{{ now "2006" | len }}"}`,
+		Headers: map[string]string{"X-Order": "{{ .Body.id }}"},
+	}
+	require.NoError(t, service.AddTemplate(template, ""))
+	require.NoError(t, service.UpdateFromDb())
+
+	result, err := service.ProcessMessage(template.Id, &util.Message{
+		Body:   `{"id": 42, "created": "2024-02-28T10:00:00Z", "items": [{"sku": "A"}, {"sku": "B"}]}`,
+		Method: "GET",
+		Query:  map[string]string{"page": "3"},
+		States: map[string]string{"orders": "PAID"},
+	})
+	require.NoError(t, err)
+	require.Regexp(t, regexp.MustCompile(`^{"id": "42", "items": \["A","B"\], "first": "A", "page": "3", "state": "PAID", `+
+		`"due": "2024-03-01", "hash": "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb", "b64": "R0VU", `+
+		`"uuid": "[0-9a-f-]{36}", "random": 5, "year": "4"}$`), result.Body)
+	require.Equal(t, "42", result.Headers["X-Order"])
+
+	xmlTemplate := &Template{Name: "xml", Engine: GoTemplateEngine,
+		Body: `{{ xpath "//order/id" }}:{{ .Body.order.item }}`}
+	require.NoError(t, xmlTemplate.prepare())
+	result, err = xmlTemplate.ProcessMessage(&util.Message{Body: `<order><id>7</id><item>x</item></order>`})
+	require.NoError(t, err)
+	require.Equal(t, "7:x", result.Body)
+
+	text, err := xmlTemplate.ProcessText("http://host/{{ xpath `//id` }}", &util.Message{Body: `<id>9</id>`})
+	require.NoError(t, err)
+	require.Equal(t, "http://host/9", text)
+}
+
+func TestGoTemplateValidation(t *testing.T) {
+	service := newTestService(t)
+
+	err := service.AddTemplate(&Template{Name: "broken", Engine: GoTemplateEngine, Body: "{{ .Body.id "}, "")
+	require.IsType(t, &TemplateValidationException{}, err)
+	err = service.AddTemplate(&Template{Name: "unknown", Engine: "velocity", Body: ""}, "")
+	require.IsType(t, &TemplateValidationException{}, err)
+
+	simple := &Template{Name: "simple", Body: "{{ .Body.id }}"}
+	require.NoError(t, service.AddTemplate(simple, ""))
+	require.Equal(t, SimpleEngine, simple.Engine)
+	result, err := simple.ProcessMessage(&util.Message{})
+	require.NoError(t, err)
+	require.Equal(t, "{{ .Body.id }}", result.Body, "Движок simple не выполняет конструкции text/template")
+
+	strict := &Template{Name: "strict", Engine: GoTemplateEngine, Strict: true, Body: "{{ .Body.missing }}"}
+	require.NoError(t, strict.prepare())
+	_, err = strict.ProcessMessage(&util.Message{Body: `{"id": 1}`})
+	require.IsType(t, &TemplateValidationException{}, err)
+}
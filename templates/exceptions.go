@@ -15,3 +15,11 @@ type TemplateNotFoundException struct {
 func (e *TemplateNotFoundException) Error() string {
 	return e.message
 }
+
+type ExtractorNotFoundException struct {
+	message string
+}
+
+func (e *ExtractorNotFoundException) Error() string {
+	return e.message
+}
@@ -1,11 +1,113 @@
 package templates
 
-import "unimock/util"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/PaesslerAG/gval"
+	"github.com/PaesslerAG/jsonpath"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/tidwall/gjson"
+	"regexp"
+	"strconv"
+	"strings"
+	"unimock/util"
+)
+
+type ExtractorType string
+
+const (
+	GsonExtractorType        ExtractorType = "gson"
+	JsonPathExtractorType    ExtractorType = "jsonpath"
+	XPathExtractorType       ExtractorType = "xpath"
+	RegexExtractorType       ExtractorType = "regex"
+	HeaderExtractorType      ExtractorType = "header"
+	QueryExtractorType       ExtractorType = "query"
+	PathSegmentExtractorType ExtractorType = "path"
+)
+
+// regexValueGroup - имя группы, значение которой извлекает RegexExtractor
+const regexValueGroup = "value"
+const dotAllRegexMod = "(?s)"
 
 type MessageExtractor interface {
 	Extract(message *util.Message) (string, bool)
 }
 
+var extractorNameRegexp = regexp.MustCompile(`^` + placeholderNamePattern + `$`)
+
+// Extractor - сохраняемое в БД описание извлечения значения для плейсхолдера ${Name} шаблона
+type Extractor struct {
+	Id            int64         `json:"id"`
+	TemplateId    int64         `json:"template_id"`
+	Name          string        `json:"name"`
+	ExtractorType ExtractorType `json:"type"`
+	Expression    string        `json:"expression"`
+	extractor     MessageExtractor
+}
+
+func (extractor *Extractor) validate() error {
+	if extractor.ExtractorType == "" {
+		return &TemplateValidationException{message: "Не указан тип извлекателя"}
+	}
+	if !extractorNameRegexp.MatchString(extractor.Name) {
+		return &TemplateValidationException{message: fmt.Sprintf("Некорректное имя плейсхолдера '%s'", extractor.Name)}
+	}
+	return nil
+}
+
+func (extractor *Extractor) prepare() error {
+	var err error
+	switch extractor.ExtractorType {
+	case GsonExtractorType:
+		extractor.extractor = GsonExtractor{path: extractor.Expression}
+	case JsonPathExtractorType:
+		var eval gval.Evaluable
+		eval, err = jsonpath.New(extractor.Expression)
+		extractor.extractor = JsonPathExtractor{eval: eval}
+	case XPathExtractorType:
+		var expression *xpath.Expr
+		expression, err = xpath.Compile(extractor.Expression)
+		extractor.extractor = XPathExtractor{expression: expression}
+	case RegexExtractorType:
+		var expressionRegexp *regexp.Regexp
+		expressionRegexp, err = regexp.Compile(dotAllRegexMod + extractor.Expression)
+		extractor.extractor = RegexExtractor{expressionRegexp: expressionRegexp}
+	case HeaderExtractorType:
+		extractor.extractor = HeaderExtractor{headerName: extractor.Expression}
+	case QueryExtractorType:
+		extractor.extractor = QueryExtractor{paramName: extractor.Expression}
+	case PathSegmentExtractorType:
+		var index int
+		index, err = strconv.Atoi(extractor.Expression)
+		extractor.extractor = PathSegmentExtractor{index: index}
+	default:
+		return &TemplateValidationException{message: fmt.Sprintf("Неизвестный тип извлекателя %s", extractor.ExtractorType)}
+	}
+
+	if err != nil {
+		return &TemplateValidationException{message: err.Error()}
+	}
+	return nil
+}
+
+// NewMessageExtractor создаёт извлекатель значения из сообщения без привязки к шаблону
+func NewMessageExtractor(extractorType ExtractorType, expression string) (MessageExtractor, error) {
+	extractor := &Extractor{ExtractorType: extractorType, Expression: expression}
+	if err := extractor.prepare(); err != nil {
+		return nil, err
+	}
+	return extractor, nil
+}
+
+func (extractor *Extractor) Extract(message *util.Message) (string, bool) {
+	if extractor.extractor == nil {
+		return "", false
+	}
+	return extractor.extractor.Extract(message)
+}
+
 type HeaderExtractor struct {
 	headerName string
 }
@@ -14,3 +116,102 @@ func (extractor HeaderExtractor) Extract(message *util.Message) (string, bool) {
 	value, ok := message.Headers[extractor.headerName]
 	return value, ok
 }
+
+type QueryExtractor struct {
+	paramName string
+}
+
+func (extractor QueryExtractor) Extract(message *util.Message) (string, bool) {
+	value, ok := message.Query[extractor.paramName]
+	return value, ok
+}
+
+// PathSegmentExtractor извлекает сегмент пути запроса по индексу, начиная с 0
+type PathSegmentExtractor struct {
+	index int
+}
+
+func (extractor PathSegmentExtractor) Extract(message *util.Message) (string, bool) {
+	segments := strings.Split(strings.Trim(message.Path, "/"), "/")
+	if extractor.index < 0 || extractor.index >= len(segments) || segments[extractor.index] == "" {
+		return "", false
+	}
+	return segments[extractor.index], true
+}
+
+// RegexExtractor возвращает группу с именем value, если она есть, иначе первую группу или всё совпадение
+type RegexExtractor struct {
+	expressionRegexp *regexp.Regexp
+}
+
+func (extractor RegexExtractor) Extract(message *util.Message) (string, bool) {
+	match := extractor.expressionRegexp.FindStringSubmatch(message.Body)
+	if match == nil {
+		return "", false
+	}
+
+	if index := extractor.expressionRegexp.SubexpIndex(regexValueGroup); index >= 0 {
+		return match[index], true
+	}
+	if len(match) > 1 {
+		return match[1], true
+	}
+	return match[0], true
+}
+
+type GsonExtractor struct {
+	path string
+}
+
+func (extractor GsonExtractor) Extract(message *util.Message) (string, bool) {
+	result := gjson.Get(message.Body, extractor.path)
+	return result.String(), result.Exists()
+}
+
+type JsonPathExtractor struct {
+	eval gval.Evaluable
+}
+
+func (extractor JsonPathExtractor) Extract(message *util.Message) (string, bool) {
+	var messageBody interface{}
+	if err := json.Unmarshal([]byte(message.Body), &messageBody); err != nil {
+		return "", false
+	}
+
+	result, err := extractor.eval(context.Background(), messageBody)
+	if err != nil || result == nil {
+		return "", false
+	}
+
+	return valueToString(result)
+}
+
+type XPathExtractor struct {
+	expression *xpath.Expr
+}
+
+func (extractor XPathExtractor) Extract(message *util.Message) (string, bool) {
+	messageBody, err := xmlquery.Parse(strings.NewReader(message.Body))
+	if err != nil {
+		return "", false
+	}
+
+	node := xmlquery.QuerySelector(messageBody, extractor.expression)
+	if node == nil {
+		return "", false
+	}
+	return node.InnerText(), true
+}
+
+func valueToString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	default:
+		result, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(result), true
+	}
+}
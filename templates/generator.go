@@ -0,0 +1,295 @@
+package templates
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"math/big"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unimock/util"
+)
+
+// SeedHeader - заголовок запроса с зерном генераторов. Ответ шаблона, в котором использовались генераторы,
+// возвращает зерно в этом же заголовке, чтобы ответ можно было воспроизвести
+const SeedHeader = "X-Unimock-Seed"
+
+// generatorRegexp - генератор ${@name} или ${@name(arg1,arg2)}, $${@name} выводится без подстановки
+var generatorRegexp = regexp.MustCompile(`\$?\$\{@(\w+)(?:\(([^}]*?)\))?}`)
+
+const defaultSequence = "default"
+const defaultDateFormat = "2006-01-02"
+
+// Интервал дат по умолчанию фиксирован, чтобы ответ с тем же зерном воспроизводился и позже
+const defaultDateFrom = "2000-01-01"
+const defaultDateTo = "2029-12-31"
+const defaultLoremWords = 10
+
+var firstNames = []string{"Александр", "Алексей", "Анна", "Дмитрий", "Екатерина", "Елена", "Иван", "Ирина",
+	"Максим", "Мария", "Михаил", "Наталья", "Ольга", "Павел", "Сергей", "Татьяна"}
+var lastNames = []string{"Иванов", "Смирнов", "Кузнецов", "Попов", "Васильев", "Петров", "Соколов", "Михайлов",
+	"Новиков", "Фёдоров", "Морозов", "Волков", "Алексеев", "Лебедев", "Семёнов", "Егоров"}
+var loremWords = strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor " +
+	"incididunt ut labore et dolore magna aliqua enim ad minim veniam quis nostrud exercitation ullamco laboris " +
+	"nisi aliquip ex ea commodo consequat")
+
+// ibanLengths - длина цифрового BBAN для поддерживаемых стран
+var ibanLengths = map[string]int{"DE": 18, "AT": 16, "BE": 12, "CH": 17, "DK": 14, "ES": 20, "FI": 14,
+	"NO": 11, "PL": 24, "SE": 20}
+
+const defaultIbanCountry = "DE"
+const defaultCardPrefix = "4"
+const defaultPhoneFormat = "+7 9## ###-##-##"
+
+// generator вычисляет генераторы одного сообщения. Все случайные значения берутся из одного источника,
+// поэтому одинаковое зерно даёт одинаковый ответ, кроме значений seq
+type generator struct {
+	template *Template
+	// seedHeader - значение заголовка SeedHeader, разбирается при первом использовании генератора
+	seedHeader *string
+	seed       int64
+	seedErr    error
+	random     *rand.Rand
+}
+
+// newGenerator запоминает заголовок SeedHeader сообщения. Зерно разбирается только при использовании генераторов,
+// поэтому некорректное зерно не мешает шаблонам без генераторов
+func newGenerator(template *Template, message *util.Message) *generator {
+	generator := &generator{template: template}
+	for key, value := range message.Headers {
+		if strings.EqualFold(key, SeedHeader) {
+			value := value
+			generator.seedHeader = &value
+		}
+	}
+	return generator
+}
+
+// used - в сообщении использовался хотя бы один генератор
+func (generator *generator) used() bool {
+	return generator.random != nil
+}
+
+// err возвращает ошибку разбора зерна, если генераторы использовались с некорректным зерном
+func (generator *generator) err() error {
+	return generator.seedErr
+}
+
+func (generator *generator) rand() *rand.Rand {
+	if generator.random == nil {
+		generator.seed = time.Now().UnixNano()
+		if generator.seedHeader != nil {
+			seed, err := strconv.ParseInt(*generator.seedHeader, 10, 64)
+			if err != nil {
+				generator.seedErr = &TemplateValidationException{
+					message: fmt.Sprintf("Некорректное зерно генераторов '%s'", *generator.seedHeader),
+				}
+			}
+			generator.seed = seed
+		}
+		generator.random = rand.New(rand.NewSource(generator.seed))
+	}
+	return generator.random
+}
+
+// setSeedHeader сообщает в ответе зерно, с которым были вычислены генераторы
+func (generator *generator) setSeedHeader(message *util.Message) {
+	if generator.used() {
+		message.Headers[SeedHeader] = strconv.FormatInt(generator.seed, 10)
+	}
+}
+
+// substitute заменяет генераторы в text, имена генераторов с ошибкой добавляются в unresolved
+func (generator *generator) substitute(text string, unresolved *[]string) string {
+	return generatorRegexp.ReplaceAllStringFunc(text, func(match string) string {
+		if strings.HasPrefix(match, escapedVariablePrefix) {
+			return match[1:]
+		}
+		groups := generatorRegexp.FindStringSubmatch(match)
+		args := make([]string, 0)
+		if groups[2] != "" {
+			for _, arg := range strings.Split(groups[2], ",") {
+				args = append(args, strings.TrimSpace(arg))
+			}
+		}
+		result, err := generator.generate(groups[1], args...)
+		if err != nil {
+			*unresolved = append(*unresolved, "@"+groups[1])
+			return match
+		}
+		return result
+	})
+}
+
+// generate вычисляет генератор name. Доступны uuid, seq([name]), firstName, lastName, name, iban([country]),
+// card([prefix]), phone([format]), date([from, to[, format]]), number(min, max) и lorem([words])
+func (generator *generator) generate(name string, args ...string) (string, error) {
+	switch name {
+	case "uuid":
+		return generator.uuid()
+	case "seq":
+		return generator.sequence(argOrDefault(args, 0, defaultSequence))
+	case "firstName":
+		return generator.pick(firstNames), nil
+	case "lastName":
+		return generator.pick(lastNames), nil
+	case "name":
+		return generator.pick(firstNames) + " " + generator.pick(lastNames), nil
+	case "iban":
+		return generator.iban(argOrDefault(args, 0, defaultIbanCountry))
+	case "card":
+		return generator.card(argOrDefault(args, 0, defaultCardPrefix))
+	case "phone":
+		return generator.digits(argOrDefault(args, 0, defaultPhoneFormat)), nil
+	case "date":
+		return generator.date(args)
+	case "number":
+		return generator.number(args)
+	case "lorem":
+		return generator.lorem(argOrDefault(args, 0, strconv.Itoa(defaultLoremWords)))
+	default:
+		return "", fmt.Errorf("неизвестный генератор %s", name)
+	}
+}
+
+func argOrDefault(args []string, index int, defaultValue string) string {
+	if index < len(args) && args[index] != "" {
+		return args[index]
+	}
+	return defaultValue
+}
+
+func (generator *generator) uuid() (string, error) {
+	value, err := uuid.NewRandomFromReader(generator.rand())
+	if err != nil {
+		return "", err
+	}
+	return value.String(), nil
+}
+
+func (generator *generator) sequence(name string) (string, error) {
+	if generator.template.sequences == nil {
+		return "", fmt.Errorf("счётчики шаблона %s недоступны", generator.template.Name)
+	}
+	value, err := generator.template.sequences.next(generator.template.Id, name)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(value, 10), nil
+}
+
+func (generator *generator) pick(values []string) string {
+	return values[generator.rand().Intn(len(values))]
+}
+
+// digits заменяет каждый символ # в format случайной цифрой
+func (generator *generator) digits(format string) string {
+	var result strings.Builder
+	for _, char := range format {
+		if char == '#' {
+			result.WriteByte(byte('0' + generator.rand().Intn(10)))
+		} else {
+			result.WriteRune(char)
+		}
+	}
+	return result.String()
+}
+
+// iban возвращает IBAN с цифровым BBAN и контрольными цифрами по ISO 13616 (mod 97)
+func (generator *generator) iban(country string) (string, error) {
+	country = strings.ToUpper(country)
+	length, ok := ibanLengths[country]
+	if !ok {
+		return "", fmt.Errorf("генерация IBAN для страны %s не поддерживается", country)
+	}
+	bban := generator.digits(strings.Repeat("#", length))
+
+	var numeric strings.Builder
+	numeric.WriteString(bban)
+	for _, char := range country {
+		numeric.WriteString(strconv.Itoa(int(char-'A') + 10))
+	}
+	numeric.WriteString("00")
+	value, _ := new(big.Int).SetString(numeric.String(), 10)
+	check := 98 - new(big.Int).Mod(value, big.NewInt(97)).Int64()
+	return fmt.Sprintf("%s%02d%s", country, check, bban), nil
+}
+
+// card возвращает 16-значный номер карты с префиксом prefix и контрольной цифрой по алгоритму Луна
+func (generator *generator) card(prefix string) (string, error) {
+	if len(prefix) > 15 || strings.Trim(prefix, "0123456789") != "" {
+		return "", fmt.Errorf("некорректный префикс номера карты %s", prefix)
+	}
+	number := prefix + generator.digits(strings.Repeat("#", 15-len(prefix)))
+
+	sum := 0
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i] - '0')
+		// Удваиваются цифры на нечётных позициях справа с учётом контрольной цифры, которая будет добавлена
+		if (len(number)-i)%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return number + strconv.Itoa((10-sum%10)%10), nil
+}
+
+// date возвращает дату из интервала [from, to] в формате format. По умолчанию интервал - с defaultDateFrom
+// по defaultDateTo
+func (generator *generator) date(args []string) (string, error) {
+	format := argOrDefault(args, 2, defaultDateFormat)
+	from, err := time.Parse(defaultDateFormat, argOrDefault(args, 0, defaultDateFrom))
+	if err != nil {
+		return "", err
+	}
+	to, err := time.Parse(defaultDateFormat, argOrDefault(args, 1, defaultDateTo))
+	if err != nil {
+		return "", err
+	}
+	if to.Before(from) {
+		return "", fmt.Errorf("конец интервала дат раньше начала")
+	}
+	seconds := to.Unix() - from.Unix()
+	return time.Unix(from.Unix()+generator.rand().Int63n(seconds+1), 0).UTC().Format(format), nil
+}
+
+func (generator *generator) number(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", fmt.Errorf("генератор number принимает min и max")
+	}
+	min, err := strconv.Atoi(args[0])
+	if err != nil {
+		return "", err
+	}
+	max, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", err
+	}
+	value, err := generator.randomInt(min, max)
+	return strconv.Itoa(value), err
+}
+
+// randomInt возвращает случайное число из интервала [min, max]
+func (generator *generator) randomInt(min, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("max %d меньше min %d", max, min)
+	}
+	return min + generator.rand().Intn(max-min+1), nil
+}
+
+func (generator *generator) lorem(words string) (string, error) {
+	count, err := strconv.Atoi(words)
+	if err != nil || count < 1 {
+		return "", fmt.Errorf("некорректное количество слов %s", words)
+	}
+	result := make([]string, count)
+	for i := range result {
+		result[i] = generator.pick(loremWords)
+	}
+	return strings.Join(result, " "), nil
+}
@@ -0,0 +1,136 @@
+package templates
+
+import (
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+	"unimock/util"
+)
+
+func TestGenerators(t *testing.T) {
+	template := createTemplate(t, `${@uuid}|${@name}|${@iban}|${@iban(PL)}|${@card(2200)}|${@phone}|${@phone(8 (###) ###)}|`+
+		`${@date(2024-01-01,2024-01-31)}|${@date(2024-01-01,2024-01-01,02.01.2006)}|${@number(5,5)}|${@lorem(3)}|$${@uuid}`)
+	seeded := &util.Message{Headers: map[string]string{"x-unimock-seed": "42"}}
+
+	result, err := template.ProcessMessage(seeded)
+	require.NoError(t, err)
+	require.Equal(t, "42", result.Headers[SeedHeader])
+	values := strings.Split(result.Body, "|")
+	require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, values[0])
+	require.Len(t, strings.Fields(values[1]), 2)
+	require.Regexp(t, `^DE\d{20}$`, values[2])
+	require.True(t, validIban(values[2]), values[2])
+	require.Regexp(t, `^PL\d{26}$`, values[3])
+	require.True(t, validIban(values[3]), values[3])
+	require.Regexp(t, `^2200\d{12}$`, values[4])
+	require.True(t, validLuhn(values[4]), values[4])
+	require.Regexp(t, `^\+7 9\d{2} \d{3}-\d{2}-\d{2}$`, values[5])
+	require.Regexp(t, `^8 \(\d{3}\) \d{3}$`, values[6])
+	require.Regexp(t, `^2024-01-([0-2]\d|3[01])$`, values[7])
+	require.Equal(t, "01.01.2024", values[8])
+	require.Equal(t, "5", values[9])
+	require.Len(t, strings.Fields(values[10]), 3)
+	require.Equal(t, "${@uuid}", values[11])
+
+	repeated, err := template.ProcessMessage(seeded)
+	require.NoError(t, err)
+	require.Equal(t, result.Body, repeated.Body, "Одинаковое зерно даёт одинаковый ответ")
+
+	dates, err := createTemplate(t, "${@date}|${@date(,2000-01-01)}").ProcessMessage(seeded)
+	require.NoError(t, err)
+	require.Regexp(t, `^20[0-2]\d-\d{2}-\d{2}\|2000-01-01$`, dates.Body, "Интервал дат по умолчанию не зависит от текущей даты")
+
+	random, err := template.ProcessMessage(&util.Message{})
+	require.NoError(t, err)
+	seed := random.Headers[SeedHeader]
+	require.NotEmpty(t, seed)
+	reproduced, err := template.ProcessMessage(&util.Message{Headers: map[string]string{SeedHeader: seed}})
+	require.NoError(t, err)
+	require.Equal(t, random.Body, reproduced.Body, "Ответ воспроизводится по зерну из заголовка ответа")
+
+	_, err = template.ProcessMessage(&util.Message{Headers: map[string]string{SeedHeader: "abc"}})
+	require.IsType(t, &TemplateValidationException{}, err)
+
+	strict := createTemplate(t, "${@unknown} ${@iban(XX)}")
+	strict.Strict = true
+	_, err = strict.ProcessMessage(&util.Message{})
+	require.EqualError(t, err, "Не удалось подставить значения плейсхолдеров @unknown, @iban в шаблон ")
+
+	plain, err := createTemplate(t, "${id:-1}").ProcessMessage(&util.Message{})
+	require.NoError(t, err)
+	require.NotContains(t, plain.Headers, SeedHeader, "Зерно возвращается только при использовании генераторов")
+
+	invalidSeed := &util.Message{Headers: map[string]string{SeedHeader: "abc"}}
+	plain, err = createTemplate(t, "${id:-1}").ProcessMessage(invalidSeed)
+	require.NoError(t, err, "Некорректное зерно не мешает шаблону без генераторов")
+	require.Equal(t, "1", plain.Body)
+	text, err := createTemplate(t, "").ProcessText("${id:-2}", invalidSeed)
+	require.NoError(t, err)
+	require.Equal(t, "2", text)
+	_, err = createTemplate(t, "").ProcessText("${@uuid}", invalidSeed)
+	require.IsType(t, &TemplateValidationException{}, err)
+}
+
+func TestSequenceGenerator(t *testing.T) {
+	service := newTestService(t)
+	template := &Template{Name: "seq", Body: "${@seq}-${@seq(orders)}", Engine: SimpleEngine}
+	require.NoError(t, service.AddTemplate(template, ""))
+	other := &Template{Name: "other", Engine: GoTemplateEngine, Body: `{{ fake "seq" }} {{ fake "iban" "AT" | len }}`}
+	require.NoError(t, service.AddTemplate(other, ""))
+
+	for i := 1; i <= 2; i++ {
+		result, err := service.ProcessMessage(template.Id, &util.Message{})
+		require.NoError(t, err)
+		require.Equal(t, strconv.Itoa(i)+"-"+strconv.Itoa(i), result.Body)
+	}
+	result, err := service.ProcessMessage(other.Id, &util.Message{})
+	require.NoError(t, err)
+	require.Equal(t, "1 20", result.Body, "Счётчики ведутся отдельно для каждого шаблона")
+
+	require.NoError(t, service.UpdateFromDb())
+	result, err = service.ProcessMessage(template.Id, &util.Message{})
+	require.NoError(t, err)
+	require.Equal(t, "3-3", result.Body, "Счётчики сохраняются в БД")
+
+	require.NoError(t, service.Shutdown())
+	restarted := NewService(service.db)
+	require.NoError(t, restarted.UpdateFromDb())
+	result, err = restarted.ProcessMessage(template.Id, &util.Message{})
+	require.NoError(t, err)
+	require.Equal(t, "4-4", result.Body, "Shutdown записывает счётчики, выданные после последней записи")
+
+	require.NoError(t, service.DeleteTemplate(template.Id, ""))
+	require.NoError(t, service.UpdateFromDb())
+	require.Empty(t, service.sequences.values[sequenceKey{templateId: template.Id, name: defaultSequence}])
+}
+
+func validIban(iban string) bool {
+	rearranged := iban[4:] + iban[:4]
+	var numeric strings.Builder
+	for _, char := range rearranged {
+		if char >= 'A' && char <= 'Z' {
+			numeric.WriteString(strconv.Itoa(int(char-'A') + 10))
+		} else {
+			numeric.WriteRune(char)
+		}
+	}
+	value, _ := new(big.Int).SetString(numeric.String(), 10)
+	return new(big.Int).Mod(value, big.NewInt(97)).Int64() == 1
+}
+
+func validLuhn(number string) bool {
+	sum := 0
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i] - '0')
+		if (len(number)-i)%2 == 0 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,148 @@
+package templates
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// includeRegexp - директива ${include:name}, вместо которой подставляется тело шаблона с именем name. Фрагмент
+// подставляется текстом, поэтому его плейсхолдеры разрешаются извлекателями включающего шаблона. Экранированная
+// директива $${include:name} не раскрывается и выводится как ${include:name}
+var includeRegexp = regexp.MustCompile(`\$?\$\{include:(` + placeholderNamePattern + `)}`)
+
+func parseIncludes(body string) []string {
+	matches := includeRegexp.FindAllStringSubmatch(body, -1)
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if !strings.HasPrefix(match[0], escapedVariablePrefix) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// unescapeIncludes заменяет экранированные директивы включения текстом директивы
+func unescapeIncludes(text string) string {
+	return includeRegexp.ReplaceAllStringFunc(text, func(match string) string {
+		if strings.HasPrefix(match, escapedVariablePrefix) {
+			return match[1:]
+		}
+		return match
+	})
+}
+
+func (registry *templateRegistry) templateByName(name string) (*Template, bool) {
+	for _, template := range registry.templates {
+		if template.Name == name {
+			return template, true
+		}
+	}
+	return nil, false
+}
+
+// resolveIncludes раскрывает включения шаблона по текущему снимку и компилирует результат. Шаблон ещё не должен
+// быть опубликован
+func (registry *templateRegistry) resolveIncludes(template *Template) error {
+	template.includes = parseIncludes(template.Body)
+	template.resolvedBody = ""
+	if len(template.includes) == 0 {
+		return template.compile()
+	}
+
+	body, err := registry.expand(template.Body, []string{template.Name})
+	if err != nil {
+		return err
+	}
+	template.resolvedBody = body
+	return template.compile()
+}
+
+// expand рекурсивно подставляет включения, stack - цепочка включающих шаблонов для обнаружения циклов
+func (registry *templateRegistry) expand(body string, stack []string) (string, error) {
+	var err error
+	result := includeRegexp.ReplaceAllStringFunc(body, func(match string) string {
+		if err != nil || strings.HasPrefix(match, escapedVariablePrefix) {
+			return match
+		}
+		name := includeRegexp.FindStringSubmatch(match)[1]
+		for _, including := range stack {
+			if including == name {
+				err = &TemplateValidationException{
+					message: fmt.Sprintf("Циклическое включение шаблонов: %s -> %s", strings.Join(stack, " -> "), name),
+				}
+				return match
+			}
+		}
+
+		included, ok := registry.templateByName(name)
+		if !ok {
+			err = &TemplateValidationException{
+				message: fmt.Sprintf("Включаемый шаблон %s не найден", name),
+			}
+			return match
+		}
+		var expanded string
+		expanded, err = registry.expand(included.Body, append(stack[:len(stack):len(stack)], name))
+		return expanded
+	})
+	return result, err
+}
+
+// dependents возвращает шаблоны, которые прямо или через другие шаблоны включают шаблон name
+func (registry *templateRegistry) dependents(name string) []*Template {
+	result := make([]*Template, 0)
+	visited := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, template := range registry.templates {
+			if visited[template.Name] || !includes(template, current) {
+				continue
+			}
+			visited[template.Name] = true
+			result = append(result, template)
+			queue = append(queue, template.Name)
+		}
+	}
+	return result
+}
+
+func includes(template *Template, name string) bool {
+	for _, included := range template.includes {
+		if included == name {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshDependents заменяет шаблоны, включающие шаблон name, копиями с заново раскрытыми включениями
+func (registry *templateRegistry) refreshDependents(name string) error {
+	for _, dependent := range registry.dependents(name) {
+		dependentCopy := *dependent
+		if err := registry.resolveIncludes(&dependentCopy); err != nil {
+			return err
+		}
+		registry.templates[dependentCopy.Id] = &dependentCopy
+	}
+	return nil
+}
+
+// checkNotIncluded запрещает удаление и переименование шаблона, который включают другие шаблоны
+func (registry *templateRegistry) checkNotIncluded(template *Template) error {
+	dependents := registry.dependents(template.Name)
+	if len(dependents) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(dependents))
+	for _, dependent := range dependents {
+		names = append(names, dependent.Name)
+	}
+	sort.Strings(names)
+	return &TemplateValidationException{
+		message: fmt.Sprintf("Шаблон %s включается в шаблоны %s", template.Name, strings.Join(names, ", ")),
+	}
+}
@@ -0,0 +1,73 @@
+package templates
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unimock/util"
+)
+
+func TestTemplateIncludes(t *testing.T) {
+	service := newTestService(t)
+
+	envelope := &Template{Name: "envelope", Body: "<Envelope>${include:fault}</Envelope>"}
+	fault := &Template{Name: "fault", Body: "<Fault>${code:-500}</Fault>"}
+	require.IsType(t, &TemplateValidationException{}, service.AddTemplate(envelope, ""), "Включаемый шаблон ещё не создан")
+	require.NoError(t, service.AddTemplate(fault, ""))
+	require.NoError(t, service.AddTemplate(envelope, ""))
+	response := &Template{Name: "response", Engine: GoTemplateEngine, Body: `{{ .Body.id }}:${include:envelope}`}
+	require.NoError(t, service.AddTemplate(response, ""))
+	require.NoError(t, service.AddExtractor(&Extractor{TemplateId: envelope.Id, Name: "code",
+		ExtractorType: GsonExtractorType, Expression: "code"}))
+
+	result, err := service.ProcessMessage(envelope.Id, &util.Message{Body: `{"code": 404}`})
+	require.NoError(t, err)
+	require.Equal(t, "<Envelope><Fault>404</Fault></Envelope>", result.Body,
+		"Плейсхолдеры фрагмента разрешаются извлекателями включающего шаблона")
+
+	fault.Body = "<Fault>{{ .Query.code }}</Fault>"
+	require.NoError(t, service.UpdateTemplate(fault, ""))
+	message := &util.Message{Body: `{"id": 7}`, Query: map[string]string{"code": "503"}}
+	result, err = service.ProcessMessage(response.Id, message)
+	require.NoError(t, err)
+	require.Equal(t, "7:<Envelope><Fault>503</Fault></Envelope>", result.Body)
+
+	require.NoError(t, service.UpdateFromDb())
+	result, err = service.ProcessMessage(response.Id, message)
+	require.NoError(t, err)
+	require.Equal(t, "7:<Envelope><Fault>503</Fault></Envelope>", result.Body)
+
+	fault.Body = "${include:response}"
+	err = service.UpdateTemplate(fault, "")
+	require.EqualError(t, err, "Циклическое включение шаблонов: fault -> response -> envelope -> fault")
+	fault.Body = "{{ .Body.id "
+	require.IsType(t, &TemplateValidationException{}, service.UpdateTemplate(fault, ""),
+		"Изменение не должно ломать компиляцию включающих шаблонов gotemplate")
+	published, err := service.GetTemplateById(fault.Id)
+	require.NoError(t, err)
+	require.Equal(t, "<Fault>{{ .Query.code }}</Fault>", published.Body)
+
+	require.IsType(t, &TemplateValidationException{}, service.DeleteTemplate(fault.Id, ""))
+	fault.Name = "renamed"
+	require.IsType(t, &TemplateValidationException{}, service.UpdateTemplate(fault, ""))
+	require.NoError(t, service.DeleteTemplate(response.Id, ""))
+	require.NoError(t, service.DeleteTemplate(envelope.Id, ""))
+	require.NoError(t, service.DeleteTemplate(fault.Id, ""))
+}
+
+func TestEscapedInclude(t *testing.T) {
+	service := newTestService(t)
+	fragment := &Template{Name: "fragment", Body: "FRAG"}
+	require.NoError(t, service.AddTemplate(fragment, ""))
+
+	recorded := &Template{Name: "recorded", Body: EscapeBody("<a>${include:missing}</a>")}
+	require.NoError(t, service.AddTemplate(recorded, ""), "Экранированная директива не требует шаблона")
+	result, err := service.ProcessMessage(recorded.Id, &util.Message{})
+	require.NoError(t, err)
+	require.Equal(t, "<a>${include:missing}</a>", result.Body)
+
+	mixed := &Template{Name: "mixed", Body: "${include:fragment} " + EscapeBody("${include:fragment}")}
+	require.NoError(t, service.AddTemplate(mixed, ""))
+	result, err = service.ProcessMessage(mixed.Id, &util.Message{})
+	require.NoError(t, err)
+	require.Equal(t, "FRAG ${include:fragment}", result.Body)
+}
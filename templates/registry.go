@@ -0,0 +1,55 @@
+package templates
+
+// templateRegistry - снимок шаблонов и извлекателей. Опубликованный снимок и входящие в него шаблоны не изменяются,
+// каждое изменение создаёт копию и атомарно подменяет снимок в TemplateService
+type templateRegistry struct {
+	templates  map[int64]*Template
+	extractors map[int64]*Extractor
+}
+
+func newTemplateRegistry() *templateRegistry {
+	return &templateRegistry{
+		templates:  make(map[int64]*Template),
+		extractors: make(map[int64]*Extractor),
+	}
+}
+
+func (registry *templateRegistry) clone() *templateRegistry {
+	result := &templateRegistry{
+		templates:  make(map[int64]*Template, len(registry.templates)),
+		extractors: make(map[int64]*Extractor, len(registry.extractors)),
+	}
+	for id, template := range registry.templates {
+		result.templates[id] = template
+	}
+	for id, extractor := range registry.extractors {
+		result.extractors[id] = extractor
+	}
+	return result
+}
+
+func (registry *templateRegistry) buildTemplateExtractors(templateId int64) map[string]MessageExtractor {
+	extractors := make(map[string]MessageExtractor)
+	for _, extractor := range registry.extractors {
+		if extractor.TemplateId == templateId {
+			extractors[extractor.Name] = extractor
+		}
+	}
+	return extractors
+}
+
+// putTemplate добавляет шаблон в снимок, связывая его с извлекателями снимка
+func (registry *templateRegistry) putTemplate(template *Template) {
+	template.extractors = registry.buildTemplateExtractors(template.Id)
+	registry.templates[template.Id] = template
+}
+
+// relinkExtractors заменяет шаблон копией с актуальным набором извлекателей
+func (registry *templateRegistry) relinkExtractors(templateId int64) {
+	template, ok := registry.templates[templateId]
+	if !ok {
+		return
+	}
+	templateCopy := *template
+	registry.putTemplate(&templateCopy)
+}
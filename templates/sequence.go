@@ -0,0 +1,162 @@
+package templates
+
+import (
+	"database/sql"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+	"unimock/database"
+)
+
+const upsertSequenceQuery = "INSERT INTO template_sequences (template_id, name, value) VALUES (?,?,?) " +
+	"ON CONFLICT (template_id, name) DO UPDATE SET value = excluded.value"
+const selectAllSequencesQuery = "SELECT template_id, name, value FROM template_sequences"
+const deleteSequencesByTemplateIdQuery = "DELETE FROM template_sequences WHERE template_id = ?"
+
+// sequenceFlushDelay - задержка записи изменённых счётчиков в БД, за это время изменения накапливаются
+const sequenceFlushDelay = 200 * time.Millisecond
+
+type sequenceKey struct {
+	templateId int64
+	name       string
+}
+
+// sequenceStore - счётчики генератора seq. Значения выдаются из памяти, изменённые счётчики пачкой
+// записываются в БД через sequenceFlushDelay, поэтому нумерация продолжается после перезапуска
+type sequenceStore struct {
+	db *sql.DB
+	// flushMut упорядочивает запись счётчиков в БД с их удалением и загрузкой
+	flushMut  sync.Mutex
+	mut       sync.Mutex
+	values    map[sequenceKey]int64
+	dirty     map[sequenceKey]struct{}
+	scheduled bool
+}
+
+func newSequenceStore(db *sql.DB) *sequenceStore {
+	return &sequenceStore{
+		db:     db,
+		values: make(map[sequenceKey]int64),
+		dirty:  make(map[sequenceKey]struct{}),
+	}
+}
+
+func (store *sequenceStore) next(templateId int64, name string) (int64, error) {
+	store.mut.Lock()
+	defer store.mut.Unlock()
+
+	key := sequenceKey{templateId: templateId, name: name}
+	value := store.values[key] + 1
+	store.values[key] = value
+	store.dirty[key] = struct{}{}
+	store.scheduleFlush()
+	return value, nil
+}
+
+// scheduleFlush планирует запись изменённых счётчиков, вызывается под mut
+func (store *sequenceStore) scheduleFlush() {
+	if store.scheduled {
+		return
+	}
+	store.scheduled = true
+	time.AfterFunc(sequenceFlushDelay, func() {
+		if err := store.flush(); err != nil {
+			log.Error().Err(err).Msg("Не удалось сохранить счётчики генератора seq")
+		}
+	})
+}
+
+// flush записывает изменённые счётчики в БД одной транзакцией
+func (store *sequenceStore) flush() error {
+	store.flushMut.Lock()
+	defer store.flushMut.Unlock()
+	return store.flushLocked()
+}
+
+func (store *sequenceStore) flushLocked() error {
+	store.mut.Lock()
+	pending := make(map[sequenceKey]int64, len(store.dirty))
+	for key := range store.dirty {
+		pending[key] = store.values[key]
+	}
+	store.dirty = make(map[sequenceKey]struct{})
+	store.scheduled = false
+	store.mut.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	err := database.InTransaction(store.db, func(tx *sql.Tx) error {
+		for key, value := range pending {
+			if _, err := tx.Exec(upsertSequenceQuery, key.templateId, key.name, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Несохранённые счётчики записываются при следующей попытке
+		store.mut.Lock()
+		for key := range pending {
+			store.dirty[key] = struct{}{}
+		}
+		store.scheduleFlush()
+		store.mut.Unlock()
+	}
+	return err
+}
+
+func (store *sequenceStore) deleteTemplate(templateId int64) error {
+	store.flushMut.Lock()
+	defer store.flushMut.Unlock()
+
+	if _, err := store.db.Exec(deleteSequencesByTemplateIdQuery, templateId); err != nil {
+		return err
+	}
+	store.mut.Lock()
+	defer store.mut.Unlock()
+	for key := range store.values {
+		if key.templateId == templateId {
+			delete(store.values, key)
+			delete(store.dirty, key)
+		}
+	}
+	return nil
+}
+
+func (store *sequenceStore) updateFromDb() error {
+	store.flushMut.Lock()
+	defer store.flushMut.Unlock()
+
+	if err := store.flushLocked(); err != nil {
+		return err
+	}
+	rows, err := store.db.Query(selectAllSequencesQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make(map[sequenceKey]int64)
+	for rows.Next() {
+		var key sequenceKey
+		var value int64
+		if err = rows.Scan(&key.templateId, &key.name, &value); err != nil {
+			return err
+		}
+		values[key] = value
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	store.mut.Lock()
+	// Значения, выданные во время загрузки, ещё не записаны и новее прочитанных
+	for key := range store.dirty {
+		values[key] = store.values[key]
+	}
+	store.values = values
+	store.mut.Unlock()
+	return nil
+}
@@ -1,46 +1,188 @@
 package templates
 
 import (
+	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
+	gotemplate "text/template"
 	"unimock/util"
 )
 
-var variableRegexp = regexp.MustCompile(`\$\{\d+}`)
+const placeholderNamePattern = `[\w.\-]+`
+
+// escapedVariablePrefix - $${name} выводится как ${name} без подстановки
+const escapedVariablePrefix = "$${"
+const defaultValueSeparator = ":-"
+
+var variableRegexp = regexp.MustCompile(`\$?\$\{(` + placeholderNamePattern + `)(?::-[^}]*)?}`)
 
 type Template struct {
-	Id         int64  `json:"id"`
-	Name       string `json:"name"`
-	Body       string `json:"body"`
-	Subsystem  string `json:"subsystem"`
-	extractors map[int]MessageExtractor
+	Id         int64             `json:"id"`
+	Name       string            `json:"name"`
+	Body       string            `json:"body"`
+	Subsystem  string            `json:"subsystem"`
+	Strict     bool              `json:"strict"`
+	StatusCode int               `json:"status_code"`
+	Headers    map[string]string `json:"headers"`
+	// Engine - движок шаблона, по умолчанию simple
+	Engine TemplateEngine `json:"engine"`
+	// ContentType - значение заголовка Content-Type ответа
+	ContentType string `json:"content_type,omitempty"`
+	// BinaryBody - двоичное тело, в API передаётся в base64. Вместе с File загружает содержимое файла
+	BinaryBody []byte `json:"binary_body,omitempty"`
+	// File - имя файла с телом в каталоге файлов шаблонов, файл отправляется клиенту потоком
+	File string `json:"file,omitempty"`
+	// filePath - путь к файлу тела с учётом каталога файлов шаблонов
+	filePath   string
+	extractors map[string]MessageExtractor
+	// sequences - счётчики генератора seq, задаются сервисом шаблонов
+	sequences *sequenceStore
+	// includes и resolvedBody - имена включаемых шаблонов и тело с раскрытыми включениями
+	includes     []string
+	resolvedBody string
+	// compiled - разобранный при сохранении шаблон движка gotemplate
+	compiled *gotemplate.Template
+}
+
+// EscapeBody экранирует последовательности ${ в тексте, чтобы шаблон вернул его без подстановок
+func EscapeBody(body string) string {
+	return strings.ReplaceAll(body, "${", escapedVariablePrefix)
 }
 
-func (template *Template) validate() bool {
-	return template.Name != ""
+// body возвращает тело шаблона с раскрытыми включениями
+func (template *Template) body() string {
+	if len(template.includes) > 0 {
+		return template.resolvedBody
+	}
+	return template.Body
 }
 
-func (template *Template) ProcessMessage(message *util.Message) *util.Message {
+func (template *Template) validate() error {
+	if template.Name == "" {
+		return &TemplateValidationException{message: "Не указано имя шаблона"}
+	}
+	if template.StatusCode != 0 && (template.StatusCode < 100 || template.StatusCode > 599) {
+		return &TemplateValidationException{message: fmt.Sprintf("Некорректный код ответа %d", template.StatusCode)}
+	}
+	switch template.Engine {
+	case "", SimpleEngine, GoTemplateEngine:
+	default:
+		return &TemplateValidationException{message: fmt.Sprintf("Неизвестный движок шаблона '%s'", template.Engine)}
+	}
+	return template.validateBinary()
+}
 
-	resultBody := variableRegexp.ReplaceAllStringFunc(template.Body, func(match string) string {
-		idStr := strings.TrimSuffix(strings.TrimPrefix(match, "${"), "}")
-		id, err := strconv.Atoi(idStr)
-		if err != nil {
-			return match
+// prepare проверяет шаблон и компилирует его для движка gotemplate
+func (template *Template) prepare() error {
+	if err := template.validate(); err != nil {
+		return err
+	}
+	if template.Engine == "" {
+		template.Engine = SimpleEngine
+	}
+	return template.compile()
+}
+
+func (template *Template) ProcessMessage(message *util.Message) (*util.Message, error) {
+	generator := newGenerator(template, message)
+	result, err := template.processMessage(message, generator)
+	if err == nil {
+		err = generator.err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (template *Template) processMessage(message *util.Message, generator *generator) (*util.Message, error) {
+	if template.isBinary() {
+		return template.processBinary(message, generator)
+	}
+	if template.compiled != nil {
+		return template.render(message, generator)
+	}
+	unresolved := make([]string, 0)
+
+	result := &util.Message{
+		Body:       template.substitute(template.body(), message, generator, &unresolved),
+		Headers:    make(map[string]string, len(template.Headers)),
+		StatusCode: template.StatusCode,
+		Variables:  message.Variables,
+	}
+	template.setContentType(result)
+
+	for name, value := range template.Headers {
+		HeaderUpdater{headerName: name}.Update(result, template.substitute(value, message, generator, &unresolved))
+	}
+	generator.setSeedHeader(result)
+
+	if err := template.checkUnresolved(unresolved); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ProcessText подставляет в произвольный текст значения плейсхолдеров, извлечённые экстракторами шаблона
+func (template *Template) ProcessText(text string, message *util.Message) (string, error) {
+	generator := newGenerator(template, message)
+	result, err := template.processText(text, message, generator)
+	if err == nil {
+		err = generator.err()
+	}
+	if err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+func (template *Template) processText(text string, message *util.Message, generator *generator) (string, error) {
+	if template.compiled != nil {
+		return template.renderText(text, message, generator)
+	}
+	unresolved := make([]string, 0)
+	result := template.substitute(text, message, generator, &unresolved)
+	if err := template.checkUnresolved(unresolved); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+func (template *Template) checkUnresolved(unresolved []string) error {
+	if template.Strict && len(unresolved) > 0 {
+		return &TemplateValidationException{
+			message: fmt.Sprintf("Не удалось подставить значения плейсхолдеров %s в шаблон %s",
+				strings.Join(unresolved, ", "), template.Name),
+		}
+	}
+	return nil
+}
+
+// substitute заменяет генераторы и плейсхолдеры в text значениями из message, имена неразрешённых плейсхолдеров
+// добавляются в unresolved
+func (template *Template) substitute(text string, message *util.Message, generator *generator, unresolved *[]string) string {
+	text = unescapeIncludes(generator.substitute(text, unresolved))
+	return variableRegexp.ReplaceAllStringFunc(text, func(match string) string {
+		if strings.HasPrefix(match, escapedVariablePrefix) {
+			return match[1:]
 		}
 
-		if extractor, ok := template.extractors[id]; ok {
+		name := variableRegexp.FindStringSubmatch(match)[1]
+		if extractor, ok := template.extractors[name]; ok {
 			if result, ok2 := extractor.Extract(message); ok2 {
 				return result
 			}
 		}
+		if value, ok := message.Variables[name]; ok {
+			return value
+		}
+
+		if index := strings.Index(match, defaultValueSeparator); index >= 0 {
+			return match[index+len(defaultValueSeparator) : len(match)-1]
+		}
 
+		*unresolved = append(*unresolved, name)
 		return match
 	})
-
-	return &util.Message{
-		Body:    resultBody,
-		Headers: map[string]string{},
-	}
 }
@@ -2,31 +2,61 @@ package templates
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unimock/database"
+	"unimock/history"
 	"unimock/util"
 )
 
-const InsertQuery = "INSERT INTO templates (name, body, subsystem) VALUES (?,?,?)"
-const SelectAllQuery = "SELECT * FROM templates"
-const UpdateQuery = "UPDATE templates SET name = ?, body = ?, subsystem = ? where id = ?"
+const InsertQuery = "INSERT INTO templates (name, body, subsystem, strict, status_code, headers, engine, content_type, binary_body, file) VALUES (?,?,?,?,?,?,?,?,?,?)"
+const InsertWithIdQuery = "INSERT INTO templates (id, name, body, subsystem, strict, status_code, headers, engine, content_type, binary_body, file) VALUES (?,?,?,?,?,?,?,?,?,?,?)"
+const SelectAllQuery = "SELECT id, name, body, subsystem, strict, status_code, headers, engine, content_type, binary_body, file FROM templates"
+const UpdateQuery = "UPDATE templates SET name = ?, body = ?, subsystem = ?, strict = ?, status_code = ?, headers = ?, engine = ?, content_type = ?, binary_body = ?, file = ? where id = ?"
 const DeleteQuery = "DELETE FROM templates WHERE id = ?"
 
+const InsertExtractorQuery = "INSERT INTO template_extractors (template_id, name, type, expression) VALUES (?,?,?,?)"
+const InsertExtractorWithIdQuery = "INSERT INTO template_extractors (id, template_id, name, type, expression) VALUES (?,?,?,?,?)"
+const SelectAllExtractorsQuery = "SELECT id, template_id, name, type, expression FROM template_extractors"
+const UpdateExtractorQuery = "UPDATE template_extractors SET name = ?, type = ?, expression = ? where id = ?"
+const DeleteExtractorQuery = "DELETE FROM template_extractors WHERE id = ?"
+const DeleteExtractorsByTemplateIdQuery = "DELETE FROM template_extractors WHERE template_id = ?"
+
 type TemplateService struct {
-	templates map[int64]*Template
-	db        *sql.DB
+	registry atomic.Pointer[templateRegistry]
+	// mut упорядочивает изменения реестра, чтение выполняется без блокировок
+	mut      sync.Mutex
+	db       *sql.DB
+	versions *history.History
+	// filesDirectory - каталог файлов тел шаблонов
+	filesDirectory string
+	sequences      *sequenceStore
 }
 
 func NewService(db *sql.DB) *TemplateService {
-	return &TemplateService{
-		templates: make(map[int64]*Template),
+	service := &TemplateService{
 		db:        db,
+		versions:  history.NewHistory(db, history.TemplateVersionsTable),
+		sequences: newSequenceStore(db),
 	}
+	service.registry.Store(newTemplateRegistry())
+	return service
+}
+
+// Shutdown сохраняет в БД ещё не записанные значения счётчиков генератора seq
+func (service *TemplateService) Shutdown() error {
+	return service.sequences.flush()
 }
 
 func (service *TemplateService) GetTemplates() []*Template {
-	templateValues := make([]*Template, 0, len(service.templates))
+	templates := service.registry.Load().templates
+	templateValues := make([]*Template, 0, len(templates))
 
-	for _, value := range service.templates {
+	for _, value := range templates {
 		templateValues = append(templateValues, value)
 	}
 
@@ -34,13 +64,20 @@ func (service *TemplateService) GetTemplates() []*Template {
 }
 
 func (service *TemplateService) GetTemplatesWithoutBody() []Template {
-	templateValues := make([]Template, 0, len(service.templates))
+	templates := service.registry.Load().templates
+	templateValues := make([]Template, 0, len(templates))
 
-	for _, value := range service.templates {
+	for _, value := range templates {
 		templateValues = append(templateValues, Template{
-			Id:        value.Id,
-			Name:      value.Name,
-			Subsystem: value.Subsystem,
+			Id:          value.Id,
+			Name:        value.Name,
+			Subsystem:   value.Subsystem,
+			Strict:      value.Strict,
+			StatusCode:  value.StatusCode,
+			Headers:     value.Headers,
+			Engine:      value.Engine,
+			ContentType: value.ContentType,
+			File:        value.File,
 		})
 	}
 
@@ -48,7 +85,7 @@ func (service *TemplateService) GetTemplatesWithoutBody() []Template {
 }
 
 func (service *TemplateService) GetTemplateById(id int64) (*Template, error) {
-	template, ok := service.templates[id]
+	template, ok := service.registry.Load().templates[id]
 
 	if !ok {
 		return nil, &TemplateNotFoundException{
@@ -59,91 +96,464 @@ func (service *TemplateService) GetTemplateById(id int64) (*Template, error) {
 	}
 }
 
-func (service *TemplateService) AddTemplate(template *Template) error {
-	if !template.validate() {
-		return &TemplateValidationException{message: "Не указано имя шаблона"}
+// SetFilesDirectory задаёт каталог, в котором хранятся файлы тел шаблонов
+func (service *TemplateService) SetFilesDirectory(directory string) {
+	service.filesDirectory = directory
+}
+
+// Versions возвращает историю изменений шаблонов
+func (service *TemplateService) Versions() *history.History {
+	return service.versions
+}
+
+// deletedTemplate - снимок удалённого шаблона. Извлекатели удаляются вместе с шаблоном и не версионируются
+// отдельно, поэтому сохраняются в его последней версии
+type deletedTemplate struct {
+	*Template
+	Extractors []*Extractor `json:"extractors"`
+}
+
+func (service *TemplateService) AddTemplate(template *Template, author string) error {
+	return service.addTemplate(template, nil, author, history.Created)
+}
+
+// addTemplate сохраняет новый шаблон с извлекателями extractors. При восстановлении удалённого шаблона из версии
+// (операция RolledBack) шаблон и извлекатели сохраняются с прежними id, чтобы ссылки шагов сценариев и история
+// версий остались связаны с ними
+func (service *TemplateService) addTemplate(template *Template, extractors []*Extractor, author string,
+	operation history.Operation) error {
+	if err := template.prepare(); err != nil {
+		return err
 	}
-	insertStatement, err := service.db.Prepare(InsertQuery)
+	for _, extractor := range extractors {
+		extractor.TemplateId = template.Id
+		if err := extractor.validate(); err != nil {
+			return err
+		}
+		if err := extractor.prepare(); err != nil {
+			return err
+		}
+	}
+	headers, err := buildHeadersForDb(template.Headers)
 	if err != nil {
 		return err
 	}
-	res, err := insertStatement.Exec(template.Name, template.Body, template.Subsystem)
+
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	file, err := service.storeFile(template)
 	if err != nil {
 		return err
 	}
+	defer file.discard()
+	template.sequences = service.sequences
+	registry := service.registry.Load().clone()
+	if err = registry.resolveIncludes(template); err != nil {
+		return err
+	}
 
-	template.Id, err = res.LastInsertId()
+	err = database.InTransaction(service.db, func(tx *sql.Tx) error {
+		var res sql.Result
+		if operation == history.RolledBack {
+			res, err = tx.Exec(InsertWithIdQuery, template.Id, template.Name, template.Body, template.Subsystem,
+				template.Strict, template.StatusCode, headers, template.Engine, template.ContentType,
+				template.BinaryBody, template.File)
+		} else {
+			res, err = tx.Exec(InsertQuery, template.Name, template.Body, template.Subsystem, template.Strict,
+				template.StatusCode, headers, template.Engine, template.ContentType, template.BinaryBody,
+				template.File)
+		}
+		if err != nil {
+			return err
+		}
+		if template.Id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		for _, extractor := range extractors {
+			_, err = tx.Exec(InsertExtractorWithIdQuery, extractor.Id, extractor.TemplateId, extractor.Name,
+				extractor.ExtractorType, extractor.Expression)
+			if err != nil {
+				return err
+			}
+		}
+		if err = service.versions.Record(tx, template.Id, operation, author, template); err != nil {
+			return err
+		}
+		return file.apply()
+	})
 	if err != nil {
 		return err
 	}
+	file.release()
+
+	for _, extractor := range extractors {
+		registry.extractors[extractor.Id] = extractor
+	}
+	registry.putTemplate(template)
+	service.registry.Store(registry)
+	return nil
+}
+
+func (service *TemplateService) UpdateTemplate(template *Template, author string) error {
+	return service.updateTemplate(template, author, history.Updated)
+}
 
-	err = insertStatement.Close()
+// RollbackTemplate восстанавливает шаблон из сохранённой версии, откат записывается в историю новой версией.
+// Удалённый шаблон создаётся заново вместе с извлекателями из версии, записанной при удалении
+func (service *TemplateService) RollbackTemplate(id int64, version int64, author string) error {
+	snapshot, err := service.versions.GetVersion(id, version)
 	if err != nil {
 		return err
 	}
+	template := new(Template)
+	if err = json.Unmarshal(snapshot.Snapshot, template); err != nil {
+		return err
+	}
+	template.Id = id
+	// Содержимое файла в версиях не хранится, откат вернул бы только имя файла
+	if template.File != "" {
+		return &TemplateValidationException{
+			message: fmt.Sprintf("Версию файлового шаблона %d нельзя восстановить", id),
+		}
+	}
+	if _, err = service.GetTemplateById(id); err == nil {
+		return service.updateTemplate(template, author, history.RolledBack)
+	}
 
-	service.templates[template.Id] = template
-	return nil
+	latest, err := service.versions.GetLatestVersion(id)
+	if err != nil {
+		return err
+	}
+	if latest.Operation != history.Deleted {
+		return &TemplateNotFoundException{message: fmt.Sprintf("Шаблон с id = %d не найден", id)}
+	}
+	deleted := &deletedTemplate{Template: new(Template)}
+	if err = json.Unmarshal(latest.Snapshot, deleted); err != nil {
+		return err
+	}
+	return service.addTemplate(template, deleted.Extractors, author, history.RolledBack)
 }
 
-func (service *TemplateService) UpdateTemplate(template *Template) error {
-	if !template.validate() {
-		return &TemplateValidationException{message: "Не указано имя шаблона"}
+func (service *TemplateService) updateTemplate(template *Template, author string, operation history.Operation) error {
+	if err := template.prepare(); err != nil {
+		return err
 	}
-	updateStatement, err := service.db.Prepare(UpdateQuery)
+	headers, err := buildHeadersForDb(template.Headers)
 	if err != nil {
 		return err
 	}
-	_, err = updateStatement.Exec(template.Name, template.Body, template.Subsystem, template.Id)
+
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	file, err := service.storeFile(template)
 	if err != nil {
 		return err
 	}
+	defer file.discard()
+	template.sequences = service.sequences
+	registry := service.registry.Load().clone()
+	if previous, ok := registry.templates[template.Id]; ok && previous.Name != template.Name {
+		if err = registry.checkNotIncluded(previous); err != nil {
+			return err
+		}
+	}
+	registry.putTemplate(template)
+	if err = registry.resolveIncludes(template); err != nil {
+		return err
+	}
+	// Шаблоны, включающие изменённый, компилируются заново с его новым телом
+	if err = registry.refreshDependents(template.Name); err != nil {
+		return err
+	}
 
-	err = updateStatement.Close()
+	err = database.InTransaction(service.db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(UpdateQuery, template.Name, template.Body, template.Subsystem, template.Strict,
+			template.StatusCode, headers, template.Engine, template.ContentType, template.BinaryBody, template.File,
+			template.Id)
+		if err != nil {
+			return err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return &TemplateNotFoundException{message: fmt.Sprintf("Шаблон с id = %d не найден", template.Id)}
+		}
+		if err = service.versions.Record(tx, template.Id, operation, author, template); err != nil {
+			return err
+		}
+		return file.apply()
+	})
 	if err != nil {
 		return err
 	}
+	file.release()
 
-	service.templates[template.Id] = template
+	service.registry.Store(registry)
 	return nil
 }
 
-func (service *TemplateService) DeleteTemplate(id int64) error {
-	deleteStatement, err := service.db.Prepare(DeleteQuery)
-	if err != nil {
-		return err
+func (service *TemplateService) DeleteTemplate(id int64, author string) error {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	registry := service.registry.Load().clone()
+	template, ok := registry.templates[id]
+	if ok {
+		if err := registry.checkNotIncluded(template); err != nil {
+			return err
+		}
 	}
-	_, err = deleteStatement.Exec(id)
+
+	err := database.InTransaction(service.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(DeleteQuery, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(DeleteExtractorsByTemplateIdQuery, id); err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		snapshot := &deletedTemplate{Template: template, Extractors: make([]*Extractor, 0)}
+		for _, extractor := range registry.extractors {
+			if extractor.TemplateId == id {
+				snapshot.Extractors = append(snapshot.Extractors, extractor)
+			}
+		}
+		sort.Slice(snapshot.Extractors, func(i, j int) bool {
+			return snapshot.Extractors[i].Id < snapshot.Extractors[j].Id
+		})
+		return service.versions.Record(tx, id, history.Deleted, author, snapshot)
+	})
 	if err != nil {
 		return err
 	}
 
-	err = deleteStatement.Close()
+	for extractorId, extractor := range registry.extractors {
+		if extractor.TemplateId == id {
+			delete(registry.extractors, extractorId)
+		}
+	}
+	delete(registry.templates, id)
+	service.registry.Store(registry)
+	if ok {
+		template.removeFile()
+	}
+
+	return service.sequences.deleteTemplate(id)
+}
+
+func buildHeadersForDb(headers map[string]string) (string, error) {
+	if len(headers) == 0 {
+		return "", nil
+	}
+	res, err := json.Marshal(headers)
 	if err != nil {
-		return err
+		return "", &TemplateValidationException{message: err.Error()}
 	}
+	return string(res), nil
+}
 
-	delete(service.templates, id)
-	return nil
+func getHeadersFromString(headersRow string) (map[string]string, error) {
+	headers := make(map[string]string)
+	if headersRow == "" {
+		return headers, nil
+	}
+	err := json.Unmarshal([]byte(headersRow), &headers)
+	return headers, err
 }
 
 func (service *TemplateService) UpdateFromDb() error {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
 	rows, err := service.db.Query(SelectAllQuery)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
-	service.templates = make(map[int64]*Template)
+	registry := newTemplateRegistry()
 
 	for rows.Next() {
 		var t Template
-		err = rows.Scan(&t.Id, &t.Name, &t.Body, &t.Subsystem)
+		var headersRow string
+		err = rows.Scan(&t.Id, &t.Name, &t.Body, &t.Subsystem, &t.Strict, &t.StatusCode, &headersRow, &t.Engine,
+			&t.ContentType, &t.BinaryBody, &t.File)
 		if err != nil {
 			return err
 		}
 
-		service.templates[t.Id] = &t
+		t.Headers, err = getHeadersFromString(headersRow)
+		if err != nil {
+			return err
+		}
+		if t.File != "" {
+			t.filePath = filepath.Join(service.filesDirectory, t.File)
+		}
+		t.sequences = service.sequences
+
+		registry.templates[t.Id] = &t
+	}
+
+	for _, template := range registry.templates {
+		if err = registry.resolveIncludes(template); err != nil {
+			return err
+		}
+	}
+
+	if err = service.updateExtractorsFromDb(registry); err != nil {
+		return err
+	}
+	if err = service.sequences.updateFromDb(); err != nil {
+		return err
 	}
+
+	service.registry.Store(registry)
+	return nil
+}
+
+func (service *TemplateService) updateExtractorsFromDb(registry *templateRegistry) error {
+	rows, err := service.db.Query(SelectAllExtractorsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Extractor
+		err = rows.Scan(&e.Id, &e.TemplateId, &e.Name, &e.ExtractorType, &e.Expression)
+		if err != nil {
+			return err
+		}
+
+		err = e.prepare()
+		if err != nil {
+			return err
+		}
+
+		registry.extractors[e.Id] = &e
+	}
+
+	for _, template := range registry.templates {
+		registry.putTemplate(template)
+	}
+	return nil
+}
+
+func (service *TemplateService) GetExtractors(templateId int64) ([]*Extractor, error) {
+	if _, err := service.GetTemplateById(templateId); err != nil {
+		return nil, err
+	}
+
+	extractors := make([]*Extractor, 0)
+	for _, extractor := range service.registry.Load().extractors {
+		if extractor.TemplateId == templateId {
+			extractors = append(extractors, extractor)
+		}
+	}
+
+	sort.Slice(extractors, func(i, j int) bool {
+		return extractors[i].Name < extractors[j].Name
+	})
+	return extractors, nil
+}
+
+func (service *TemplateService) getExtractor(templateId int64, id int64) (*Extractor, error) {
+	extractor, ok := service.registry.Load().extractors[id]
+	if !ok || extractor.TemplateId != templateId {
+		return nil, &ExtractorNotFoundException{
+			message: fmt.Sprintf("Извлекатель с id = %d для шаблона с id = %d не найден", id, templateId),
+		}
+	}
+	return extractor, nil
+}
+
+func (service *TemplateService) AddExtractor(extractor *Extractor) error {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	_, err := service.GetTemplateById(extractor.TemplateId)
+	if err != nil {
+		return err
+	}
+	if err := extractor.validate(); err != nil {
+		return err
+	}
+	if err := extractor.prepare(); err != nil {
+		return err
+	}
+
+	res, err := service.db.Exec(InsertExtractorQuery, extractor.TemplateId, extractor.Name,
+		extractor.ExtractorType, extractor.Expression)
+	if err != nil {
+		return err
+	}
+
+	extractor.Id, err = res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	registry := service.registry.Load().clone()
+	registry.extractors[extractor.Id] = extractor
+	registry.relinkExtractors(extractor.TemplateId)
+	service.registry.Store(registry)
+	return nil
+}
+
+func (service *TemplateService) UpdateExtractor(extractor *Extractor) error {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	_, err := service.GetTemplateById(extractor.TemplateId)
+	if err != nil {
+		return err
+	}
+	if _, err := service.getExtractor(extractor.TemplateId, extractor.Id); err != nil {
+		return err
+	}
+	if err := extractor.validate(); err != nil {
+		return err
+	}
+	if err := extractor.prepare(); err != nil {
+		return err
+	}
+
+	_, err = service.db.Exec(UpdateExtractorQuery, extractor.Name, extractor.ExtractorType,
+		extractor.Expression, extractor.Id)
+	if err != nil {
+		return err
+	}
+
+	registry := service.registry.Load().clone()
+	registry.extractors[extractor.Id] = extractor
+	registry.relinkExtractors(extractor.TemplateId)
+	service.registry.Store(registry)
+	return nil
+}
+
+func (service *TemplateService) DeleteExtractor(templateId int64, id int64) error {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	if _, err := service.getExtractor(templateId, id); err != nil {
+		return err
+	}
+
+	_, err := service.db.Exec(DeleteExtractorQuery, id)
+	if err != nil {
+		return err
+	}
+
+	registry := service.registry.Load().clone()
+	delete(registry.extractors, id)
+	registry.relinkExtractors(templateId)
+	service.registry.Store(registry)
 	return nil
 }
 
@@ -152,5 +562,5 @@ func (service *TemplateService) ProcessMessage(templateId int64, message *util.M
 	if err != nil {
 		return nil, err
 	}
-	return template.ProcessMessage(message), nil
+	return template.ProcessMessage(message)
 }
@@ -0,0 +1,151 @@
+package templates
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"unimock/database"
+	"unimock/history"
+	"unimock/util"
+)
+
+func newTestService(t *testing.T) *TemplateService {
+	dbFile := filepath.Join(t.TempDir(), "unimock.db")
+	require.NoError(t, os.WriteFile(dbFile, nil, 0o644))
+
+	db, err := database.InitDatabaseConnection(dbFile, "../sql")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	service := NewService(db)
+	require.NoError(t, service.UpdateFromDb())
+	return service
+}
+
+func TestConcurrentTemplateCrudAndProcessing(t *testing.T) {
+	service := newTestService(t)
+
+	template := &Template{Name: "base", Body: "id=${id:-none}"}
+	require.NoError(t, service.AddTemplate(template, ""))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				name := fmt.Sprintf("template-%d-%d", writer, j)
+				added := &Template{Name: name, Body: "body"}
+				assert.NoError(t, service.AddTemplate(added, ""))
+				assert.NoError(t, service.UpdateTemplate(&Template{Id: added.Id, Name: name, Body: "updated"}, ""))
+
+				extractor := &Extractor{TemplateId: template.Id, Name: fmt.Sprintf("e%d_%d", writer, j),
+					ExtractorType: GsonExtractorType, Expression: "id"}
+				assert.NoError(t, service.AddExtractor(extractor))
+				assert.NoError(t, service.DeleteExtractor(template.Id, extractor.Id))
+				assert.NoError(t, service.DeleteTemplate(added.Id, ""))
+			}
+		}(i)
+	}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				result, err := service.ProcessMessage(template.Id, &util.Message{Body: `{"id": 1}`})
+				assert.NoError(t, err)
+				assert.Equal(t, "id=none", result.Body)
+				_ = service.GetTemplates()
+				_ = service.GetTemplatesWithoutBody()
+			}
+		}()
+	}
+
+	wg.Wait()
+	require.Len(t, service.GetTemplates(), 1)
+}
+
+func TestExtractorChangesDoNotMutatePublishedTemplate(t *testing.T) {
+	service := newTestService(t)
+
+	template := &Template{Name: "base", Body: "id=${id:-none}"}
+	require.NoError(t, service.AddTemplate(template, ""))
+	published, err := service.GetTemplateById(template.Id)
+	require.NoError(t, err)
+
+	require.NoError(t, service.AddExtractor(&Extractor{TemplateId: template.Id, Name: "id",
+		ExtractorType: GsonExtractorType, Expression: "id"}))
+
+	oldResult, err := published.ProcessMessage(&util.Message{Body: `{"id": 1}`})
+	require.NoError(t, err)
+	require.Equal(t, "id=none", oldResult.Body)
+
+	newResult, err := service.ProcessMessage(template.Id, &util.Message{Body: `{"id": 1}`})
+	require.NoError(t, err)
+	require.Equal(t, "id=1", newResult.Body)
+}
+
+func TestTemplateVersions(t *testing.T) {
+	service := newTestService(t)
+
+	template := &Template{Name: "order", Body: "v1"}
+	require.NoError(t, service.AddTemplate(template, "alice"))
+	template.Body = "v2 ${id}"
+	require.NoError(t, service.UpdateTemplate(template, "bob"))
+	extractor := &Extractor{TemplateId: template.Id, Name: "id", ExtractorType: JsonPathExtractorType, Expression: "$.id"}
+	require.NoError(t, service.AddExtractor(extractor))
+
+	require.NoError(t, service.RollbackTemplate(template.Id, 1, "carol"))
+	restored, err := service.GetTemplateById(template.Id)
+	require.NoError(t, err)
+	require.Equal(t, "v1", restored.Body)
+
+	versions, err := service.Versions().GetVersions(template.Id)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	require.Equal(t, history.RolledBack, versions[2].Operation)
+	require.Equal(t, "carol", versions[2].Author)
+
+	require.IsType(t, &history.VersionNotFoundException{}, service.RollbackTemplate(template.Id, 7, ""))
+	require.NoError(t, service.DeleteTemplate(template.Id, "dave"))
+	version, err := service.Versions().GetVersion(template.Id, 4)
+	require.NoError(t, err)
+	require.Equal(t, history.Deleted, version.Operation)
+
+	require.NoError(t, service.RollbackTemplate(template.Id, 2, "erin"), "Удалённый шаблон восстанавливается")
+	require.NoError(t, service.UpdateFromDb())
+	restored, err = service.GetTemplateById(template.Id)
+	require.NoError(t, err)
+	require.Equal(t, "v2 ${id}", restored.Body)
+	extractors, err := service.GetExtractors(template.Id)
+	require.NoError(t, err)
+	require.Len(t, extractors, 1, "Извлекатели восстанавливаются вместе с шаблоном")
+	require.Equal(t, extractor.Id, extractors[0].Id)
+	require.Equal(t, "$.id", extractors[0].Expression)
+	result, err := service.ProcessMessage(template.Id, &util.Message{Body: `{"id": "7"}`})
+	require.NoError(t, err)
+	require.Equal(t, "v2 7", result.Body)
+	version, err = service.Versions().GetVersion(template.Id, 5)
+	require.NoError(t, err)
+	require.Equal(t, history.RolledBack, version.Operation)
+
+	_, err = service.db.Exec("DROP TABLE template_versions")
+	require.NoError(t, err)
+	changed := *restored
+	changed.Body = "v3"
+	require.Error(t, service.UpdateTemplate(&changed, ""))
+	require.NoError(t, service.UpdateFromDb())
+	restored, err = service.GetTemplateById(template.Id)
+	require.NoError(t, err)
+	require.Equal(t, "v2 ${id}", restored.Body, "Изменение не сохраняется без записи в историю")
+
+	missing := &Template{Id: 100, Name: "missing", Body: "missing"}
+	require.IsType(t, &TemplateNotFoundException{}, service.UpdateTemplate(missing, ""))
+	_, err = service.GetTemplateById(missing.Id)
+	require.IsType(t, &TemplateNotFoundException{}, err, "Несуществующий шаблон не добавляется при изменении")
+}
@@ -0,0 +1,92 @@
+package templates
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unimock/util"
+)
+
+func createTemplate(t *testing.T, body string, extractors ...*Extractor) *Template {
+	template := &Template{Body: body, extractors: make(map[string]MessageExtractor)}
+	for _, extractor := range extractors {
+		require.NoError(t, extractor.prepare())
+		template.extractors[extractor.Name] = extractor
+	}
+	return template
+}
+
+func TestProcessMessageWithExtractors(t *testing.T) {
+	template := createTemplate(t, "${0}|${1}|${2}|${3}|${4}|${5}|${6}|${7}",
+		&Extractor{Name: "0", ExtractorType: GsonExtractorType, Expression: "order.id"},
+		&Extractor{Name: "1", ExtractorType: JsonPathExtractorType, Expression: "$.order.items[0].sku"},
+		&Extractor{Name: "2", ExtractorType: RegexExtractorType, Expression: `"sku":\s*"(?P<value>[A-Z]+)-\d+"`},
+		&Extractor{Name: "3", ExtractorType: HeaderExtractorType, Expression: "X-Request-Id"},
+		&Extractor{Name: "4", ExtractorType: QueryExtractorType, Expression: "page"},
+		&Extractor{Name: "5", ExtractorType: PathSegmentExtractorType, Expression: "1"},
+		&Extractor{Name: "6", ExtractorType: JsonPathExtractorType, Expression: "$.order.items[0]"},
+	)
+
+	message := &util.Message{
+		Body:    `{"order": {"id": 42, "items": [{"sku": "ABC-1"}]}}`,
+		Headers: map[string]string{"X-Request-Id": "req-1"},
+		Path:    "/orders/42",
+		Query:   map[string]string{"page": "3"},
+	}
+
+	result, err := template.ProcessMessage(message)
+	require.NoError(t, err)
+	require.Equal(t, `42|ABC-1|ABC|req-1|3|42|{"sku":"ABC-1"}|${7}`, result.Body)
+}
+
+func TestXPathExtractor(t *testing.T) {
+	template := createTemplate(t, "<id>${0}</id>",
+		&Extractor{Name: "0", ExtractorType: XPathExtractorType, Expression: "//order/id"})
+
+	result, err := template.ProcessMessage(&util.Message{Body: "<order><id>7</id></order>"})
+	require.NoError(t, err)
+	require.Equal(t, "<id>7</id>", result.Body)
+}
+
+func TestNamedPlaceholdersWithDefaultsAndEscaping(t *testing.T) {
+	template := createTemplate(t, `{"id": "${orderId}", "status": "${status:-UNKNOWN}", "empty": "${note:-}", "raw": "$${orderId}"}`,
+		&Extractor{Name: "orderId", ExtractorType: GsonExtractorType, Expression: "id"})
+
+	result, err := template.ProcessMessage(&util.Message{Body: `{"id": "A-1"}`})
+	require.NoError(t, err)
+	require.Equal(t, `{"id": "A-1", "status": "UNKNOWN", "empty": "", "raw": "${orderId}"}`, result.Body)
+}
+
+func TestStrictTemplate(t *testing.T) {
+	template := createTemplate(t, "${orderId} ${missing}",
+		&Extractor{Name: "orderId", ExtractorType: GsonExtractorType, Expression: "id"})
+
+	result, err := template.ProcessMessage(&util.Message{Body: `{"id": 1}`})
+	require.NoError(t, err)
+	require.Equal(t, "1 ${missing}", result.Body)
+
+	template.Strict = true
+	_, err = template.ProcessMessage(&util.Message{Body: `{"id": 1}`})
+	require.IsType(t, &TemplateValidationException{}, err)
+}
+
+func TestPrepareInvalidExtractor(t *testing.T) {
+	require.Error(t, (&Extractor{ExtractorType: GsonExtractorType, Name: "order id"}).validate())
+	require.Error(t, (&Extractor{ExtractorType: "unknown"}).prepare())
+	require.Error(t, (&Extractor{ExtractorType: RegexExtractorType, Expression: "("}).prepare())
+	require.Error(t, (&Extractor{ExtractorType: PathSegmentExtractorType, Expression: "first"}).prepare())
+}
+
+func TestTemplateStatusCodeAndHeaders(t *testing.T) {
+	template := createTemplate(t, "{}",
+		&Extractor{Name: "requestId", ExtractorType: HeaderExtractorType, Expression: "X-Request-Id"})
+	template.StatusCode = 503
+	template.Headers = map[string]string{"Content-Type": "application/json", "X-Request-Id": "${requestId}"}
+
+	result, err := template.ProcessMessage(&util.Message{Headers: map[string]string{"X-Request-Id": "req-1"}})
+	require.NoError(t, err)
+	require.Equal(t, 503, result.StatusCode)
+	require.Equal(t, map[string]string{"Content-Type": "application/json", "X-Request-Id": "req-1"}, result.Headers)
+
+	template.StatusCode = 1000
+	require.Error(t, template.validate())
+}
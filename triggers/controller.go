@@ -5,24 +5,64 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
 	"strconv"
+	"time"
+	"unimock/journal"
+	"unimock/proxy"
 	"unimock/util"
 )
 
+const defaultNearMissLimit = 3
+
+// maxRecordedNearMisses - число ближайших триггеров, сохраняемых в журнале для сообщения без триггера
+const maxRecordedNearMisses = 10
+
 type TriggerHandler struct {
 	triggerService *TriggerService
+	journal        *journal.Journal
+	// nearMissLimit - количество ближайших триггеров в ответе 404, 0 отключает диагностику в ответе
+	nearMissLimit int
+	// proxy - если задан, сообщения без триггера перенаправляются вышестоящему сервису
+	proxy *proxy.Proxy
+	// recorder - если задан, ответы вышестоящего сервиса сохраняются как триггеры
+	recorder *Recorder
+	// responseHooks запускают асинхронные callback после записи ответа, без них callback запускаются при
+	// возврате из обработчика
+	responseHooks *util.ResponseHooks
+}
+
+type UnmatchedRequest struct {
+	*journal.Record
+	NearMisses []*NearMiss `json:"near_misses"`
 }
 
-func NewHandler(service *TriggerService) *TriggerHandler {
+func NewHandler(service *TriggerService, requestJournal *journal.Journal, nearMissLimit int) *TriggerHandler {
 	return &TriggerHandler{
 		triggerService: service,
+		journal:        requestJournal,
+		nearMissLimit:  nearMissLimit,
 	}
 }
 
+// SetProxy включает режим прокси для сообщений без триггера, recorder может быть nil
+func (handler *TriggerHandler) SetProxy(proxy *proxy.Proxy, recorder *Recorder) {
+	handler.proxy = proxy
+	handler.recorder = recorder
+}
+
+// SetResponseHooks задаёт hooks сервера, которые выполняют действия после записи ответа
+func (handler *TriggerHandler) SetResponseHooks(hooks *util.ResponseHooks) {
+	handler.responseHooks = hooks
+}
+
 func (handler *TriggerHandler) GetTriggers(context *fiber.Ctx) error {
 
 	return context.JSON(handler.triggerService.GetTriggers())
 }
 
+func (handler *TriggerHandler) GetTriggersInEvaluationOrder(context *fiber.Ctx) error {
+	return context.JSON(handler.triggerService.GetTriggersInEvaluationOrder())
+}
+
 func (handler *TriggerHandler) GetTriggerById(context *fiber.Ctx) error {
 	id, err := strconv.ParseInt(context.Params("id"), 10, 64)
 	if err != nil {
@@ -43,7 +83,10 @@ func (handler *TriggerHandler) AddTrigger(context *fiber.Ctx) error {
 		return &TriggerValidationException{message: err.Error()}
 	}
 	trigger := CreateTriggerFromBaseTrigger(baseTrigger)
-	if err := handler.triggerService.AddTrigger(trigger); err != nil {
+	if trigger == nil {
+		return &TriggerValidationException{message: "Неизвестный тип триггера"}
+	}
+	if err := handler.triggerService.AddTrigger(trigger, util.GetAuthor(context)); err != nil {
 		return err
 	}
 	return context.JSON(trigger)
@@ -59,8 +102,11 @@ func (handler *TriggerHandler) UpdateTrigger(context *fiber.Ctx) error {
 		return util.CreateParamValidationException("id", err)
 	}
 	trigger := CreateTriggerFromBaseTrigger(baseTrigger)
+	if trigger == nil {
+		return &TriggerValidationException{message: "Неизвестный тип триггера"}
+	}
 	trigger.setId(id)
-	if err := handler.triggerService.UpdateTrigger(trigger); err != nil {
+	if err := handler.triggerService.UpdateTrigger(trigger, util.GetAuthor(context)); err != nil {
 		return err
 	}
 	return nil
@@ -72,28 +118,145 @@ func (handler *TriggerHandler) DeleteTrigger(context *fiber.Ctx) error {
 		return util.CreateParamValidationException("id", err)
 	}
 
-	if err := handler.triggerService.DeleteTrigger(id); err != nil {
+	if err := handler.triggerService.DeleteTrigger(id, util.GetAuthor(context)); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (handler *TriggerHandler) ProcessMessage(context *fiber.Ctx) error {
-	inputMessage := util.Message{
-		Body:    string(context.Body()),
-		Headers: context.GetReqHeaders(),
-	}
+	inputMessage := util.CreateMessageFromRequest(context, "*")
 
 	log.Debug().Any("headers", inputMessage.Headers).Str("body", inputMessage.Body).Msg("Получено сообщение")
 
-	outputMessage, err := handler.triggerService.ProcessMessage(&inputMessage)
+	record := &journal.Record{
+		Timestamp: time.Now(),
+		TriggerId: journal.UnmatchedTriggerId,
+		Request:   inputMessage,
+	}
+	defer func() {
+		record.DurationMs = float64(time.Since(record.Timestamp).Microseconds()) / 1000
+		handler.journal.Record(record)
+	}()
+
+	var outputMessage *util.Message
+	trigger, err := handler.triggerService.FindTrigger(inputMessage)
+	if err == nil {
+		record.TriggerId = trigger.getId()
+		record.Subsystem = trigger.getSubsystem()
+		requestCtx, stop := util.WatchDisconnect(context)
+		requestCtx, afterResponse := util.WithAfterResponse(requestCtx)
+		// Ответ записывается в соединение после возврата из обработчика, поэтому отложенные действия,
+		// например асинхронные callback, выполняются hooks сервера после записи
+		defer handler.responseHooks.After(context, afterResponse)
+		outputMessage, err = handler.triggerService.ProcessTriggerMessage(requestCtx, trigger, inputMessage)
+		stop()
+	} else if notFound, ok := err.(*TriggerNotFoundException); ok {
+		// Ближайшие триггеры сохраняются в журнале, чтобы диагностика не зависела от последующих изменений триггеров
+		nearMisses := handler.triggerService.FindNearMisses(inputMessage, maxRecordedNearMisses)
+		if encoded, marshalErr := json.Marshal(nearMisses); marshalErr == nil {
+			record.NearMisses = encoded
+		} else {
+			log.Error().Err(marshalErr).Msg("Ошибка сохранения ближайших триггеров в журнале")
+		}
+		if handler.proxy != nil {
+			record.Proxied = true
+			record.Subsystem = handler.proxy.Subsystem(inputMessage)
+			outputMessage, err = handler.proxyMessage(inputMessage, record.Subsystem)
+		} else if handler.nearMissLimit > 0 {
+			notFound.nearMisses = limitNearMisses(nearMisses, handler.nearMissLimit)
+		}
+	}
+
 	if err != nil {
+		record.Error = err.Error()
 		return err
 	}
+	record.Response = outputMessage
+
+	if outputMessage.StatusCode != 0 {
+		context.Status(outputMessage.StatusCode)
+	}
 
 	for key, value := range outputMessage.Headers {
-		context.Append(key, value)
+		context.Set(key, value)
+	}
+
+	if outputMessage.Fault != nil {
+		return util.SendFault(context, outputMessage)
+	}
+	return util.SendMessageBody(context, outputMessage)
+}
+
+func (handler *TriggerHandler) proxyMessage(inputMessage *util.Message, subsystem string) (*util.Message, error) {
+	outputMessage, err := handler.proxy.Forward(inputMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	if handler.recorder != nil {
+		if err := handler.recorder.Record(inputMessage, outputMessage, subsystem); err != nil {
+			log.Warn().Err(err).Msg("Не удалось записать ответ вышестоящего сервиса")
+		}
+	}
+	return outputMessage, nil
+}
+
+func (handler *TriggerHandler) VerifyRequests(context *fiber.Ctx) error {
+	request := new(VerificationRequest)
+	if err := json.Unmarshal(context.Body(), request); err != nil {
+		return &TriggerValidationException{message: err.Error()}
+	}
+	filter, err := journal.ParseFilter(context)
+	if err != nil {
+		return err
+	}
+
+	result, err := Verify(handler.journal, filter, request)
+	if err != nil {
+		return err
+	}
+
+	if !result.Verified {
+		context.Status(fiber.StatusExpectationFailed)
 	}
+	return context.JSON(result)
+}
 
-	return context.SendString(outputMessage.Body)
+// limitNearMisses возвращает первые limit ближайших триггеров, отрицательный limit не ограничивает список
+func limitNearMisses(nearMisses []*NearMiss, limit int) []*NearMiss {
+	if limit >= 0 && len(nearMisses) > limit {
+		return nearMisses[:limit]
+	}
+	return nearMisses
+}
+
+func (handler *TriggerHandler) GetUnmatchedRequests(context *fiber.Ctx) error {
+	filter, err := journal.ParseFilter(context)
+	if err != nil {
+		return err
+	}
+	unmatchedTriggerId := journal.UnmatchedTriggerId
+	filter.TriggerId = &unmatchedTriggerId
+
+	top, err := strconv.Atoi(context.Query("top", strconv.Itoa(defaultNearMissLimit)))
+	if err != nil {
+		return util.CreateParamValidationException("top", err)
+	}
+
+	records := handler.journal.Find(filter)
+	result := make([]*UnmatchedRequest, 0, len(records))
+	for _, record := range records {
+		nearMisses := make([]*NearMiss, 0)
+		if len(record.NearMisses) > 0 {
+			if err = json.Unmarshal(record.NearMisses, &nearMisses); err != nil {
+				return err
+			}
+		}
+		result = append(result, &UnmatchedRequest{
+			Record:     record,
+			NearMisses: limitNearMisses(nearMisses, top),
+		})
+	}
+	return context.JSON(result)
 }
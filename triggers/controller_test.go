@@ -0,0 +1,143 @@
+package triggers
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unimock/journal"
+	"unimock/scenarios"
+	"unimock/templates"
+	"unimock/util"
+)
+
+// eventLog - порядок событий на стороне сервера и получателя callback
+type eventLog struct {
+	mut    sync.Mutex
+	events []string
+}
+
+func (log *eventLog) add(event string) {
+	log.mut.Lock()
+	log.events = append(log.events, event)
+	log.mut.Unlock()
+}
+
+// recordingListener отмечает в журнале событий завершение записи ответа в соединение
+type recordingListener struct {
+	net.Listener
+	events *eventLog
+}
+
+func (listener *recordingListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{Conn: conn, events: listener.events}, nil
+}
+
+type recordingConn struct {
+	net.Conn
+	events *eventLog
+}
+
+// Write задерживает запись, чтобы callback, отправленный до записи ответа, успел дойти до получателя
+func (conn *recordingConn) Write(data []byte) (int, error) {
+	time.Sleep(100 * time.Millisecond)
+	n, err := conn.Conn.Write(data)
+	conn.events.add("response")
+	return n, err
+}
+
+func TestAsyncCallbackAfterResponse(t *testing.T) {
+	events := new(eventLog)
+	received := make(chan struct{}, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events.add("callback")
+		received <- struct{}{}
+	}))
+	defer target.Close()
+
+	service, scenarioService, templateService := newTestServices(t)
+	trigger := CreateTriggerFromBaseTrigger(&Trigger{TriggerType: Regex, Expression: "order", IsActive: true})
+	require.NoError(t, service.AddTrigger(trigger, ""))
+	response := &templates.Template{Name: "response", Body: "mocked"}
+	require.NoError(t, templateService.AddTemplate(response, ""))
+	notification := &templates.Template{Name: "notification", Body: "created"}
+	require.NoError(t, templateService.AddTemplate(notification, ""))
+	_, err := scenarioService.UpdateStepsForTrigger(scenarios.Steps{
+		{Id: -1, OrderNumber: 1, TriggerId: trigger.getId(), StepType: scenarios.TemplateProcessing,
+			Config: scenarios.CreateStepConfig(&scenarios.TemplateStepConfig{TemplateId: response.Id})},
+		{Id: -1, OrderNumber: 2, TriggerId: trigger.getId(), StepType: scenarios.Callback,
+			Config: scenarios.CreateStepConfig(&scenarios.CallbackStepConfig{TemplateId: notification.Id,
+				Url: target.URL, Async: true})},
+	}, trigger.getId(), "")
+	require.NoError(t, err)
+
+	app := fiber.New()
+	hooks := util.NewResponseHooks()
+	hooks.Install(app.Server())
+	handler := NewHandler(service, journal.NewJournal(10, nil), 0)
+	handler.SetResponseHooks(hooks)
+	app.All("/process*", handler.ProcessMessage)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = app.Listener(&recordingListener{Listener: listener, events: events}) }()
+	defer func() { _ = app.Shutdown() }()
+
+	result, err := http.Post("http://"+listener.Addr().String()+"/process", "text/plain", strings.NewReader("order"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+	require.NoError(t, result.Body.Close())
+	require.Equal(t, "mocked", string(body))
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		require.Fail(t, "Асинхронный callback не отправлен")
+	}
+	events.mut.Lock()
+	defer events.mut.Unlock()
+	require.Equal(t, []string{"response", "callback"}, events.events, "Callback отправляется после записи ответа")
+}
+
+func TestUnmatchedRequestsKeepNearMisses(t *testing.T) {
+	service := newTestService(t)
+	trigger := CreateTriggerFromBaseTrigger(&Trigger{TriggerType: Regex, Expression: "order", Method: "POST",
+		IsActive: true})
+	require.NoError(t, service.AddTrigger(trigger, ""))
+
+	app := fiber.New()
+	handler := NewHandler(service, journal.NewJournal(10, nil), 1)
+	app.All("/process*", handler.ProcessMessage)
+	app.Get("/unmatched", handler.GetUnmatchedRequests)
+
+	_, err := app.Test(httptest.NewRequest("PUT", "/process", strings.NewReader("order")))
+	require.NoError(t, err)
+
+	trigger.base().Method = "PUT"
+	require.NoError(t, service.UpdateTrigger(trigger, ""))
+
+	result, err := app.Test(httptest.NewRequest("GET", "/unmatched?top=1", nil))
+	require.NoError(t, err)
+	var unmatched []*UnmatchedRequest
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&unmatched))
+	require.Len(t, unmatched, 1)
+	require.Len(t, unmatched[0].NearMisses, 1, "Ближайшие триггеры сохранены на момент получения сообщения")
+	require.Equal(t, trigger.getId(), unmatched[0].NearMisses[0].TriggerId)
+	require.Equal(t, MethodPart, unmatched[0].NearMisses[0].Mismatches[0].Part)
+
+	result, err = app.Test(httptest.NewRequest("GET", "/unmatched?top=0", nil))
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&unmatched))
+	require.Empty(t, unmatched[0].NearMisses)
+}
@@ -0,0 +1,196 @@
+package triggers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/antchfx/xmlquery"
+	"github.com/tidwall/gjson"
+	"sort"
+	"strings"
+	"unimock/util"
+)
+
+type MismatchPart string
+
+const (
+	ActivePart MismatchPart = "active"
+	MethodPart MismatchPart = "method"
+	PathPart   MismatchPart = "path"
+	QueryPart  MismatchPart = "query"
+	HeaderPart MismatchPart = "header"
+	BodyPart   MismatchPart = "body"
+	StatePart  MismatchPart = "state"
+)
+
+// Mismatch - несработавшая проверка триггера
+type Mismatch struct {
+	Part     MismatchPart `json:"part"`
+	Name     string       `json:"name,omitempty"`
+	Expected string       `json:"expected,omitempty"`
+	Actual   string       `json:"actual,omitempty"`
+	Reason   string       `json:"reason"`
+}
+
+// NearMiss - триггер, не сработавший на сообщение, с перечнем несработавших проверок
+type NearMiss struct {
+	TriggerId   int64       `json:"trigger_id"`
+	Description string      `json:"description"`
+	Mismatches  []*Mismatch `json:"mismatches"`
+	checks      int
+}
+
+func (trigger *Trigger) diagnose(message *util.Message) ([]*Mismatch, int) {
+	mismatches := make([]*Mismatch, 0)
+	checks := 1
+
+	if !trigger.IsActive {
+		mismatches = append(mismatches, &Mismatch{Part: ActivePart, Reason: "триггер не активен"})
+	}
+
+	if trigger.Method != "" {
+		checks++
+		if !strings.EqualFold(trigger.Method, message.Method) {
+			mismatches = append(mismatches, &Mismatch{Part: MethodPart, Expected: trigger.Method, Actual: message.Method,
+				Reason: "метод отличается"})
+		}
+	}
+
+	if trigger.Path != "" {
+		checks++
+		if !matchPath(trigger.Path, message.Path) {
+			mismatches = append(mismatches, &Mismatch{Part: PathPart, Expected: trigger.Path, Actual: message.Path,
+				Reason: "путь не соответствует шаблону"})
+		}
+	}
+
+	for _, key := range sortedKeys(trigger.Query) {
+		checks++
+		valueTrigger := trigger.Query[key]
+		valueMessage, ok := message.Query[key]
+		if !ok {
+			mismatches = append(mismatches, &Mismatch{Part: QueryPart, Name: key, Expected: valueTrigger,
+				Reason: "параметр отсутствует"})
+		} else if valueTrigger != "" && valueTrigger != valueMessage {
+			mismatches = append(mismatches, &Mismatch{Part: QueryPart, Name: key, Expected: valueTrigger,
+				Actual: valueMessage, Reason: "значение параметра отличается"})
+		}
+	}
+
+	for _, key := range sortedKeys(trigger.Headers) {
+		checks++
+		valueTrigger := trigger.Headers[key]
+		valueMessage, ok := message.Headers[key]
+		if !ok {
+			mismatches = append(mismatches, &Mismatch{Part: HeaderPart, Name: key, Expected: valueTrigger,
+				Reason: "заголовок отсутствует"})
+		} else if !headerContainsValue(valueMessage, valueTrigger) {
+			mismatches = append(mismatches, &Mismatch{Part: HeaderPart, Name: key, Expected: valueTrigger,
+				Actual: valueMessage, Reason: "значение заголовка отличается"})
+		}
+	}
+
+	return mismatches, checks
+}
+
+func (trigger *RegexTrigger) diagnose(message *util.Message) ([]*Mismatch, int) {
+	mismatches, checks := trigger.Trigger.diagnose(message)
+	if !trigger.expressionRegexp.MatchString(message.Body) {
+		mismatches = append(mismatches, &Mismatch{Part: BodyPart, Expected: trigger.Expression,
+			Reason: "регулярное выражение не совпало"})
+	}
+	return mismatches, checks + 1
+}
+
+func (trigger *GsonTrigger) diagnose(message *util.Message) ([]*Mismatch, int) {
+	mismatches, checks := trigger.Trigger.diagnose(message)
+	if !gjson.Valid(message.Body) {
+		mismatches = append(mismatches, &Mismatch{Part: BodyPart, Expected: trigger.Expression,
+			Reason: "тело не является корректным JSON"})
+	} else if !gjson.Get(message.Body, trigger.Expression).Exists() {
+		mismatches = append(mismatches, &Mismatch{Part: BodyPart, Expected: trigger.Expression,
+			Reason: "путь не найден в теле"})
+	}
+	return mismatches, checks + 1
+}
+
+func (trigger *JsonPathTrigger) diagnose(message *util.Message) ([]*Mismatch, int) {
+	mismatches, checks := trigger.Trigger.diagnose(message)
+
+	var messageBody interface{}
+	if err := json.Unmarshal([]byte(message.Body), &messageBody); err != nil {
+		mismatches = append(mismatches, &Mismatch{Part: BodyPart, Expected: trigger.Expression, Actual: err.Error(),
+			Reason: "тело не является корректным JSON"})
+		return mismatches, checks + 1
+	}
+
+	result, err := trigger.eval(context.Background(), messageBody)
+	if err != nil {
+		mismatches = append(mismatches, &Mismatch{Part: BodyPart, Expected: trigger.Expression, Actual: err.Error(),
+			Reason: "ошибка вычисления выражения"})
+	} else if resultBool, ok := result.(bool); !ok || !resultBool {
+		mismatches = append(mismatches, &Mismatch{Part: BodyPart, Expected: trigger.Expression,
+			Actual: fmt.Sprintf("%v", result), Reason: "выражение не вернуло true"})
+	}
+	return mismatches, checks + 1
+}
+
+func (trigger *XmlPathTrigger) diagnose(message *util.Message) ([]*Mismatch, int) {
+	mismatches, checks := trigger.Trigger.diagnose(message)
+
+	messageBody, err := xmlquery.Parse(strings.NewReader(message.Body))
+	if err != nil {
+		mismatches = append(mismatches, &Mismatch{Part: BodyPart, Expected: trigger.Expression, Actual: err.Error(),
+			Reason: "тело не является корректным XML"})
+	} else if xmlquery.QuerySelector(messageBody, trigger.expression) == nil {
+		mismatches = append(mismatches, &Mismatch{Part: BodyPart, Expected: trigger.Expression,
+			Reason: "узел не найден в теле"})
+	}
+	return mismatches, checks + 1
+}
+
+// findNearMisses возвращает до limit триггеров, ближе всего подошедших к сообщению: сначала с меньшим числом
+// несработавших проверок, затем с большим числом проверок, затем в порядке проверки триггеров
+func findNearMisses(orderedTriggers []TriggerInterface, message *util.Message, limit int,
+	diagnoseState func(trigger TriggerInterface, message *util.Message) *Mismatch) []*NearMiss {
+	nearMisses := make([]*NearMiss, 0, len(orderedTriggers))
+	for _, trigger := range orderedTriggers {
+		mismatches, checks := trigger.diagnose(message)
+		if trigger.getStateMachine() != "" {
+			checks++
+			if mismatch := diagnoseState(trigger, message); mismatch != nil {
+				mismatches = append(mismatches, mismatch)
+			}
+		}
+		if len(mismatches) == 0 {
+			continue
+		}
+		nearMisses = append(nearMisses, &NearMiss{
+			TriggerId:   trigger.getId(),
+			Description: trigger.getDescription(),
+			Mismatches:  mismatches,
+			checks:      checks,
+		})
+	}
+
+	sort.SliceStable(nearMisses, func(i, j int) bool {
+		if len(nearMisses[i].Mismatches) != len(nearMisses[j].Mismatches) {
+			return len(nearMisses[i].Mismatches) < len(nearMisses[j].Mismatches)
+		}
+		return nearMisses[i].checks > nearMisses[j].checks
+	})
+
+	if limit >= 0 && len(nearMisses) > limit {
+		nearMisses = nearMisses[:limit]
+	}
+	return nearMisses
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,43 @@
+package triggers
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unimock/util"
+)
+
+func prepareTrigger(t *testing.T, baseTrigger *Trigger) TriggerInterface {
+	trigger := CreateTriggerFromBaseTrigger(baseTrigger)
+	require.NoError(t, trigger.prepare())
+	return trigger
+}
+
+func TestFindNearMisses(t *testing.T) {
+	triggers := []TriggerInterface{
+		prepareTrigger(t, &Trigger{Id: 1, TriggerType: Gson, Expression: "order", IsActive: true,
+			Headers: map[string]string{"X-System": "crm"}}),
+		prepareTrigger(t, &Trigger{Id: 2, TriggerType: Gson, Expression: "payment", IsActive: true}),
+		prepareTrigger(t, &Trigger{Id: 3, TriggerType: XPath, Expression: "//order", IsActive: false}),
+	}
+	message := &util.Message{
+		Body:    `{"order": 1}`,
+		Headers: map[string]string{"Content-Type": "application/json; charset=utf-8", "X-System": "billing"},
+	}
+
+	nearMisses := findNearMisses(triggers, message, 2, nil)
+	require.Len(t, nearMisses, 2)
+
+	require.Equal(t, int64(1), nearMisses[0].TriggerId)
+	require.Equal(t, []*Mismatch{{Part: HeaderPart, Name: "X-System", Expected: "crm", Actual: "billing",
+		Reason: "значение заголовка отличается"}}, nearMisses[0].Mismatches)
+
+	require.Equal(t, int64(2), nearMisses[1].TriggerId)
+	require.Equal(t, BodyPart, nearMisses[1].Mismatches[0].Part)
+
+	nearMisses = findNearMisses(triggers, &util.Message{Body: "{", Headers: map[string]string{}}, 5, nil)
+	require.Len(t, nearMisses, 3)
+	for _, nearMiss := range nearMisses {
+		require.Equal(t, BodyPart, nearMiss.Mismatches[len(nearMiss.Mismatches)-1].Part)
+	}
+	require.Equal(t, "тело не является корректным JSON", nearMisses[0].Mismatches[1].Reason)
+}
@@ -9,9 +9,15 @@ func (e *TriggerValidationException) Error() string {
 }
 
 type TriggerNotFoundException struct {
-	message string
+	message    string
+	nearMisses []*NearMiss
 }
 
 func (e *TriggerNotFoundException) Error() string {
 	return e.message
 }
+
+// NearMisses - ближайшие несработавшие триггеры, если диагностика включена
+func (e *TriggerNotFoundException) NearMisses() []*NearMiss {
+	return e.nearMisses
+}
@@ -0,0 +1,189 @@
+package triggers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/PaesslerAG/gval"
+	"github.com/PaesslerAG/jsonpath"
+	"github.com/rs/zerolog/log"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+	"unimock/scenarios"
+	"unimock/templates"
+	"unimock/util"
+)
+
+// recordedResponseHeaders не сохраняются в шаблон записанного ответа
+var recordedResponseHeaders = map[string]bool{
+	"Date":           true,
+	"Content-Length": true,
+}
+
+// textContentTypes - типы содержимого, кроме text/*, тело которых записывается как текст
+var textContentTypes = []string{"json", "xml", "javascript", "x-www-form-urlencoded"}
+
+// recorderAuthor - автор изменений, которые записывает Recorder, в истории версий
+const recorderAuthor = "proxy-recorder"
+
+// Recorder сохраняет пару запрос-ответ, полученную через прокси, как неактивный триггер, шаблон ответа
+// и шаг сценария template_processing. Выражение триггера строится по значению ключа keyExpression в запросе
+type Recorder struct {
+	triggerService  *TriggerService
+	scenarioService *scenarios.ScenarioService
+	templateService *templates.TemplateService
+	keyType         TriggerType
+	keyExpression   string
+	keyRegexp       *regexp.Regexp
+	keyEval         gval.Evaluable
+}
+
+func NewRecorder(triggerService *TriggerService, scenarioService *scenarios.ScenarioService,
+	templateService *templates.TemplateService, keyType TriggerType, keyExpression string) (*Recorder, error) {
+	recorder := &Recorder{
+		triggerService:  triggerService,
+		scenarioService: scenarioService,
+		templateService: templateService,
+		keyType:         keyType,
+		keyExpression:   keyExpression,
+	}
+
+	var err error
+	switch keyType {
+	case Regex:
+		recorder.keyRegexp, err = regexp.Compile(dotAllRegexMod + keyExpression)
+	case JsonPath:
+		recorder.keyEval, err = jsonpath.New(keyExpression)
+	default:
+		return nil, &TriggerValidationException{message: fmt.Sprintf("Тип ключа записи %s не поддерживается", keyType)}
+	}
+	if err != nil {
+		return nil, &TriggerValidationException{message: err.Error()}
+	}
+	return recorder, nil
+}
+
+func (recorder *Recorder) buildExpression(message *util.Message) (string, error) {
+	switch recorder.keyType {
+	case Regex:
+		match := recorder.keyRegexp.FindString(message.Body)
+		if match == "" {
+			return "", fmt.Errorf("ключ %s не найден в запросе", recorder.keyExpression)
+		}
+		return regexp.QuoteMeta(match), nil
+	default:
+		var messageBody interface{}
+		if err := json.Unmarshal([]byte(message.Body), &messageBody); err != nil {
+			return "", err
+		}
+		value, err := recorder.keyEval(context.Background(), messageBody)
+		if err != nil {
+			return "", err
+		}
+		literal, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s == %s", recorder.keyExpression, literal), nil
+	}
+}
+
+// Record сохраняет ответ response на запрос request подсистемы subsystem
+func (recorder *Recorder) Record(request *util.Message, response *util.Message, subsystem string) error {
+	expression, err := recorder.buildExpression(request)
+	if err != nil {
+		return err
+	}
+
+	trigger := CreateTriggerFromBaseTrigger(&Trigger{
+		TriggerType: recorder.keyType,
+		Expression:  expression,
+		Description: fmt.Sprintf("Записано прокси: %s %s", request.Method, request.Path),
+		Method:      request.Method,
+		Path:        request.Path,
+		Subsystem:   subsystem,
+	})
+	if err = recorder.triggerService.AddTrigger(trigger, recorderAuthor); err != nil {
+		return err
+	}
+
+	headers := make(map[string]string, len(response.Headers))
+	for key, value := range response.Headers {
+		if !recordedResponseHeaders[key] {
+			headers[key] = value
+		}
+	}
+
+	template := &templates.Template{
+		Name:       fmt.Sprintf("proxy-%d", trigger.getId()),
+		Subsystem:  subsystem,
+		StatusCode: response.StatusCode,
+		Headers:    headers,
+	}
+	// Двоичное тело сохраняется без изменений, в текстовом теле экранируются плейсхолдеры
+	if isTextBody(response) {
+		template.Body = templates.EscapeBody(response.Body)
+	} else {
+		template.BinaryBody = []byte(response.Body)
+	}
+	if err = recorder.templateService.AddTemplate(template, recorderAuthor); err != nil {
+		return recorder.rollback(err, trigger.getId(), 0)
+	}
+
+	err = recorder.scenarioService.AddStep(&scenarios.ScenarioStep{
+		OrderNumber: 1,
+		TriggerId:   trigger.getId(),
+		StepType:    scenarios.TemplateProcessing,
+		Config:      scenarios.CreateStepConfig(&scenarios.TemplateStepConfig{TemplateId: template.Id}),
+	}, recorderAuthor)
+	if err != nil {
+		return recorder.rollback(err, trigger.getId(), template.Id)
+	}
+
+	log.Info().Int64("triggerId", trigger.getId()).Int64("templateId", template.Id).
+		Msg("Записан ответ вышестоящего сервиса")
+	return nil
+}
+
+// isTextBody - тело ответа является текстом: корректная строка UTF-8 с текстовым типом содержимого
+// или без типа содержимого
+func isTextBody(response *util.Message) bool {
+	if !utf8.ValidString(response.Body) {
+		return false
+	}
+	for key, value := range response.Headers {
+		if !strings.EqualFold(key, "Content-Type") {
+			continue
+		}
+		mediaType, _, err := mime.ParseMediaType(value)
+		if err != nil {
+			return false
+		}
+		if strings.HasPrefix(mediaType, "text/") {
+			return true
+		}
+		for _, textType := range textContentTypes {
+			if strings.HasSuffix(mediaType, textType) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// rollback удаляет частично записанные триггер и шаблон, чтобы повторная запись того же ответа не упиралась
+// в уникальный индекс триггеров. Шаги удаляются вместе с триггером. Возвращает исходную ошибку err
+func (recorder *Recorder) rollback(err error, triggerId int64, templateId int64) error {
+	if deleteErr := recorder.triggerService.DeleteTrigger(triggerId, recorderAuthor); deleteErr != nil {
+		log.Error().Err(deleteErr).Int64("triggerId", triggerId).Msg("Не удалось удалить записанный триггер")
+	}
+	if templateId != 0 {
+		if deleteErr := recorder.templateService.DeleteTemplate(templateId, recorderAuthor); deleteErr != nil {
+			log.Error().Err(deleteErr).Int64("templateId", templateId).Msg("Не удалось удалить записанный шаблон")
+		}
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package triggers
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unimock/templates"
+	"unimock/util"
+)
+
+func TestRecorderCreatesTriggerTemplateAndStep(t *testing.T) {
+	triggerService, scenarioService, templateService := newTestServices(t)
+	recorder, err := NewRecorder(triggerService, scenarioService, templateService, JsonPath, "$.id")
+	require.NoError(t, err)
+
+	request := &util.Message{
+		Body:    `{"id": "A-1"}`,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Method:  "POST",
+		Path:    "/orders",
+	}
+	response := &util.Message{
+		Body:       `{"status": "${raw}"}`,
+		Headers:    map[string]string{"Content-Type": "application/json", "Date": "today"},
+		StatusCode: 201,
+	}
+	require.NoError(t, recorder.Record(request, response, "crm"))
+
+	recorded := triggerService.GetTriggers()
+	require.Len(t, recorded, 1)
+	require.Equal(t, `$.id == "A-1"`, recorded[0].getExpression())
+	require.False(t, recorded[0].getIsActive())
+	require.False(t, recorded[0].TriggerOnMessage(request))
+
+	steps := scenarioService.GetOrderedStepsByTriggerId(recorded[0].getId())
+	require.Len(t, steps, 1)
+
+	output, err := scenarioService.ProcessMessage(context.Background(), request, recorded[0].getId())
+	require.NoError(t, err)
+	require.Equal(t, response.Body, output.Body)
+	require.Equal(t, 201, output.StatusCode)
+	require.Equal(t, map[string]string{"Content-Type": "application/json"}, output.Headers)
+
+	require.Error(t, recorder.Record(request, response, "crm"), "Повторная запись того же запроса нарушает уникальность триггера")
+}
+
+func TestRecorderRemovesTriggerOnFailure(t *testing.T) {
+	triggerService, scenarioService, templateService := newTestServices(t)
+	recorder, err := NewRecorder(triggerService, scenarioService, templateService, Regex, "A-\\d+")
+	require.NoError(t, err)
+
+	occupied := &templates.Template{Name: "proxy-1", Body: "occupied"}
+	require.NoError(t, templateService.AddTemplate(occupied, ""))
+
+	request := &util.Message{Body: "order A-1", Method: "POST", Path: "/orders"}
+	response := &util.Message{Body: "ok", StatusCode: 200}
+	require.Error(t, recorder.Record(request, response, "crm"))
+	require.Empty(t, triggerService.GetTriggers())
+
+	require.NoError(t, templateService.DeleteTemplate(occupied.Id, ""))
+	require.NoError(t, recorder.Record(request, response, "crm"))
+	require.Len(t, triggerService.GetTriggers(), 1)
+}
+
+func TestRecorderKeepsBinaryBody(t *testing.T) {
+	triggerService, scenarioService, templateService := newTestServices(t)
+	recorder, err := NewRecorder(triggerService, scenarioService, templateService, Regex, "A-\\d+")
+	require.NoError(t, err)
+
+	request := &util.Message{Body: "order A-1", Method: "GET", Path: "/statements"}
+	pdf := "%PDF-1.4\x00\xff${id}"
+	response := &util.Message{Body: pdf, Headers: map[string]string{"Content-Type": "application/pdf"},
+		StatusCode: 200}
+	require.NoError(t, recorder.Record(request, response, "crm"))
+
+	recorded := triggerService.GetTriggers()
+	require.Len(t, recorded, 1)
+	output, err := scenarioService.ProcessMessage(context.Background(), request, recorded[0].getId())
+	require.NoError(t, err)
+	require.Equal(t, pdf, output.Body, "Двоичное тело записывается без изменений")
+	require.Equal(t, "application/pdf", output.Headers["Content-Type"])
+
+	require.True(t, isTextBody(&util.Message{Body: "{}",
+		Headers: map[string]string{"content-type": "application/problem+json; charset=utf-8"}}))
+	require.True(t, isTextBody(&util.Message{Body: "plain"}))
+	require.False(t, isTextBody(&util.Message{Body: "\xff\xfe"}))
+	require.False(t, isTextBody(&util.Message{Body: "PK", Headers: map[string]string{"Content-Type": "application/zip"}}))
+}
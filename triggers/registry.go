@@ -0,0 +1,44 @@
+package triggers
+
+// triggerRegistry - снимок триггеров. Опубликованный снимок не изменяется, каждое изменение создаёт копию
+// и атомарно подменяет снимок в TriggerService
+type triggerRegistry struct {
+	triggers map[int64]TriggerInterface
+	// orderedTriggers - триггеры в порядке проверки при обработке сообщения
+	orderedTriggers []TriggerInterface
+}
+
+func newTriggerRegistry(triggers map[int64]TriggerInterface) *triggerRegistry {
+	orderedTriggers := make([]TriggerInterface, 0, len(triggers))
+	for _, trigger := range triggers {
+		orderedTriggers = append(orderedTriggers, trigger)
+	}
+	sortTriggers(orderedTriggers)
+
+	return &triggerRegistry{
+		triggers:        triggers,
+		orderedTriggers: orderedTriggers,
+	}
+}
+
+func (registry *triggerRegistry) copyTriggers() map[int64]TriggerInterface {
+	result := make(map[int64]TriggerInterface, len(registry.triggers))
+	for id, trigger := range registry.triggers {
+		result[id] = trigger
+	}
+	return result
+}
+
+// with возвращает новый снимок с добавленным или заменённым триггером
+func (registry *triggerRegistry) with(trigger TriggerInterface) *triggerRegistry {
+	triggers := registry.copyTriggers()
+	triggers[trigger.getId()] = trigger
+	return newTriggerRegistry(triggers)
+}
+
+// without возвращает новый снимок без триггера с указанным id
+func (registry *triggerRegistry) without(id int64) *triggerRegistry {
+	triggers := registry.copyTriggers()
+	delete(triggers, id)
+	return newTriggerRegistry(triggers)
+}
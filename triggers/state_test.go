@@ -0,0 +1,99 @@
+package triggers
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unimock/scenarios"
+	"unimock/states"
+	"unimock/templates"
+	"unimock/util"
+)
+
+func TestStatefulScenario(t *testing.T) {
+	service, scenarioService, templateService := newTestServices(t)
+	stateService := service.stateService
+	require.NoError(t, stateService.AddMachine(&states.Machine{
+		Name: "orders", KeyType: templates.PathSegmentExtractorType, KeyExpression: "1",
+	}))
+
+	addStatefulTrigger := func(state string, body string, nextState string) {
+		trigger := CreateTriggerFromBaseTrigger(&Trigger{
+			TriggerType: Regex, IsActive: true, Method: "GET", Path: "/orders/:id",
+			StateMachine: "orders", State: state,
+		})
+		require.NoError(t, service.AddTrigger(trigger, ""))
+		template := &templates.Template{Name: "order-" + state, Body: body}
+		require.NoError(t, templateService.AddTemplate(template, ""))
+		require.NoError(t, scenarioService.AddStep(&scenarios.ScenarioStep{
+			OrderNumber: 1, TriggerId: trigger.getId(), StepType: scenarios.TemplateProcessing,
+			Config: scenarios.CreateStepConfig(&scenarios.TemplateStepConfig{TemplateId: template.Id}),
+		}, ""))
+		require.NoError(t, scenarioService.AddStep(&scenarios.ScenarioStep{
+			OrderNumber: 2, TriggerId: trigger.getId(), StepType: scenarios.SetState,
+			Config: scenarios.CreateStepConfig(&scenarios.SetStateStepConfig{StateMachine: "orders", State: nextState}),
+		}, ""))
+	}
+	addStatefulTrigger(states.DefaultInitialState, "PENDING", "DONE")
+	addStatefulTrigger("DONE", "DONE", "DONE")
+
+	get := func(path string) string {
+		output, err := service.ProcessMessage(context.Background(), &util.Message{Method: "GET", Path: path})
+		require.NoError(t, err)
+		return output.Body
+	}
+	require.Equal(t, "PENDING", get("/orders/1"))
+	require.Equal(t, "DONE", get("/orders/1"))
+	require.Equal(t, "PENDING", get("/orders/2"), "Состояние хранится отдельно для каждого ключа")
+
+	currentStates, err := stateService.GetStates("orders")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"1": "DONE", "2": "DONE"}, currentStates)
+
+	require.NoError(t, stateService.ResetStates("orders", "1"))
+	require.Equal(t, "PENDING", get("/orders/1"))
+
+	require.NoError(t, stateService.PutState("orders", "3", "CANCELLED"))
+	_, err = service.ProcessMessage(context.Background(), &util.Message{Method: "GET", Path: "/orders/3"})
+	require.IsType(t, &TriggerNotFoundException{}, err)
+	nearMisses := service.FindNearMisses(&util.Message{Method: "GET", Path: "/orders/3"}, 1)
+	require.Len(t, nearMisses[0].Mismatches, 1)
+	require.Equal(t, StatePart, nearMisses[0].Mismatches[0].Part)
+	require.Equal(t, "CANCELLED", nearMisses[0].Mismatches[0].Actual)
+
+	require.Error(t, service.AddTrigger(CreateTriggerFromBaseTrigger(&Trigger{TriggerType: Regex, StateMachine: "orders"}), ""))
+	require.Error(t, scenarioService.AddStep(&scenarios.ScenarioStep{
+		TriggerId: 1, StepType: scenarios.SetState,
+		Config: scenarios.CreateStepConfig(&scenarios.SetStateStepConfig{StateMachine: "unknown", State: "DONE"}),
+	}, ""))
+}
+
+func TestMachineReferences(t *testing.T) {
+	service, scenarioService, _ := newTestServices(t)
+	stateService := service.stateService
+	machine := &states.Machine{Name: "orders", KeyType: templates.PathSegmentExtractorType, KeyExpression: "1"}
+	require.NoError(t, stateService.AddMachine(machine))
+
+	require.IsType(t, &TriggerValidationException{}, service.AddTrigger(CreateTriggerFromBaseTrigger(&Trigger{
+		TriggerType: Regex, IsActive: true, StateMachine: "unknown", State: "NEW"}), ""))
+
+	trigger := CreateTriggerFromBaseTrigger(&Trigger{TriggerType: Regex, IsActive: true, Path: "/orders/:id",
+		StateMachine: "orders", State: states.DefaultInitialState})
+	require.NoError(t, service.AddTrigger(trigger, ""))
+	require.NoError(t, scenarioService.AddStep(&scenarios.ScenarioStep{
+		OrderNumber: 1, TriggerId: trigger.getId(), StepType: scenarios.SetState,
+		Config: scenarios.CreateStepConfig(&scenarios.SetStateStepConfig{StateMachine: "orders", State: "DONE"}),
+	}, ""))
+
+	require.IsType(t, &states.MachineInUseException{}, stateService.DeleteMachine(machine.Id))
+	renamed := *machine
+	renamed.Name = "orders-v2"
+	require.IsType(t, &states.MachineInUseException{}, stateService.UpdateMachine(&renamed))
+	changed := *machine
+	changed.InitialState = "CREATED"
+	require.NoError(t, stateService.UpdateMachine(&changed), "Изменение без переименования разрешено")
+
+	require.NoError(t, service.DeleteTrigger(trigger.getId(), ""))
+	require.Empty(t, scenarioService.GetOrderedStepsByTriggerId(trigger.getId()))
+	require.NoError(t, stateService.DeleteMachine(machine.Id))
+}
@@ -3,14 +3,18 @@ package triggers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/PaesslerAG/gval"
 	"github.com/PaesslerAG/jsonpath"
 	"github.com/antchfx/xmlquery"
 	"github.com/antchfx/xpath"
 	"github.com/rs/zerolog/log"
 	"github.com/tidwall/gjson"
+	"path"
 	"regexp"
+	"sort"
 	"strings"
+	"unimock/scenarios"
 	"unimock/util"
 )
 
@@ -31,6 +35,7 @@ const dotAllRegexMod = "(?s)"
 
 type TriggerInterface interface {
 	validate() bool
+	base() *Trigger
 	getId() int64
 	setId(id int64)
 	getType() TriggerType
@@ -45,8 +50,21 @@ type TriggerInterface interface {
 	setHeaders(h map[string]string)
 	getSubsystem() string
 	setSubsystem(subsystem string)
+	getMethod() string
+	setMethod(method string)
+	getPath() string
+	setPath(path string)
+	getQuery() map[string]string
+	setQuery(query map[string]string)
+	getPriority() int
+	setPriority(priority int)
+	getStateMachine() string
+	setStateMachine(stateMachine string)
+	getState() string
+	setState(state string)
 	prepare() error
 	TriggerOnMessage(message *util.Message) bool
+	diagnose(message *util.Message) ([]*Mismatch, int)
 }
 
 type Trigger struct {
@@ -57,12 +75,26 @@ type Trigger struct {
 	IsActive    bool              `json:"is_active"`
 	Headers     map[string]string `json:"headers"`
 	Subsystem   string            `json:"subsystem"`
+	Method      string            `json:"method"`
+	Path        string            `json:"path"`
+	Query       map[string]string `json:"query"`
+	// Priority - порядок проверки триггера, триггеры с меньшим значением проверяются раньше
+	Priority int `json:"priority"`
+	// StateMachine и State - триггер срабатывает, только если ключ сообщения в автомате StateMachine
+	// находится в состоянии State
+	StateMachine string `json:"state_machine"`
+	State        string `json:"state"`
 }
 
 func (trigger *Trigger) validate() bool {
 	return trigger.TriggerType != ""
 }
 
+// base возвращает общие поля триггера, которые сохраняются в БД и версиях
+func (trigger *Trigger) base() *Trigger {
+	return trigger
+}
+
 func (trigger *Trigger) getId() int64 {
 	return trigger.Id
 }
@@ -119,6 +151,54 @@ func (trigger *Trigger) setSubsystem(subsystem string) {
 	trigger.Subsystem = subsystem
 }
 
+func (trigger *Trigger) getMethod() string {
+	return trigger.Method
+}
+
+func (trigger *Trigger) setMethod(method string) {
+	trigger.Method = method
+}
+
+func (trigger *Trigger) getPath() string {
+	return trigger.Path
+}
+
+func (trigger *Trigger) setPath(path string) {
+	trigger.Path = path
+}
+
+func (trigger *Trigger) getQuery() map[string]string {
+	return trigger.Query
+}
+
+func (trigger *Trigger) setQuery(query map[string]string) {
+	trigger.Query = query
+}
+
+func (trigger *Trigger) getPriority() int {
+	return trigger.Priority
+}
+
+func (trigger *Trigger) setPriority(priority int) {
+	trigger.Priority = priority
+}
+
+func (trigger *Trigger) getStateMachine() string {
+	return trigger.StateMachine
+}
+
+func (trigger *Trigger) setStateMachine(stateMachine string) {
+	trigger.StateMachine = stateMachine
+}
+
+func (trigger *Trigger) getState() string {
+	return trigger.State
+}
+
+func (trigger *Trigger) setState(state string) {
+	trigger.State = state
+}
+
 type RegexTrigger struct {
 	*Trigger
 	expressionRegexp *regexp.Regexp
@@ -183,26 +263,59 @@ func (trigger *XmlPathTrigger) prepare() error {
 func containHeaders(messageHeaders map[string]string, triggerHeaders map[string]string) bool {
 	for key, valueTrigger := range triggerHeaders {
 		valueMessage, ok := messageHeaders[key]
-		if !ok {
+		if !ok || !headerContainsValue(valueMessage, valueTrigger) {
 			return false
 		}
+	}
+	return true
+}
 
-		splitValueMessage := strings.Split(valueMessage, ";")
-
-		// Trim any whitespace
-		for i, val := range splitValueMessage {
-			splitValueMessage[i] = strings.TrimSpace(val)
+// headerContainsValue проверяет, что одно из значений заголовка, разделённых ';', равно valueTrigger
+func headerContainsValue(valueMessage string, valueTrigger string) bool {
+	for _, val := range strings.Split(valueMessage, ";") {
+		if valueTrigger == strings.TrimSpace(val) {
+			return true
 		}
+	}
+	return false
+}
+
+// matchPath сравнивает путь сообщения с шаблоном по сегментам. Сегмент :param совпадает с любым непустым сегментом,
+// ** - с любым количеством оставшихся сегментов, остальные сегменты сравниваются как glob-шаблоны
+func matchPath(pattern string, messagePath string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(messagePath, "/"), "/")
 
-		contains := false
-		for _, val := range splitValueMessage {
-			if valueTrigger == val {
-				contains = true
-				break
+	for i, patternSegment := range patternSegments {
+		if patternSegment == "**" {
+			return true
+		}
+		if i >= len(pathSegments) {
+			return false
+		}
+		if strings.HasPrefix(patternSegment, ":") {
+			if pathSegments[i] == "" {
+				return false
 			}
+			continue
 		}
+		if matched, err := path.Match(patternSegment, pathSegments[i]); err != nil || !matched {
+			return false
+		}
+	}
+
+	return len(patternSegments) == len(pathSegments)
+}
 
-		if !contains {
+// containQuery проверяет наличие параметров запроса, пустое значение в триггере означает любое значение параметра
+func containQuery(messageQuery map[string]string, triggerQuery map[string]string) bool {
+	for key, valueTrigger := range triggerQuery {
+		valueMessage, ok := messageQuery[key]
+		if !ok || (valueTrigger != "" && valueTrigger != valueMessage) {
 			return false
 		}
 	}
@@ -210,7 +323,11 @@ func containHeaders(messageHeaders map[string]string, triggerHeaders map[string]
 }
 
 func (trigger *Trigger) TriggerOnMessage(message *util.Message) bool {
-	return trigger.IsActive && containHeaders(message.Headers, trigger.Headers)
+	return trigger.IsActive &&
+		(trigger.Method == "" || strings.EqualFold(trigger.Method, message.Method)) &&
+		matchPath(trigger.Path, message.Path) &&
+		containQuery(message.Query, trigger.Query) &&
+		containHeaders(message.Headers, trigger.Headers)
 }
 
 func (trigger *RegexTrigger) TriggerOnMessage(message *util.Message) bool {
@@ -269,7 +386,55 @@ func (trigger *XmlPathTrigger) TriggerOnMessage(message *util.Message) bool {
 	return false
 }
 
+// specificity - количество ограничений триггера на заголовки, метод, путь, параметры запроса и состояние
+func specificity(trigger TriggerInterface) int {
+	result := len(trigger.getHeaders()) + len(trigger.getQuery())
+	if trigger.getMethod() != "" {
+		result++
+	}
+	if trigger.getStateMachine() != "" {
+		result++
+	}
+	if trigger.getPath() != "" {
+		result++
+	}
+	return result
+}
+
+// sortTriggers упорядочивает триггеры по приоритету, при равном приоритете первым идёт более конкретный триггер,
+// затем триггер с меньшим id
+func sortTriggers(triggers []TriggerInterface) {
+	sort.SliceStable(triggers, func(i, j int) bool {
+		if triggers[i].getPriority() != triggers[j].getPriority() {
+			return triggers[i].getPriority() < triggers[j].getPriority()
+		}
+		if specificityI, specificityJ := specificity(triggers[i]), specificity(triggers[j]); specificityI != specificityJ {
+			return specificityI > specificityJ
+		}
+		return triggers[i].getId() < triggers[j].getId()
+	})
+}
+
+// NewMessageMatcher создаёт условие шага сценария if из выражения триггера указанного типа. Условие проверяет
+// только тело сообщения, без заголовков, метода и пути
+func NewMessageMatcher(matcherType string, expression string) (scenarios.MessageMatcher, error) {
+	baseTrigger := &Trigger{TriggerType: TriggerType(matcherType), Expression: expression, IsActive: true}
+	trigger := CreateTriggerFromBaseTrigger(baseTrigger)
+	if trigger == nil {
+		return nil, &TriggerValidationException{message: fmt.Sprintf("Неизвестный тип триггера %s", matcherType)}
+	}
+	if err := trigger.prepare(); err != nil {
+		return nil, err
+	}
+	// prepare добавляет ожидаемый Content-Type, который условию шага не нужен
+	baseTrigger.Headers = make(map[string]string)
+	return trigger, nil
+}
+
 func CreateTriggerFromBaseTrigger(baseTrigger *Trigger) (trigger TriggerInterface) {
+	if baseTrigger.Headers == nil {
+		baseTrigger.Headers = make(map[string]string)
+	}
 	switch baseTrigger.TriggerType {
 	case Regex:
 		trigger = &RegexTrigger{Trigger: baseTrigger}
@@ -1,21 +1,30 @@
 package triggers
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/rs/zerolog/log"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unimock/database"
+	"unimock/history"
 	"unimock/scenarios"
+	"unimock/states"
 	"unimock/util"
 )
 
-const InsertQuery = "INSERT INTO triggers (type, expression, description, active, headers, subsystem) VALUES (?,?,?,?,?,?)"
-const UpdateQuery = "UPDATE triggers SET type = ?, expression = ?, description = ?, active = ?, headers = ?, subsystem = ? where id = ?"
-const SelectAllQuery = "SELECT * FROM triggers"
+const InsertQuery = "INSERT INTO triggers (type, expression, description, active, headers, subsystem, method, path, query, priority, state_machine, state) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)"
+const InsertWithIdQuery = "INSERT INTO triggers (id, type, expression, description, active, headers, subsystem, method, path, query, priority, state_machine, state) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)"
+const UpdateQuery = "UPDATE triggers SET type = ?, expression = ?, description = ?, active = ?, headers = ?, subsystem = ?, method = ?, path = ?, query = ?, priority = ?, state_machine = ?, state = ? where id = ?"
+const SelectAllQuery = "SELECT id, type, expression, description, active, headers, subsystem, method, path, query, priority, state_machine, state FROM triggers"
 const DeleteQuery = "DELETE FROM triggers WHERE id = ?"
 
 var successTriggerProcessingMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{Name: "trigger_success_requests_duration_histogram", Help: "Успешные обработки запросов триггером"},
@@ -25,30 +34,57 @@ var failedTriggerProcessingMetric = promauto.NewHistogramVec(prometheus.Histogra
 	[]string{"trigger_id"})
 
 type TriggerService struct {
-	triggers        map[int64]TriggerInterface
+	registry atomic.Pointer[triggerRegistry]
+	// mut упорядочивает изменения реестра, чтение выполняется без блокировок
+	mut             sync.Mutex
 	db              *sql.DB
 	scenarioService *scenarios.ScenarioService
+	stateService    *states.StateService
+	versions        *history.History
 }
 
-func NewService(db *sql.DB, scenarioService *scenarios.ScenarioService) *TriggerService {
-	return &TriggerService{
-		triggers:        make(map[int64]TriggerInterface),
+func NewService(db *sql.DB, scenarioService *scenarios.ScenarioService, stateService *states.StateService) *TriggerService {
+	service := &TriggerService{
 		db:              db,
 		scenarioService: scenarioService,
+		stateService:    stateService,
+		versions:        history.NewHistory(db, history.TriggerVersionsTable),
 	}
+	service.registry.Store(newTriggerRegistry(make(map[int64]TriggerInterface)))
+	stateService.AddUsage(service.machineUsages)
+	return service
+}
+
+// machineUsages возвращает триггеры, которые требуют состояния автомата name
+func (service *TriggerService) machineUsages(name string) []string {
+	usages := make([]string, 0)
+	for _, trigger := range service.registry.Load().orderedTriggers {
+		if trigger.getStateMachine() == name {
+			usages = append(usages, fmt.Sprintf("триггер %d", trigger.getId()))
+		}
+	}
+	return usages
 }
 
 func (service *TriggerService) GetTriggers() []TriggerInterface {
-	triggerValues := make([]TriggerInterface, 0, len(service.triggers))
+	triggers := service.registry.Load().triggers
+	triggerValues := make([]TriggerInterface, 0, len(triggers))
 
-	for _, value := range service.triggers {
+	for _, value := range triggers {
 		triggerValues = append(triggerValues, value)
 	}
 	return triggerValues
 }
 
+func (service *TriggerService) GetTriggersInEvaluationOrder() []TriggerInterface {
+	orderedTriggers := service.registry.Load().orderedTriggers
+	triggerValues := make([]TriggerInterface, len(orderedTriggers))
+	copy(triggerValues, orderedTriggers)
+	return triggerValues
+}
+
 func (service *TriggerService) GetTriggerById(id int64) (TriggerInterface, error) {
-	trigger, ok := service.triggers[id]
+	trigger, ok := service.registry.Load().triggers[id]
 
 	if !ok {
 		return nil, &TriggerNotFoundException{
@@ -59,159 +95,342 @@ func (service *TriggerService) GetTriggerById(id int64) (TriggerInterface, error
 	}
 }
 
-func (service *TriggerService) AddTrigger(trigger TriggerInterface) error {
+// validateTrigger проверяет тип триггера и то, что требование состояния задано полностью и ссылается
+// на существующий автомат
+func (service *TriggerService) validateTrigger(trigger TriggerInterface) error {
 	if !trigger.validate() {
 		return &TriggerValidationException{message: "Не указан тип триггера"}
 	}
-	insertStatement, err := service.db.Prepare(InsertQuery)
-	if err != nil {
-		return err
+	if (trigger.getStateMachine() == "") != (trigger.getState() == "") {
+		return &TriggerValidationException{message: "Автомат и состояние триггера указываются вместе"}
 	}
-	res, err := insertStatement.Exec(trigger.getType(), trigger.getExpression(), trigger.getDescription(),
-		trigger.getIsActive(), buildHeadersForDb(trigger.getHeaders()), trigger.getSubsystem())
-	if err != nil {
-		return err
+	if trigger.getStateMachine() != "" {
+		if _, err := service.stateService.GetMachineByName(trigger.getStateMachine()); err != nil {
+			return &TriggerValidationException{message: err.Error()}
+		}
 	}
+	return nil
+}
 
-	id, err := res.LastInsertId()
-	if err != nil {
+// Versions возвращает историю изменений триггеров
+func (service *TriggerService) Versions() *history.History {
+	return service.versions
+}
+
+func (service *TriggerService) AddTrigger(trigger TriggerInterface, author string) error {
+	return service.addTrigger(trigger, nil, author, history.Created)
+}
+
+// deletedTrigger - снимок удалённого триггера вместе с шагами сценария, которые удаляются вместе с ним
+type deletedTrigger struct {
+	*Trigger
+	Steps scenarios.Steps `json:"steps"`
+}
+
+// addTrigger сохраняет новый триггер. При восстановлении удалённого триггера из версии (операция RolledBack)
+// триггер и его шаги steps сохраняются с прежними id, чтобы история версий осталась связана с ними
+func (service *TriggerService) addTrigger(trigger TriggerInterface, steps scenarios.Steps, author string,
+	operation history.Operation) error {
+	if err := service.validateTrigger(trigger); err != nil {
+		return err
+	}
+	if err := trigger.prepare(); err != nil {
 		return err
 	}
 
-	trigger.setId(id)
+	service.mut.Lock()
+	defer service.mut.Unlock()
 
-	err = insertStatement.Close()
+	headers, query, err := headersAndQueryForDb(trigger)
 	if err != nil {
 		return err
 	}
 
-	err = trigger.prepare()
+	var restoreSteps func()
+	err = database.InTransaction(service.db, func(tx *sql.Tx) error {
+		var res sql.Result
+		if operation == history.RolledBack {
+			res, err = tx.Exec(InsertWithIdQuery, trigger.getId(), trigger.getType(), trigger.getExpression(),
+				trigger.getDescription(), trigger.getIsActive(), headers, trigger.getSubsystem(),
+				trigger.getMethod(), trigger.getPath(), query, trigger.getPriority(),
+				trigger.getStateMachine(), trigger.getState())
+		} else {
+			res, err = tx.Exec(InsertQuery, trigger.getType(), trigger.getExpression(), trigger.getDescription(),
+				trigger.getIsActive(), headers, trigger.getSubsystem(),
+				trigger.getMethod(), trigger.getPath(), query, trigger.getPriority(),
+				trigger.getStateMachine(), trigger.getState())
+		}
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		trigger.setId(id)
+		if err = service.versions.Record(tx, id, operation, author, trigger); err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			return nil
+		}
+		restoreSteps, err = service.scenarioService.RestoreStepsForTrigger(tx, steps, author)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
-	service.triggers[trigger.getId()] = trigger
+	if restoreSteps != nil {
+		restoreSteps()
+	}
+	service.registry.Store(service.registry.Load().with(trigger))
 	return nil
 }
 
-func (service *TriggerService) UpdateTrigger(trigger TriggerInterface) error {
-	if !trigger.validate() {
-		return &TriggerValidationException{message: "Не указан тип триггера"}
-	}
-	updateStatement, err := service.db.Prepare(UpdateQuery)
+func (service *TriggerService) UpdateTrigger(trigger TriggerInterface, author string) error {
+	return service.updateTrigger(trigger, author, history.Updated)
+}
+
+// RollbackTrigger восстанавливает триггер из сохранённой версии, откат записывается в историю новой версией.
+// Удалённый триггер создаётся заново вместе с шагами из версии, записанной при удалении
+func (service *TriggerService) RollbackTrigger(id int64, version int64, author string) error {
+	snapshot, err := service.versions.GetVersion(id, version)
 	if err != nil {
 		return err
 	}
-	_, err = updateStatement.Exec(trigger.getType(), trigger.getExpression(), trigger.getDescription(),
-		trigger.getIsActive(), buildHeadersForDb(trigger.getHeaders()), trigger.getSubsystem(), trigger.getId())
-	if err != nil {
+	baseTrigger := new(Trigger)
+	if err = json.Unmarshal(snapshot.Snapshot, baseTrigger); err != nil {
 		return err
 	}
+	trigger := CreateTriggerFromBaseTrigger(baseTrigger)
+	if trigger == nil {
+		return &TriggerValidationException{message: "Неизвестный тип триггера"}
+	}
+	trigger.setId(id)
+	if _, err = service.GetTriggerById(id); err == nil {
+		return service.updateTrigger(trigger, author, history.RolledBack)
+	}
 
-	err = updateStatement.Close()
+	latest, err := service.versions.GetLatestVersion(id)
 	if err != nil {
 		return err
 	}
-
-	err = trigger.prepare()
-	if err != nil {
+	if latest.Operation != history.Deleted {
+		return &TriggerNotFoundException{message: fmt.Sprintf("Триггер с id = %d не найден", id)}
+	}
+	deleted := &deletedTrigger{Trigger: new(Trigger)}
+	if err = json.Unmarshal(latest.Snapshot, deleted); err != nil {
 		return err
 	}
-
-	service.triggers[trigger.getId()] = trigger
-	return nil
+	return service.addTrigger(trigger, deleted.Steps, author, history.RolledBack)
 }
 
-func (service *TriggerService) DeleteTrigger(id int64) error {
-	deleteStatement, err := service.db.Prepare(DeleteQuery)
-	if err != nil {
+func (service *TriggerService) updateTrigger(trigger TriggerInterface, author string, operation history.Operation) error {
+	if err := service.validateTrigger(trigger); err != nil {
+		return err
+	}
+	if err := trigger.prepare(); err != nil {
 		return err
 	}
-	_, err = deleteStatement.Exec(id)
+
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	headers, query, err := headersAndQueryForDb(trigger)
 	if err != nil {
 		return err
 	}
 
-	err = deleteStatement.Close()
+	err = database.InTransaction(service.db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(UpdateQuery, trigger.getType(), trigger.getExpression(), trigger.getDescription(),
+			trigger.getIsActive(), headers, trigger.getSubsystem(),
+			trigger.getMethod(), trigger.getPath(), query, trigger.getPriority(),
+			trigger.getStateMachine(), trigger.getState(), trigger.getId())
+		if err != nil {
+			return err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return &TriggerNotFoundException{message: fmt.Sprintf("Триггер с id = %d не найден", trigger.getId())}
+		}
+		return service.versions.Record(tx, trigger.getId(), operation, author, trigger)
+	})
 	if err != nil {
 		return err
 	}
 
-	delete(service.triggers, id)
+	service.registry.Store(service.registry.Load().with(trigger))
 	return nil
 }
 
-func buildHeadersForDb(headers map[string]string) string {
-	var res strings.Builder
+func (service *TriggerService) DeleteTrigger(id int64, author string) error {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
+	trigger, ok := service.registry.Load().triggers[id]
+	steps := service.scenarioService.GetOrderedStepsByTriggerId(id)
 
-	for key, value := range headers {
-		res.WriteString(fmt.Sprintf("%s=%s, ", key, value))
+	var forgetSteps func()
+	err := database.InTransaction(service.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(DeleteQuery, id); err != nil {
+			return err
+		}
+		var err error
+		if forgetSteps, err = service.scenarioService.DeleteStepsForTrigger(tx, id, author); err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		return service.versions.Record(tx, id, history.Deleted, author, &deletedTrigger{Trigger: trigger.base(), Steps: steps})
+	})
+	if err != nil {
+		return err
 	}
-	return res.String()
-}
 
-func getHeadersFromString(headersRow string) map[string]string {
-	pairs := strings.Split(headersRow, ",")
+	forgetSteps()
+	service.registry.Store(service.registry.Load().without(id))
+	return nil
+}
 
-	resMap := make(map[string]string, len(pairs))
+// buildHeadersForDb сериализует заголовки или параметры запроса в JSON. Ключи json.Encoder выводит по порядку,
+// а экранирование HTML отключено, как в json_group_object миграции, чтобы уникальный индекс по ним работал
+func buildHeadersForDb(headers map[string]string) (string, error) {
+	if len(headers) == 0 {
+		return "", nil
+	}
+	var res bytes.Buffer
+	encoder := json.NewEncoder(&res)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(headers); err != nil {
+		return "", &TriggerValidationException{message: err.Error()}
+	}
+	return strings.TrimSuffix(res.String(), "\n"), nil
+}
 
-	for _, pair := range pairs {
-		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
-		if len(kv) == 2 {
-			resMap[kv[0]] = kv[1]
-		}
+func headersAndQueryForDb(trigger TriggerInterface) (string, string, error) {
+	headers, err := buildHeadersForDb(trigger.getHeaders())
+	if err != nil {
+		return "", "", err
 	}
+	query, err := buildHeadersForDb(trigger.getQuery())
+	return headers, query, err
+}
 
-	return resMap
+func getHeadersFromString(headersRow string) (map[string]string, error) {
+	headers := make(map[string]string)
+	if headersRow == "" {
+		return headers, nil
+	}
+	err := json.Unmarshal([]byte(headersRow), &headers)
+	return headers, err
 }
 
 func (service *TriggerService) UpdateFromDb() error {
+	service.mut.Lock()
+	defer service.mut.Unlock()
+
 	rows, err := service.db.Query(SelectAllQuery)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
-	service.triggers = make(map[int64]TriggerInterface)
+	triggers := make(map[int64]TriggerInterface)
 
 	for rows.Next() {
 		var baseTrigger Trigger
 		var headersRow string
+		var queryRow string
 		err = rows.Scan(&baseTrigger.Id, &baseTrigger.TriggerType, &baseTrigger.Expression,
-			&baseTrigger.Description, &baseTrigger.IsActive, &headersRow, &baseTrigger.Subsystem)
+			&baseTrigger.Description, &baseTrigger.IsActive, &headersRow, &baseTrigger.Subsystem,
+			&baseTrigger.Method, &baseTrigger.Path, &queryRow, &baseTrigger.Priority, &baseTrigger.StateMachine,
+			&baseTrigger.State)
 		if err != nil {
 			return err
 		}
 
-		baseTrigger.Headers = getHeadersFromString(headersRow)
+		if baseTrigger.Headers, err = getHeadersFromString(headersRow); err != nil {
+			return err
+		}
+		if baseTrigger.Query, err = getHeadersFromString(queryRow); err != nil {
+			return err
+		}
 		trigger := CreateTriggerFromBaseTrigger(&baseTrigger)
 		err = trigger.prepare()
 		if err != nil {
 			return err
 		}
 
-		service.triggers[trigger.getId()] = trigger
+		triggers[trigger.getId()] = trigger
 	}
+
+	service.registry.Store(newTriggerRegistry(triggers))
 	return nil
 }
 
-func (service *TriggerService) ProcessMessage(message *util.Message) (*util.Message, error) {
-	for _, trigger := range service.triggers {
-		if trigger.TriggerOnMessage(message) {
+func (service *TriggerService) ProcessMessage(ctx context.Context, message *util.Message) (*util.Message, error) {
+	trigger, err := service.FindTrigger(message)
+	if err != nil {
+		return nil, err
+	}
+	return service.ProcessTriggerMessage(ctx, trigger, message)
+}
+
+// FindTrigger возвращает первый в порядке проверки триггер, срабатывающий на сообщение
+func (service *TriggerService) FindTrigger(message *util.Message) (TriggerInterface, error) {
+	for _, trigger := range service.registry.Load().orderedTriggers {
+		if trigger.TriggerOnMessage(message) && service.inState(trigger, message) {
 			log.Debug().Int64("triggerId", trigger.getId()).Msg("Выбран триггер")
-			startTime := time.Now()
-			msg, err := service.scenarioService.ProcessMessage(message, trigger.getId())
-			duration := time.Since(startTime).Seconds()
-			if err == nil {
-				successTriggerProcessingMetric.WithLabelValues(strconv.FormatInt(trigger.getId(), 10)).
-					Observe(duration)
-			} else {
-				failedTriggerProcessingMetric.WithLabelValues(strconv.FormatInt(trigger.getId(), 10)).
-					Observe(duration)
-			}
-			return msg, err
+			return trigger, nil
 		}
 	}
 	return nil, &TriggerNotFoundException{
 		message: "Триггер для сообщения не найден",
 	}
 }
+
+// inState проверяет, что ключ сообщения находится в состоянии, которого требует триггер
+func (service *TriggerService) inState(trigger TriggerInterface, message *util.Message) bool {
+	return service.diagnoseState(trigger, message) == nil
+}
+
+// diagnoseState возвращает несоответствие состояния ключа сообщения требованию триггера
+func (service *TriggerService) diagnoseState(trigger TriggerInterface, message *util.Message) *Mismatch {
+	if trigger.getStateMachine() == "" {
+		return nil
+	}
+	_, state, err := service.stateService.GetState(trigger.getStateMachine(), message)
+	if err != nil {
+		return &Mismatch{Part: StatePart, Name: trigger.getStateMachine(), Expected: trigger.getState(),
+			Reason: err.Error()}
+	}
+	if state != trigger.getState() {
+		return &Mismatch{Part: StatePart, Name: trigger.getStateMachine(), Expected: trigger.getState(), Actual: state,
+			Reason: "состояние отличается"}
+	}
+	return nil
+}
+
+// FindNearMisses возвращает до limit триггеров, ближе всего подошедших к сообщению, с причинами несрабатывания
+func (service *TriggerService) FindNearMisses(message *util.Message, limit int) []*NearMiss {
+	return findNearMisses(service.registry.Load().orderedTriggers, message, limit, service.diagnoseState)
+}
+
+func (service *TriggerService) ProcessTriggerMessage(ctx context.Context, trigger TriggerInterface, message *util.Message) (*util.Message, error) {
+	startTime := time.Now()
+	msg, err := service.scenarioService.ProcessMessage(ctx, message, trigger.getId())
+	duration := time.Since(startTime).Seconds()
+	if err == nil {
+		successTriggerProcessingMetric.WithLabelValues(strconv.FormatInt(trigger.getId(), 10)).
+			Observe(duration)
+	} else {
+		failedTriggerProcessingMetric.WithLabelValues(strconv.FormatInt(trigger.getId(), 10)).
+			Observe(duration)
+	}
+	return msg, err
+}
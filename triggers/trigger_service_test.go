@@ -0,0 +1,252 @@
+package triggers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"unimock/database"
+	"unimock/history"
+	"unimock/scenarios"
+	"unimock/states"
+	"unimock/templates"
+	"unimock/util"
+)
+
+func newTestService(t *testing.T) *TriggerService {
+	service, _, _ := newTestServices(t)
+	return service
+}
+
+func newTestServices(t *testing.T) (*TriggerService, *scenarios.ScenarioService, *templates.TemplateService) {
+	dbFile := filepath.Join(t.TempDir(), "unimock.db")
+	require.NoError(t, os.WriteFile(dbFile, nil, 0o644))
+
+	db, err := database.InitDatabaseConnection(dbFile, "../sql")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	templateService := templates.NewService(db)
+	require.NoError(t, templateService.UpdateFromDb())
+	stateService := states.NewService(db)
+	require.NoError(t, stateService.UpdateFromDb())
+	scenarioService := scenarios.NewService(db, templateService, stateService, NewMessageMatcher)
+	require.NoError(t, scenarioService.UpdateFromDb())
+	service := NewService(db, scenarioService, stateService)
+	require.NoError(t, service.UpdateFromDb())
+	return service, scenarioService, templateService
+}
+
+func TestConcurrentTriggerCrudAndProcessing(t *testing.T) {
+	service := newTestService(t)
+
+	require.NoError(t, service.AddTrigger(CreateTriggerFromBaseTrigger(&Trigger{
+		TriggerType: Regex,
+		Expression:  "ping",
+		IsActive:    true,
+		Priority:    100,
+	}), ""))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				trigger := CreateTriggerFromBaseTrigger(&Trigger{
+					TriggerType: Regex,
+					Expression:  fmt.Sprintf("pong-%d-%d", writer, j),
+					IsActive:    true,
+				})
+				assert.NoError(t, service.AddTrigger(trigger, ""))
+				updated := CreateTriggerFromBaseTrigger(&Trigger{
+					Id:          trigger.getId(),
+					TriggerType: Regex,
+					Expression:  trigger.getExpression(),
+					IsActive:    true,
+					Priority:    j,
+				})
+				assert.NoError(t, service.UpdateTrigger(updated, ""))
+				assert.NoError(t, service.DeleteTrigger(trigger.getId(), ""))
+			}
+		}(i)
+	}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				_, err := service.ProcessMessage(context.Background(), &util.Message{Body: "ping"})
+				assert.NoError(t, err)
+				_ = service.GetTriggers()
+				_ = service.GetTriggersInEvaluationOrder()
+			}
+		}()
+	}
+
+	wg.Wait()
+	require.Len(t, service.GetTriggersInEvaluationOrder(), 1)
+}
+
+func TestIfStepWithTriggerMatchers(t *testing.T) {
+	service, scenarioService, templateService := newTestServices(t)
+	trigger := CreateTriggerFromBaseTrigger(&Trigger{TriggerType: Regex, Expression: "order", IsActive: true})
+	require.NoError(t, service.AddTrigger(trigger, ""))
+
+	addTemplate := func(body string) int64 {
+		template := &templates.Template{Name: body, Body: body}
+		require.NoError(t, templateService.AddTemplate(template, ""))
+		return template.Id
+	}
+	steps := scenarios.Steps{
+		{Id: -1, OrderNumber: 1, TriggerId: trigger.getId(), StepType: scenarios.If,
+			Config: json.RawMessage(`{"type": "jsonpath", "expression": "$.amount == 5000", "goto": "large"}`)},
+		{Id: -1, OrderNumber: 2, TriggerId: trigger.getId(), StepType: scenarios.TemplateProcessing,
+			Config: scenarios.CreateStepConfig(&scenarios.TemplateStepConfig{TemplateId: addTemplate("approved")})},
+		{Id: -1, OrderNumber: 3, TriggerId: trigger.getId(), StepType: scenarios.If,
+			Config: json.RawMessage(`{"type": "regex", "expression": "", "skip": 1}`)},
+		{Id: -1, OrderNumber: 4, TriggerId: trigger.getId(), StepType: scenarios.TemplateProcessing, Label: "large",
+			Config: scenarios.CreateStepConfig(&scenarios.TemplateStepConfig{TemplateId: addTemplate("review")})},
+	}
+	_, err := scenarioService.UpdateStepsForTrigger(steps, trigger.getId(), "")
+	require.NoError(t, err)
+
+	process := func(body string) string {
+		output, err := service.ProcessMessage(context.Background(), &util.Message{Body: body})
+		require.NoError(t, err)
+		return output.Body
+	}
+	require.Equal(t, "approved", process(`{"order": 1, "amount": 10}`))
+	require.Equal(t, "review", process(`{"order": 2, "amount": 5000}`))
+
+	require.Error(t, scenarioService.AddStep(&scenarios.ScenarioStep{TriggerId: trigger.getId(), StepType: scenarios.If,
+		Config: json.RawMessage(`{"type": "jsonpath", "expression": "$[", "skip": 1}`)}, ""))
+}
+
+func TestTriggerAndStepVersions(t *testing.T) {
+	service, scenarioService, templateService := newTestServices(t)
+	trigger := CreateTriggerFromBaseTrigger(&Trigger{TriggerType: Regex, Expression: "v1", IsActive: true})
+	require.NoError(t, service.AddTrigger(trigger, "alice"))
+	trigger = CreateTriggerFromBaseTrigger(&Trigger{Id: trigger.getId(), TriggerType: Gson, Expression: "v2"})
+	require.NoError(t, service.UpdateTrigger(trigger, "bob"))
+
+	require.NoError(t, service.RollbackTrigger(trigger.getId(), 1, "carol"))
+	restored, err := service.GetTriggerById(trigger.getId())
+	require.NoError(t, err)
+	require.Equal(t, Regex, restored.getType())
+	require.Equal(t, "v1", restored.getExpression())
+
+	template := &templates.Template{Name: "step", Body: "step"}
+	require.NoError(t, templateService.AddTemplate(template, ""))
+	steps, err := scenarioService.UpdateStepsForTrigger(scenarios.Steps{
+		{Id: -1, OrderNumber: 1, TriggerId: trigger.getId(), StepType: scenarios.Delay,
+			Config: json.RawMessage(`{"ms": 10}`)},
+	}, trigger.getId(), "alice")
+	require.NoError(t, err)
+	step := steps[0]
+	require.NoError(t, scenarioService.UpdateStep(&scenarios.ScenarioStep{Id: step.Id, OrderNumber: 1,
+		TriggerId: trigger.getId(), StepType: scenarios.TemplateProcessing,
+		Config: scenarios.CreateStepConfig(&scenarios.TemplateStepConfig{TemplateId: template.Id})}, "bob"))
+
+	require.NoError(t, scenarioService.RollbackStep(step.Id, 1, "carol"))
+	require.Equal(t, scenarios.Delay, scenarioService.GetOrderedStepsByTriggerId(trigger.getId())[0].StepType)
+	versions, err := scenarioService.Versions().GetVersions(step.Id)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	require.Equal(t, "alice", versions[0].Author)
+
+	diff, err := service.Versions().Diff(trigger.getId(), 1, 2)
+	require.NoError(t, err)
+	require.Contains(t, diff, "-expression: v1\n")
+	require.Contains(t, diff, "+type: gson\n")
+
+	require.NoError(t, service.DeleteTrigger(trigger.getId(), "dave"))
+	require.Empty(t, scenarioService.GetOrderedStepsByTriggerId(trigger.getId()))
+	require.NoError(t, service.RollbackTrigger(trigger.getId(), 3, "erin"))
+	require.Len(t, scenarioService.GetOrderedStepsByTriggerId(trigger.getId()), 1,
+		"Шаги восстанавливаются вместе с триггером")
+	require.NoError(t, service.UpdateFromDb())
+	require.NoError(t, scenarioService.UpdateFromDb())
+	restored, err = service.GetTriggerById(trigger.getId())
+	require.NoError(t, err)
+	require.Equal(t, "v1", restored.getExpression())
+	restoredSteps := scenarioService.GetOrderedStepsByTriggerId(trigger.getId())
+	require.Len(t, restoredSteps, 1)
+	require.Equal(t, step.Id, restoredSteps[0].Id)
+	require.Equal(t, scenarios.Delay, restoredSteps[0].StepType)
+	versions, err = service.Versions().GetVersions(trigger.getId())
+	require.NoError(t, err)
+	require.Len(t, versions, 5)
+	require.Equal(t, history.Deleted, versions[3].Operation)
+	versions, err = scenarioService.Versions().GetVersions(step.Id)
+	require.NoError(t, err)
+	require.Equal(t, history.RolledBack, versions[len(versions)-1].Operation)
+
+	missing := CreateTriggerFromBaseTrigger(&Trigger{Id: 100, TriggerType: Regex, Expression: "missing"})
+	require.IsType(t, &TriggerNotFoundException{}, service.UpdateTrigger(missing, ""))
+	_, err = service.GetTriggerById(100)
+	require.Error(t, err, "Несуществующий триггер не добавляется при изменении")
+	versions, err = service.Versions().GetVersions(100)
+	require.NoError(t, err)
+	require.Empty(t, versions)
+	require.IsType(t, &scenarios.StepNotFoundException{}, scenarioService.UpdateStep(&scenarios.ScenarioStep{Id: 100,
+		OrderNumber: 1, TriggerId: trigger.getId(), StepType: scenarios.Delay, Config: json.RawMessage(`{"ms": 10}`)}, ""))
+	versions, err = scenarioService.Versions().GetVersions(100)
+	require.NoError(t, err)
+	require.Empty(t, versions)
+}
+
+func TestHeadersAndQuerySurviveReload(t *testing.T) {
+	service := newTestService(t)
+	trigger := CreateTriggerFromBaseTrigger(&Trigger{TriggerType: Regex, Expression: "ids", IsActive: true,
+		Headers: map[string]string{"X-Pad": "  a"}, Query: map[string]string{"ids": "1,2"}})
+	require.NoError(t, service.AddTrigger(trigger, ""))
+	require.NoError(t, service.UpdateFromDb())
+
+	loaded, err := service.GetTriggerById(trigger.getId())
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"X-Pad": "  a"}, loaded.getHeaders())
+	require.Equal(t, map[string]string{"ids": "1,2"}, loaded.getQuery())
+}
+
+func TestLegacyHeadersMigration(t *testing.T) {
+	sqlDirectory := t.TempDir()
+	copyMigration := func(name string) {
+		content, err := os.ReadFile(filepath.Join("../sql", name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(sqlDirectory, name), content, 0o644))
+	}
+	for i := 0; i < 17; i++ {
+		copyMigration(fmt.Sprintf("%d.sql", i))
+	}
+	dbFile := filepath.Join(t.TempDir(), "unimock.db")
+	require.NoError(t, os.WriteFile(dbFile, nil, 0o644))
+	db, err := database.InitDatabaseConnection(dbFile, sqlDirectory)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO triggers (type, expression, description, active, headers, query)
+		VALUES ('regex', 'a', '', 1, 'A=1, B=x=y, ', 'lang=en, '), ('regex', 'b', '', 1, NULL, '')`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	copyMigration("17.sql")
+	db, err = database.InitDatabaseConnection(dbFile, sqlDirectory)
+	require.NoError(t, err)
+	defer db.Close()
+
+	rows, err := db.Query("SELECT headers, query FROM triggers ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+	var migrated [][2]string
+	for rows.Next() {
+		var row [2]string
+		require.NoError(t, rows.Scan(&row[0], &row[1]))
+		migrated = append(migrated, row)
+	}
+	require.Equal(t, [][2]string{{`{"A":"1","B":"x=y"}`, `{"lang":"en"}`}, {"", ""}}, migrated)
+}
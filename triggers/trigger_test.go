@@ -0,0 +1,80 @@
+package triggers
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unimock/util"
+)
+
+func TestMatchPath(t *testing.T) {
+	require.True(t, matchPath("", "/orders/1"))
+	require.True(t, matchPath("/orders/:id", "/orders/1"))
+	require.True(t, matchPath("orders/:id/", "/orders/1"))
+	require.False(t, matchPath("/orders/:id", "/orders"))
+	require.False(t, matchPath("/orders/:id", "/orders/1/items"))
+	require.True(t, matchPath("/orders/*/items", "/orders/1/items"))
+	require.True(t, matchPath("/orders/ORD-*", "/orders/ORD-15"))
+	require.False(t, matchPath("/orders/ORD-*", "/orders/INV-15"))
+	require.True(t, matchPath("/orders/**", "/orders/1/items/2"))
+}
+
+func TestTriggerOnMethodPathAndQuery(t *testing.T) {
+	trigger := CreateTriggerFromBaseTrigger(&Trigger{
+		TriggerType: Regex,
+		Expression:  ".*",
+		IsActive:    true,
+		Method:      "get",
+		Path:        "/orders/:id",
+		Query:       map[string]string{"expand": "items", "lang": ""},
+	})
+	require.NoError(t, trigger.prepare())
+
+	message := &util.Message{
+		Method: "GET",
+		Path:   "/orders/42",
+		Query:  map[string]string{"expand": "items", "lang": "ru"},
+	}
+	require.True(t, trigger.TriggerOnMessage(message))
+
+	message.Method = "DELETE"
+	require.False(t, trigger.TriggerOnMessage(message))
+
+	message.Method = "GET"
+	message.Query = map[string]string{"expand": "items"}
+	require.False(t, trigger.TriggerOnMessage(message))
+
+	message.Query = map[string]string{"expand": "items", "lang": "en"}
+	message.Path = "/customers/42"
+	require.False(t, trigger.TriggerOnMessage(message))
+}
+
+func TestHeadersForDbAreSorted(t *testing.T) {
+	headers := map[string]string{"b": "2", "a": "1", "c": "<3>", "ids": "1,2", "pad": "  x"}
+	row, err := buildHeadersForDb(headers)
+	require.NoError(t, err)
+	require.Equal(t, `{"a":"1","b":"2","c":"<3>","ids":"1,2","pad":"  x"}`, row)
+	restored, err := getHeadersFromString(row)
+	require.NoError(t, err)
+	require.Equal(t, headers, restored)
+
+	row, err = buildHeadersForDb(nil)
+	require.NoError(t, err)
+	require.Equal(t, "", row)
+}
+
+func TestSortTriggers(t *testing.T) {
+	triggers := []TriggerInterface{
+		&RegexTrigger{Trigger: &Trigger{Id: 1, Priority: 10}},
+		&RegexTrigger{Trigger: &Trigger{Id: 2, Priority: 0}},
+		&RegexTrigger{Trigger: &Trigger{Id: 3, Priority: 0, Headers: map[string]string{"X-Type": "a"}}},
+		&RegexTrigger{Trigger: &Trigger{Id: 4, Priority: 0, Headers: map[string]string{"X-Type": "a"}}},
+		&RegexTrigger{Trigger: &Trigger{Id: 5, Priority: -1}},
+	}
+	sortTriggers(triggers)
+
+	ids := make([]int64, 0, len(triggers))
+	for _, trigger := range triggers {
+		ids = append(ids, trigger.getId())
+	}
+	require.Equal(t, []int64{5, 3, 4, 2, 1}, ids)
+}
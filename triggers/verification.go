@@ -0,0 +1,79 @@
+package triggers
+
+import (
+	"fmt"
+	"unimock/journal"
+)
+
+// VerificationRequest - условие на сообщения журнала. Matcher описывается так же, как триггер, но проверяется
+// независимо от активности. Если ни одно из ограничений количества не задано, возвращается только число совпадений
+type VerificationRequest struct {
+	Matcher Trigger `json:"matcher"`
+	Exactly *int    `json:"exactly"`
+	AtLeast *int    `json:"at_least"`
+	AtMost  *int    `json:"at_most"`
+}
+
+type VerificationResult struct {
+	Count    int    `json:"count"`
+	Verified bool   `json:"verified"`
+	Message  string `json:"message,omitempty"`
+}
+
+func (request *VerificationRequest) createMatcher() (TriggerInterface, error) {
+	baseTrigger := request.Matcher
+	baseTrigger.IsActive = true
+	// Без выражения проверяются только заголовки, метод, путь и параметры запроса
+	if baseTrigger.TriggerType == "" && baseTrigger.Expression == "" {
+		baseTrigger.TriggerType = Regex
+	}
+
+	matcher := CreateTriggerFromBaseTrigger(&baseTrigger)
+	if matcher == nil {
+		return nil, &TriggerValidationException{message: fmt.Sprintf("Неизвестный тип триггера %s", baseTrigger.TriggerType)}
+	}
+	if err := matcher.prepare(); err != nil {
+		return nil, err
+	}
+	return matcher, nil
+}
+
+func (request *VerificationRequest) check(count int) (bool, string) {
+	if request.Exactly != nil && count != *request.Exactly {
+		return false, fmt.Sprintf("Ожидалось ровно %d сообщений, получено %d", *request.Exactly, count)
+	}
+	if request.AtLeast != nil && count < *request.AtLeast {
+		return false, fmt.Sprintf("Ожидалось не менее %d сообщений, получено %d", *request.AtLeast, count)
+	}
+	if request.AtMost != nil && count > *request.AtMost {
+		return false, fmt.Sprintf("Ожидалось не более %d сообщений, получено %d", *request.AtMost, count)
+	}
+	return true, ""
+}
+
+// Verify считает записи журнала, подходящие под filter, на сообщения которых срабатывает matcher запроса.
+// Если в filter не указано направление, проверяются только входящие сообщения
+func Verify(requestJournal *journal.Journal, filter *journal.Filter, request *VerificationRequest) (*VerificationResult, error) {
+	matcher, err := request.createMatcher()
+	if err != nil {
+		return nil, err
+	}
+	if filter.Outbound == nil {
+		inbound := false
+		filter.Outbound = &inbound
+	}
+
+	count := 0
+	for _, record := range requestJournal.Find(filter) {
+		if matcher.TriggerOnMessage(record.Request) {
+			count++
+		}
+	}
+
+	verified, message := request.check(count)
+	return &VerificationResult{
+		Count:    count,
+		Verified: verified,
+		Message:  message,
+	}, nil
+}
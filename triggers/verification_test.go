@@ -0,0 +1,51 @@
+package triggers
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"unimock/journal"
+	"unimock/util"
+)
+
+func TestVerify(t *testing.T) {
+	requestJournal := journal.NewJournal(10, nil)
+	for _, body := range []string{`{"order": 1}`, `{"order": 2}`, `{"payment": 1}`} {
+		requestJournal.Record(&journal.Record{
+			Timestamp: time.Now(),
+			Request: &util.Message{
+				Body:    body,
+				Method:  "POST",
+				Headers: map[string]string{"Content-Type": "application/json"},
+			},
+		})
+	}
+
+	count := 2
+	result, err := Verify(requestJournal, &journal.Filter{}, &VerificationRequest{
+		Matcher: Trigger{TriggerType: Gson, Expression: "order"},
+		Exactly: &count,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Count)
+	require.True(t, result.Verified)
+
+	result, err = Verify(requestJournal, &journal.Filter{}, &VerificationRequest{
+		Matcher: Trigger{TriggerType: JsonPath, Expression: "$.payment == 1"},
+		AtLeast: &count,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Count)
+	require.False(t, result.Verified)
+
+	result, err = Verify(requestJournal, &journal.Filter{}, &VerificationRequest{
+		Matcher: Trigger{Method: "POST"},
+		AtMost:  &count,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, result.Count)
+	require.False(t, result.Verified)
+
+	_, err = Verify(requestJournal, &journal.Filter{}, &VerificationRequest{Matcher: Trigger{TriggerType: "unknown"}})
+	require.IsType(t, &TriggerValidationException{}, err)
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"net"
+	"sync"
+)
+
+type afterResponseKey struct{}
+
+// afterResponse - действия, которые обработчик выполняет после отправки ответа клиенту
+type afterResponse struct {
+	mut     sync.Mutex
+	actions []func()
+}
+
+// WithAfterResponse возвращает контекст, в котором AfterResponse откладывает действия. Обработчик передаёт run
+// в ResponseHooks.After, чтобы действия выполнились после отправки ответа
+func WithAfterResponse(ctx context.Context) (context.Context, func()) {
+	actions := new(afterResponse)
+	return context.WithValue(ctx, afterResponseKey{}, actions), func() {
+		actions.mut.Lock()
+		pending := actions.actions
+		actions.actions = nil
+		actions.mut.Unlock()
+		for _, action := range pending {
+			action()
+		}
+	}
+}
+
+// AfterResponse откладывает action до отправки ответа, если контекст создан WithAfterResponse, иначе выполняет сразу
+func AfterResponse(ctx context.Context, action func()) {
+	actions, ok := ctx.Value(afterResponseKey{}).(*afterResponse)
+	if !ok {
+		action()
+		return
+	}
+	actions.mut.Lock()
+	actions.actions = append(actions.actions, action)
+	actions.mut.Unlock()
+}
+
+// ResponseHooks выполняет действия после записи ответа в соединение. Обработчик fasthttp возвращается раньше,
+// чем ответ отправлен клиенту, поэтому действия запускаются, когда сервер переводит соединение в состояние
+// ожидания следующего запроса или закрывает его
+type ResponseHooks struct {
+	mut     sync.Mutex
+	pending map[net.Conn][]func()
+}
+
+func NewResponseHooks() *ResponseHooks {
+	return &ResponseHooks{pending: make(map[net.Conn][]func())}
+}
+
+// Install подключает hooks к серверу, установленный ранее обработчик ConnState сохраняется
+func (hooks *ResponseHooks) Install(server *fasthttp.Server) {
+	previous := server.ConnState
+	server.ConnState = func(conn net.Conn, state fasthttp.ConnState) {
+		if previous != nil {
+			previous(conn, state)
+		}
+		switch state {
+		case fasthttp.StateIdle, fasthttp.StateClosed, fasthttp.StateHijacked:
+			hooks.run(conn)
+		}
+	}
+}
+
+// After откладывает action до записи ответа на запрос request. Без hooks или соединения action выполняется сразу
+func (hooks *ResponseHooks) After(request *fiber.Ctx, action func()) {
+	conn := request.Context().Conn()
+	if hooks == nil || conn == nil {
+		action()
+		return
+	}
+	hooks.mut.Lock()
+	hooks.pending[conn] = append(hooks.pending[conn], action)
+	hooks.mut.Unlock()
+}
+
+func (hooks *ResponseHooks) run(conn net.Conn) {
+	hooks.mut.Lock()
+	actions := hooks.pending[conn]
+	delete(hooks.pending, conn)
+	hooks.mut.Unlock()
+	for _, action := range actions {
+		action()
+	}
+}
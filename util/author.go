@@ -0,0 +1,16 @@
+package util
+
+import "github.com/gofiber/fiber/v2"
+
+// authorLocal - ключ fiber.Ctx.Locals с автором изменения, который middleware берёт из заголовка запроса
+const authorLocal = "author"
+
+func SetAuthor(context *fiber.Ctx, author string) {
+	context.Locals(authorLocal, author)
+}
+
+// GetAuthor возвращает автора изменения или пустую строку, если заголовок не передан
+func GetAuthor(context *fiber.Ctx) string {
+	author, _ := context.Locals(authorLocal).(string)
+	return author
+}
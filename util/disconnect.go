@@ -0,0 +1,36 @@
+package util
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+const disconnectCheckInterval = 100 * time.Millisecond
+
+// WatchDisconnect возвращает контекст, который отменяется, когда клиент закрывает соединение. После обработки
+// запроса наблюдение нужно остановить вызовом stop
+func WatchDisconnect(request *fiber.Ctx) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := request.Context().Conn()
+	if conn == nil {
+		return ctx, cancel
+	}
+
+	go func() {
+		ticker := time.NewTicker(disconnectCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if connClosed(conn) {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package util
+
+import "net"
+
+// connClosed на платформах без MSG_PEEK не определяет отключение клиента
+func connClosed(conn net.Conn) bool {
+	return false
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package util
+
+import (
+	"net"
+	"syscall"
+)
+
+// connClosed проверяет, разорвал ли клиент соединение, не забирая данные из сокета. Пустое чтение означает только,
+// что клиент закрыл свою сторону на запись, и ответ ему ещё можно отправить, поэтому отключением считаются
+// лишь ECONNRESET и EPIPE
+func connClosed(conn net.Conn) bool {
+	syscallConn, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	rawConn, err := syscallConn.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	closed := false
+	buffer := make([]byte, 1)
+	err = rawConn.Read(func(fd uintptr) bool {
+		_, _, err := syscall.Recvfrom(int(fd), buffer, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		closed = err == syscall.ECONNRESET || err == syscall.EPIPE
+		return true
+	})
+	return closed || err != nil
+}
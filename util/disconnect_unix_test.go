@@ -0,0 +1,38 @@
+//go:build unix
+
+package util
+
+import (
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnClosed(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	connect := func() (*net.TCPConn, net.Conn) {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		server, err := listener.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = server.Close() })
+		return client.(*net.TCPConn), server
+	}
+
+	client, server := connect()
+	require.False(t, connClosed(server))
+
+	require.NoError(t, client.CloseWrite())
+	time.Sleep(50 * time.Millisecond)
+	require.False(t, connClosed(server), "Закрытие клиентом стороны записи не является отключением")
+
+	require.NoError(t, client.Close())
+
+	client, server = connect()
+	require.NoError(t, client.SetLinger(0))
+	require.NoError(t, client.Close())
+	require.Eventually(t, func() bool { return connClosed(server) }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+type FaultType string
+
+const (
+	// FaultReset - сброс TCP соединения без ответа
+	FaultReset FaultType = "reset"
+	// FaultClose - закрытие соединения без ответа
+	FaultClose FaultType = "close"
+	// FaultEmptyBody - ответ с кодом и заголовками, но без тела
+	FaultEmptyBody FaultType = "empty_body"
+	// FaultMalformedChunked - ответ с Transfer-Encoding: chunked и некорректным размером чанка
+	FaultMalformedChunked FaultType = "malformed_chunked"
+	// FaultTrickle - медленная отправка тела со скоростью BytesPerSecond
+	FaultTrickle FaultType = "trickle"
+)
+
+const trickleInterval = 100 * time.Millisecond
+
+type Fault struct {
+	Type FaultType `json:"type"`
+	// BytesPerSecond - скорость отправки тела для сбоя trickle
+	BytesPerSecond int64 `json:"bytes_per_second,omitempty"`
+}
+
+// SendFault отправляет ответ message, воспроизводя его сбой. Код ответа и заголовки должны быть уже установлены
+func SendFault(context *fiber.Ctx, message *Message) error {
+	switch message.Fault.Type {
+	case FaultReset:
+		conn := context.Context().Conn()
+		hijack(context, func(net.Conn) {
+			// Нулевой linger заставляет ядро отправить RST вместо FIN при закрытии
+			if tcpConn, ok := conn.(*net.TCPConn); ok {
+				_ = tcpConn.SetLinger(0)
+			}
+		})
+	case FaultClose:
+		hijack(context, func(net.Conn) {})
+	case FaultEmptyBody:
+		context.Response().ResetBody()
+	case FaultMalformedChunked:
+		statusCode := context.Response().StatusCode()
+		hijack(context, func(conn net.Conn) {
+			writer := bufio.NewWriter(conn)
+			_, _ = fmt.Fprintf(writer, "HTTP/1.1 %d %s\r\n", statusCode, http.StatusText(statusCode))
+			for key, value := range message.Headers {
+				_, _ = fmt.Fprintf(writer, "%s: %s\r\n", key, value)
+			}
+			// Размер чанка должен быть шестнадцатеричным числом
+			_, _ = fmt.Fprintf(writer, "Transfer-Encoding: chunked\r\n\r\nzz\r\n%s\r\n", message.Body)
+			_ = writer.Flush()
+		})
+	case FaultTrickle:
+		context.Context().SetBodyStreamWriter(trickleWriter([]byte(message.Body), message.Fault.BytesPerSecond))
+	default:
+		return context.SendString(message.Body)
+	}
+	return nil
+}
+
+// hijack забирает соединение у сервера без отправки ответа, после action соединение закрывается
+func hijack(context *fiber.Ctx, action func(conn net.Conn)) {
+	context.Context().HijackSetNoResponse(true)
+	context.Context().Hijack(action)
+}
+
+func trickleWriter(body []byte, bytesPerSecond int64) func(writer *bufio.Writer) {
+	chunkSize := int(bytesPerSecond * int64(trickleInterval) / int64(time.Second))
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	interval := time.Duration(int64(chunkSize) * int64(time.Second) / bytesPerSecond)
+
+	return func(writer *bufio.Writer) {
+		for start := 0; start < len(body); start += chunkSize {
+			if start > 0 {
+				time.Sleep(interval)
+			}
+			end := start + chunkSize
+			if end > len(body) {
+				end = len(body)
+			}
+			if _, err := writer.Write(body[start:end]); err != nil {
+				return
+			}
+			// Ошибка записи означает, что клиент отключился
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
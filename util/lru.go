@@ -0,0 +1,72 @@
+package util
+
+import "container/list"
+
+// LRU - словарь с ограниченным числом записей. При переполнении вытесняется запись, которая дольше всех
+// не использовалась. LRU не синхронизирован, доступ упорядочивает владелец
+type LRU[K comparable, V any] struct {
+	limit int
+	// order упорядочивает записи от недавно использованных к давно не использованным
+	order *list.List
+	items map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func NewLRU[K comparable, V any](limit int) *LRU[K, V] {
+	return &LRU[K, V]{
+		limit: limit,
+		order: list.New(),
+		items: make(map[K]*list.Element),
+	}
+}
+
+// Get возвращает значение по ключу и отмечает запись как использованную
+func (lru *LRU[K, V]) Get(key K) (V, bool) {
+	element, ok := lru.items[key]
+	if !ok {
+		var empty V
+		return empty, false
+	}
+	lru.order.MoveToFront(element)
+	return element.Value.(*lruEntry[K, V]).value, true
+}
+
+// Put сохраняет значение и отмечает запись как использованную, при переполнении вытесняет самую старую запись
+func (lru *LRU[K, V]) Put(key K, value V) {
+	if element, ok := lru.items[key]; ok {
+		element.Value.(*lruEntry[K, V]).value = value
+		lru.order.MoveToFront(element)
+		return
+	}
+	lru.items[key] = lru.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	if lru.order.Len() > lru.limit {
+		oldest := lru.order.Back()
+		lru.order.Remove(oldest)
+		delete(lru.items, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+// RemoveIf удаляет записи, ключи которых удовлетворяют match
+func (lru *LRU[K, V]) RemoveIf(match func(key K) bool) {
+	for key, element := range lru.items {
+		if match(key) {
+			lru.order.Remove(element)
+			delete(lru.items, key)
+		}
+	}
+}
+
+// Range вызывает action для каждой записи, не меняя порядок вытеснения
+func (lru *LRU[K, V]) Range(action func(key K, value V)) {
+	for key, element := range lru.items {
+		action(key, element.Value.(*lruEntry[K, V]).value)
+	}
+}
+
+func (lru *LRU[K, V]) Len() int {
+	return len(lru.items)
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	lru := NewLRU[string, int](2)
+	lru.Put("a", 1)
+	lru.Put("b", 2)
+	_, ok := lru.Get("a")
+	require.True(t, ok)
+	lru.Put("c", 3)
+	require.Equal(t, 2, lru.Len())
+	_, ok = lru.Get("b")
+	require.False(t, ok, "Давно не использованная запись вытеснена")
+	value, ok := lru.Get("a")
+	require.True(t, ok, "Недавно использованная запись не вытесняется")
+	require.Equal(t, 1, value)
+
+	lru.Put("a", 4)
+	lru.Put("d", 5)
+	value, _ = lru.Get("a")
+	require.Equal(t, 4, value, "Put обновляет значение и отмечает запись как использованную")
+
+	lru.RemoveIf(func(key string) bool { return key == "a" })
+	require.Equal(t, 1, lru.Len())
+	keys := make([]string, 0)
+	lru.Range(func(key string, value int) { keys = append(keys, key) })
+	require.Equal(t, []string{"d"}, keys)
+}
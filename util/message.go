@@ -1,6 +1,65 @@
 package util
 
+import (
+	"github.com/gofiber/fiber/v2"
+	"os"
+)
+
 type Message struct {
-	Body    string
-	Headers map[string]string
+	Body    string            `json:"body"`
+	Headers map[string]string `json:"headers"`
+	Method  string            `json:"method,omitempty"`
+	Path    string            `json:"path,omitempty"`
+	Query   map[string]string `json:"query,omitempty"`
+	// BodyFile - файл, содержимое которого отправляется потоком вместо Body
+	BodyFile string `json:"body_file,omitempty"`
+	// StatusCode - код ответа, 0 означает код по умолчанию
+	StatusCode int `json:"status_code,omitempty"`
+	// Fault - сетевой сбой, который воспроизводится вместо обычной отправки ответа
+	Fault *Fault `json:"fault,omitempty"`
+	// Variables - переменные сценария, вычисленные шагами script, доступны в шаблонах как плейсхолдеры
+	Variables map[string]string `json:"variables,omitempty"`
+	// States - состояния ключа входящего сообщения по автоматам, сценарий заполняет их для шаблонов gotemplate
+	States map[string]string `json:"-"`
+}
+
+// SendMessageBody отправляет тело сообщения, файл тела передаётся клиенту потоком без чтения в память
+func SendMessageBody(context *fiber.Ctx, message *Message) error {
+	if message.BodyFile == "" {
+		return context.SendString(message.Body)
+	}
+	file, err := os.Open(message.BodyFile)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	// fasthttp закрывает файл после отправки
+	context.Response().SetBodyStream(file, int(info.Size()))
+	return nil
+}
+
+// CreateMessageFromRequest копирует данные запроса в Message, чтобы сообщение можно было использовать после
+// завершения обработчика. pathParam - имя параметра маршрута с оставшейся частью пути
+func CreateMessageFromRequest(context *fiber.Ctx, pathParam string) *Message {
+	headers := make(map[string]string)
+	context.Request().Header.VisitAll(func(key, value []byte) {
+		headers[string(key)] = string(value)
+	})
+
+	query := make(map[string]string)
+	context.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		query[string(key)] = string(value)
+	})
+
+	return &Message{
+		Body:    string(context.Body()),
+		Headers: headers,
+		Method:  string([]byte(context.Method())),
+		Path:    string([]byte(context.Params(pathParam))),
+		Query:   query,
+	}
 }
@@ -0,0 +1,119 @@
+package util
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// XmlAttributePrefix и XmlTextKey - ключи объекта для атрибутов и текста элемента XML, у которого есть вложенные
+// элементы или атрибуты
+const XmlAttributePrefix = "-"
+const XmlTextKey = "#text"
+
+// ParseXml преобразует документ в объект с единственным полем - корневым элементом. Элемент без вложенных
+// элементов и атрибутов становится строкой, повторяющиеся элементы собираются в массив. Имена элементов
+// и атрибутов берутся без префиксов пространств имён, объявления xmlns отбрасываются
+func ParseXml(body string) (interface{}, error) {
+	return parseXml(body, false)
+}
+
+// ParseXmlPrefixed разбирает документ как ParseXml, но сохраняет префиксы имён и объявления xmlns
+// в атрибутах, чтобы по объекту можно было восстановить документ с теми же пространствами имён
+func ParseXmlPrefixed(body string) (interface{}, error) {
+	return parseXml(body, true)
+}
+
+// xmlParser в режиме prefixed читает токены без разрешения пространств имён, поэтому парность тегов
+// проверяет сам
+type xmlParser struct {
+	decoder  *xml.Decoder
+	prefixed bool
+}
+
+func parseXml(body string, prefixed bool) (interface{}, error) {
+	parser := &xmlParser{decoder: xml.NewDecoder(strings.NewReader(body)), prefixed: prefixed}
+	for {
+		token, err := parser.token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("документ не содержит элементов")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			value, err := parser.parseElement(start)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{parser.name(start.Name): value}, nil
+		}
+	}
+}
+
+func (parser *xmlParser) token() (xml.Token, error) {
+	if parser.prefixed {
+		return parser.decoder.RawToken()
+	}
+	return parser.decoder.Token()
+}
+
+func (parser *xmlParser) name(name xml.Name) string {
+	if parser.prefixed && name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+func (parser *xmlParser) parseElement(start xml.StartElement) (interface{}, error) {
+	children := make(map[string]interface{})
+	for _, attr := range start.Attr {
+		if parser.prefixed || attr.Name.Space != "xmlns" && attr.Name.Local != "xmlns" {
+			children[XmlAttributePrefix+parser.name(attr.Name)] = attr.Value
+		}
+	}
+
+	var text strings.Builder
+	for {
+		token, err := parser.token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := parser.parseElement(t)
+			if err != nil {
+				return nil, err
+			}
+			addXmlChild(children, parser.name(t.Name), child)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if t.Name != start.Name {
+				return nil, fmt.Errorf("элемент %s закрыт тегом %s", parser.name(start.Name), parser.name(t.Name))
+			}
+			trimmed := strings.TrimSpace(text.String())
+			if len(children) == 0 {
+				return trimmed, nil
+			}
+			if trimmed != "" {
+				children[XmlTextKey] = trimmed
+			}
+			return children, nil
+		}
+	}
+}
+
+func addXmlChild(children map[string]interface{}, name string, child interface{}) {
+	existing, ok := children[name]
+	if !ok {
+		children[name] = child
+		return
+	}
+	if list, ok := existing.([]interface{}); ok {
+		children[name] = append(list, child)
+		return
+	}
+	children[name] = []interface{}{existing, child}
+}